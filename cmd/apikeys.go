@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/validator"
+)
+
+// The createAPIKeyHandler() creates a key for a partner integration. The plaintext key
+// is only ever included in this response, since we just store its hash.
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name           string     `json:"name"`
+		Scopes         []string   `json:"scopes"`
+		RateLimitRPS   *float64   `json:"rate_limit_rps"`
+		RateLimitBurst *int       `json:"rate_limit_burst"`
+		ExpiresAt      *time.Time `json:"expires_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	key := &data.APIKey{
+		Name:           input.Name,
+		Scopes:         input.Scopes,
+		RateLimitRPS:   5,
+		RateLimitBurst: 10,
+		ExpiresAt:      input.ExpiresAt,
+		CreatedBy:      int64(app.contextGetUser(r).ID),
+	}
+	if input.RateLimitRPS != nil {
+		key.RateLimitRPS = *input.RateLimitRPS
+	}
+	if input.RateLimitBurst != nil {
+		key.RateLimitBurst = *input.RateLimitBurst
+	}
+
+	v := validator.New()
+
+	if data.ValidateAPIKey(v, key); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.APIKeys.InsertAudited(key, audit.FromContext(r.Context()))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/admin/api-keys/%d", key.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.models.APIKeys.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.APIKeys.RevokeAudited(id, audit.FromContext(r.Context()))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "API key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
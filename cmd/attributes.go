@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/validator"
+)
+
+// The showAttributeSchemaHandler() returns the attributes defined on the category itself
+// along with the effective ones, which include those of the categories above it.
+func (app *application) showAttributeSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	own, effective, err := app.models.AttributeSchemas.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"attributes": own, "effective_attributes": effective}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateAttributeSchemaHandler() replaces the attributes defined on the category. An
+// empty object removes them all.
+func (app *application) updateAttributeSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Attributes data.AttributeSchema `json:"attributes"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateAttributeSchema(v, input.Attributes); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.AttributeSchemas.SetAudited(id, input.Attributes, audit.FromContext(r.Context()))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	own, effective, err := app.models.AttributeSchemas.Get(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"attributes": own, "effective_attributes": effective}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/domain/entity"
+)
+
+// Define a custom contextKey type, with the underlying type string.
+type contextKey string
+
+// Convert the string "user" to a contextKey type and assign it to the userContextKey
+// constant. We'll use this constant as the key for getting and setting user information
+// in the request context.
+const userContextKey = contextKey("user")
+
+// The apiKeyContextKey is used for the API key of partner integrations. Requests which
+// are authenticated with an API key carry the AnonymousUser as their user.
+const apiKeyContextKey = contextKey("apiKey")
+
+// The enrollmentUserContextKey is used for staff who authenticated with a TOTP
+// enrollment token. Such requests carry the AnonymousUser as their user.
+const enrollmentUserContextKey = contextKey("enrollmentUser")
+
+// The contextSetUser() method returns a new copy of the request with the provided
+// User struct added to the context. Note that we use our userContextKey constant as the
+// key.
+func (app *application) contextSetUser(r *http.Request, user *entity.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// The contextGetUser() retrieves the User struct from the request context. The only
+// time that we'll use this helper is when we logically expect there to be User struct
+// value in the context, and if it doesn't exist it will firmly be an 'unexpected' error,
+// so it's OK to panic in those circumstances.
+func (app *application) contextGetUser(r *http.Request) *entity.User {
+	user, ok := r.Context().Value(userContextKey).(*entity.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+// The contextSetAPIKey() method returns a new copy of the request with the API key used
+// to authenticate it added to the context.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// The contextGetAPIKey() retrieves the API key from the request context. Unlike
+// contextGetUser() it returns nil when the request wasn't made with an API key.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, ok := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	if !ok {
+		return nil
+	}
+
+	return key
+}
+
+// The contextSetEnrollmentUser() method returns a new copy of the request with the user
+// of a TOTP enrollment token added to the context.
+func (app *application) contextSetEnrollmentUser(r *http.Request, user *entity.User) *http.Request {
+	ctx := context.WithValue(r.Context(), enrollmentUserContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// The contextGetEnrollmentUser() retrieves the user of a TOTP enrollment token from the
+// request context, or nil if the request wasn't made with one.
+func (app *application) contextGetEnrollmentUser(r *http.Request) *entity.User {
+	user, ok := r.Context().Value(enrollmentUserContextKey).(*entity.User)
+	if !ok {
+		return nil
+	}
+
+	return user
+}
@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"toy-rental-system/internal/validator"
 )
 
 // retrieve Id convert it to integer and return, otherwise return 0, error
@@ -188,27 +189,27 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 	return strings.Split(csv, ",")
 }
 
-//// The readInt() helper reads a string value from the query string and converts it to an
-//// integer before returning. If no matching key could be found it returns the provided
-//// default value. If the value couldn't be converted to an integer, then we record an
-//// error message in the provided Validator instance.
-//func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
-//	// Extract the value from the query string.
-//	s := qs.Get(key)
-//	// If no key exists (or the value is empty) then return the default value.
-//	if s == "" {
-//		return defaultValue
-//	}
-//	// Try to convert the value to an int. If this fails, add an error message to the
-//	// validator instance and return the default value.
-//	i, err := strconv.Atoi(s)
-//	if err != nil {
-//		v.AddError(key, "must be an integer value")
-//		return defaultValue
-//	}
-//	// Otherwise, return the converted integer value.
-//	return i
-//}
+// The readInt() helper reads a string value from the query string and converts it to an
+// integer before returning. If no matching key could be found it returns the provided
+// default value. If the value couldn't be converted to an integer, then we record an
+// error message in the provided Validator instance.
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	// Extract the value from the query string.
+	s := qs.Get(key)
+	// If no key exists (or the value is empty) then return the default value.
+	if s == "" {
+		return defaultValue
+	}
+	// Try to convert the value to an int. If this fails, add an error message to the
+	// validator instance and return the default value.
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+	// Otherwise, return the converted integer value.
+	return i
+}
 
 // The background() helper accepts an arbitrary function as a parameter.
 func (app *application) background(fn func()) {
@@ -227,46 +228,14 @@ func (app *application) background(fn func()) {
 	}()
 }
 
-//func (app *application) scheduleEmailSending(user *data.UserInfo) {
-//	ticker := time.NewTicker(30 * time.Second)
-//	defer ticker.Stop()
-//
-//	for {
-//		select {
-//		case <-ticker.C:
-//			// Fetch the latest user info from the database
-//			refreshedUser, err := app.models.UserInfo.GetByID(user.ID)
-//			if err != nil {
-//				app.logger.PrintError(err, nil)
-//				return
-//			}
-//
-//			if refreshedUser.Activated {
-//				return // Stop the ticker and goroutine if the user is activated
-//			}
-//
-//			// Delete all existing tokens for user
-//			err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, refreshedUser.ID)
-//			if err != nil {
-//				app.logger.PrintError(err, nil)
-//				return
-//			}
-//
-//			// Generate and send a new activation token
-//			token, err := app.models.Tokens.New(refreshedUser.ID, 40*time.Second, data.ScopeActivation)
-//			if err != nil {
-//				app.logger.PrintError(err, nil)
-//				return
-//			}
-//
-//			data := map[string]any{
-//				"activationToken": token.Plaintext,
-//				"userID":          refreshedUser.ID,
-//			}
-//			err = app.mailer.Send(refreshedUser.Email, "user_welcome.tmpl", data)
-//			if err != nil {
-//				app.logger.PrintError(err, nil)
-//			}
-//		}
-//	}
-//}
+// The sendEmail() helper sends an email in the background, so that a slow SMTP server
+// doesn't hold up the response. Errors are only logged, since the request itself has
+// succeeded by then.
+func (app *application) sendEmail(recipient, templateFile string, data any) {
+	app.background(func() {
+		err := app.mailer.Send(recipient, templateFile, data)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"template": templateFile})
+		}
+	})
+}
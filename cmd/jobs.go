@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// The runPeriodically() helper runs fn right away and then once every interval, for as
+// long as the application is running. Errors are logged, and the job is simply tried
+// again at the next tick. A zero interval disables the job.
+func (app *application) runPeriodically(name string, interval time.Duration, fn func() error) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			err := fn()
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"job": name})
+			}
+
+			<-ticker.C
+		}
+	}()
+}
+
+// purgeBatchSize is the number of toys purged in one transaction.
+const purgeBatchSize = 100
+
+// The purgeDeletedToys() job deletes the toys which have been in the trash for longer
+// than the retention period, and then the files of their images. A file which can't be
+// deleted is only logged, since its row is gone already.
+func (app *application) purgeDeletedToys() error {
+	cutoff := time.Now().Add(-app.config.toys.trashRetention)
+
+	for {
+		purged, keys, err := app.models.Toys.PurgeDeleted(cutoff, purgeBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err := app.images.Delete(ctx, key)
+			cancel()
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"job": "purge deleted toys", "key": key})
+			}
+		}
+
+		if purged > 0 {
+			app.logger.PrintInfo("purged deleted toys", map[string]string{"count": strconv.Itoa(purged)})
+		}
+		if purged < purgeBatchSize {
+			return nil
+		}
+	}
+}
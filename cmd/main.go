@@ -16,8 +16,13 @@ import (
 	"toy-rental-system/internal/api/handler"
 	"toy-rental-system/internal/config"
 	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/mailer"
+	"toy-rental-system/internal/ratelimit"
+	"toy-rental-system/internal/repository"
 	"toy-rental-system/internal/repository/postgres"
 	"toy-rental-system/internal/service"
+	"toy-rental-system/internal/storage"
+	"toy-rental-system/internal/thumbnail"
 	pkg "toy-rental-system/pkg/jsonlog"
 	"toy-rental-system/serviceToy"
 )
@@ -32,18 +37,58 @@ type configuration struct {
 		maxIdleTime  string
 	}
 	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+		rps            float64
+		burst          int
+		enabled        bool
+		trustedProxies ratelimit.TrustedProxies
+		login          struct {
+			rps   float64
+			burst int
+		}
+	}
+	search struct {
+		termsRefresh time.Duration
+	}
+	images struct {
+		dir     string
+		maxSize int64
+		// The thumbnails of uploaded images are generated in the background.
+		thumbnailsInterval    time.Duration
+		thumbnailsMaxAttempts int
+	}
+	toys struct {
+		// Deleted toys stay in the trash for the retention period before they are
+		// purged.
+		trashRetention time.Duration
+		purgeInterval  time.Duration
+	}
+	privacy struct {
+		// Account erasures are run by a background worker.
+		erasureInterval time.Duration
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+		sinkDir  string
 	}
 }
 
 type application struct {
-	config              configuration
-	subscriptionHandler *handler.SubscriptionHandler
-	toyHandler          *serviceToy.ToyService
-	logger              pkg.Logger
-	wg                  sync.WaitGroup
+	config        configuration
+	models        data.Models
+	subscriptions *service.SubscriptionService
+	toyHandler    *serviceToy.ToyService
+	images        *storage.Local
+	users         repository.UserRepository
+	userService   service.UserService
+	privacy       service.PrivacyService
+	userRouter    http.Handler
+	logger        *pkg.Logger
+	mailer        mailer.Mailer
+	wg            sync.WaitGroup
 }
 
 func main() {
@@ -69,6 +114,39 @@ func main() {
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.Float64Var(&cfg.limiter.login.rps, "limiter-login-rps", 0.2, "Rate limiter maximum requests per second for /login")
+	flag.IntVar(&cfg.limiter.login.burst, "limiter-login-burst", 5, "Rate limiter maximum burst for /login")
+
+	// Use the flag.Func() function to process the -limiter-trusted-proxies command line
+	// flag. The value is a comma-separated list of IP addresses or CIDR ranges of the
+	// reverse proxies whose X-Forwarded-For header can be trusted.
+	flag.Func("limiter-trusted-proxies", "Trusted reverse proxies (comma separated IPs or CIDR ranges)", func(val string) error {
+		proxies, err := ratelimit.ParseTrustedProxies(val)
+		if err != nil {
+			return err
+		}
+		cfg.limiter.trustedProxies = append(cfg.limiter.trustedProxies, proxies...)
+		return nil
+	})
+
+	flag.DurationVar(&cfg.search.termsRefresh, "search-terms-refresh", 5*time.Minute, "Interval for rebuilding the search suggestions vocabulary")
+
+	flag.StringVar(&cfg.images.dir, "images-dir", "uploads", "Directory for uploaded toy images")
+	flag.Int64Var(&cfg.images.maxSize, "images-max-size", 5<<20, "Maximum size of an uploaded toy image in bytes")
+	flag.DurationVar(&cfg.images.thumbnailsInterval, "images-thumbnails-interval", 10*time.Second, "Interval for generating the thumbnails of new toy images")
+	flag.IntVar(&cfg.images.thumbnailsMaxAttempts, "images-thumbnails-max-attempts", 5, "Attempts at generating the thumbnails of an image before giving up")
+
+	flag.DurationVar(&cfg.toys.trashRetention, "toys-trash-retention", 30*24*time.Hour, "How long deleted toys are kept in the trash")
+	flag.DurationVar(&cfg.toys.purgeInterval, "toys-purge-interval", time.Hour, "Interval for purging deleted toys after the retention period")
+
+	flag.DurationVar(&cfg.privacy.erasureInterval, "privacy-erasure-interval", 10*time.Second, "Interval for running requested account erasures")
+
+	flag.StringVar(&cfg.smtp.host, "smtp-host", env.SMTPHost, "SMTP host")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", env.SMTPPort, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", env.SMTPUsername, "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", env.SMTPPassword, "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", env.SMTPSender, "SMTP sender")
+	flag.StringVar(&cfg.smtp.sinkDir, "smtp-sink-dir", "", "Write outgoing emails to this directory instead of sending them (development)")
 
 	flag.Parse()
 
@@ -91,26 +169,66 @@ func main() {
 	logger.PrintInfo("RabbitMQ connection established", nil)
 
 	subscriptionRepo := postgres.NewSubscriptionRepository(db)
+	imageStore, err := storage.NewLocal(cfg.images.dir, "/images")
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	toysRepo := data.ToyModel{DB: db}
-	toyService := serviceToy.NewToyService(toysRepo)
+	toyService := serviceToy.NewToyService(toysRepo, data.ToyImageModel{DB: db}, imageStore, cfg.images.maxSize, logger)
 	subscriptionService := service.NewSubscriptionService(env, subscriptionRepo)
-	subscriptionHandler := handler.NewSubscriptionHandler(subscriptionService)
 	// Initialize repositories
 	userRepository := postgres.NewUserRepository(db)
+	loginAttemptRepository := postgres.NewLoginAttemptRepository(db)
+	twoFactorRepository := postgres.NewTwoFactorRepository(db)
 
 	// Initialize services
-	userService := service.NewUserService(userRepository)
+	userService := service.NewUserService(userRepository, data.TokenModel{DB: db}, loginAttemptRepository, twoFactorRepository, logger)
 
 	r := mux.NewRouter()
 	handler.NewUserHandler(r, userService)
 
+	// In development the emails can be written to a local directory instead of being
+	// sent through a real SMTP server.
+	var mailSender mailer.Sender = mailer.NewSMTPSender(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password)
+	if cfg.smtp.sinkDir != "" {
+		mailSender, err = mailer.NewFileSender(cfg.smtp.sinkDir)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
 	app := &application{
-		config:              cfg,
-		logger:              pkg.Logger{},
-		subscriptionHandler: subscriptionHandler,
-		toyHandler:          &toyService,
+		config:        cfg,
+		models:        data.NewModels(db),
+		users:         userRepository,
+		userService:   userService,
+		privacy:       service.NewPrivacyService(postgres.NewPrivacyRepository(db), logger),
+		userRouter:    r,
+		logger:        logger,
+		mailer:        mailer.New(mailSender, cfg.smtp.sender),
+		subscriptions: subscriptionService,
+		toyHandler:    &toyService,
+		images:        imageStore,
 	}
 
+	// New and changed toys show up in the search suggestions after the next refresh.
+	app.runPeriodically("refresh search terms", cfg.search.termsRefresh, app.models.Toys.RefreshSearchTerms)
+
+	thumbnails := &thumbnail.Worker{
+		Images:      app.models.ToyImages,
+		Storage:     imageStore,
+		Logger:      logger,
+		MaxAttempts: cfg.images.thumbnailsMaxAttempts,
+		BatchSize:   10,
+	}
+	app.runPeriodically("generate thumbnails", cfg.images.thumbnailsInterval, thumbnails.ProcessPending)
+
+	app.runPeriodically("purge deleted toys", cfg.toys.purgeInterval, app.purgeDeletedToys)
+
+	// Erasures interrupted by a restart are resumed once their lease runs out.
+	app.runPeriodically("process account erasures", cfg.privacy.erasureInterval, app.privacy.ProcessPendingErasures)
+
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.port),
 		Handler:      app.routes(),
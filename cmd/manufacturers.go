@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/validator"
+)
+
+func (app *application) listManufacturersHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+	name := app.readString(qs, "name", "")
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 24, v),
+		Sort:         app.readString(qs, "sort", "name"),
+		SortSafeList: []string{"id", "name", "country", "-id", "-name", "-country"},
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	manufacturers, metadata, err := app.models.Manufacturers.GetAll(name, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"manufacturers": manufacturers, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showManufacturerHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	manufacturer, err := app.models.Manufacturers.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"manufacturer": manufacturer}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listManufacturerToysHandler() is the toy listing limited to the toys of one
+// manufacturer, so it supports the same filters, sorting and pagination.
+func (app *application) listManufacturerToysHandler(listToys http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		_, err = app.models.Manufacturers.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = r.Clone(r.Context())
+		qs := r.URL.Query()
+		qs.Set("manufacturer_id", strconv.FormatInt(id, 10))
+		r.URL.RawQuery = qs.Encode()
+
+		listToys(w, r)
+	}
+}
+
+func (app *application) createManufacturerHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name          string `json:"name"`
+		Country       string `json:"country"`
+		Website       string `json:"website"`
+		SafetyContact string `json:"safety_contact"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	manufacturer := &data.Manufacturer{
+		Name:          input.Name,
+		Country:       input.Country,
+		Website:       input.Website,
+		SafetyContact: input.SafetyContact,
+	}
+
+	v := validator.New()
+
+	if data.ValidateManufacturer(v, manufacturer); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Manufacturers.InsertAudited(manufacturer, audit.FromContext(r.Context()))
+	if err != nil {
+		app.manufacturerErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/manufacturers/%d", manufacturer.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"manufacturer": manufacturer}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateManufacturerHandler() only changes the fields in the body. Renaming a
+// manufacturer renames it on its toys as well.
+func (app *application) updateManufacturerHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	manufacturer, err := app.models.Manufacturers.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name          *string `json:"name"`
+		Country       *string `json:"country"`
+		Website       *string `json:"website"`
+		SafetyContact *string `json:"safety_contact"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		manufacturer.Name = *input.Name
+	}
+	if input.Country != nil {
+		manufacturer.Country = *input.Country
+	}
+	if input.Website != nil {
+		manufacturer.Website = *input.Website
+	}
+	if input.SafetyContact != nil {
+		manufacturer.SafetyContact = *input.SafetyContact
+	}
+
+	v := validator.New()
+
+	if data.ValidateManufacturer(v, manufacturer); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Manufacturers.UpdateAudited(manufacturer, audit.FromContext(r.Context()))
+	if err != nil {
+		app.manufacturerErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"manufacturer": manufacturer}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteManufacturerHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Manufacturers.DeleteAudited(id, audit.FromContext(r.Context()))
+	if err != nil {
+		app.manufacturerErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "manufacturer successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) manufacturerErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	case errors.Is(err, data.ErrDuplicateManufacturer):
+		app.failedValidationResponse(w, r, map[string]string{"name": "is already used by another manufacturer"})
+	case errors.Is(err, data.ErrManufacturerInUse):
+		app.errorResponse(w, r, http.StatusConflict, "the manufacturer still has toys")
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/domain/entity"
+	"toy-rental-system/internal/ratelimit"
+	"toy-rental-system/internal/repository"
+	"toy-rental-system/internal/validator"
+)
+
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Add the "Vary: Authorization" header to the response. This indicates to any
+		// caches that the response may vary based on the value of the Authorization
+		// header in the request.
+		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+
+		// Partner integrations authenticate with an API key instead of a user token.
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			app.authenticateAPIKey(w, r, apiKey, next)
+			return
+		}
+
+		// Retrieve the value of the Authorization header from the request. This will
+		// return the empty string "" if there is no such header found.
+		authorizationHeader := r.Header.Get("Authorization")
+
+		// If there is no Authorization header found, use the contextSetUser() helper
+		// to add the AnonymousUser to the request context. Then we call the next handler
+		// in the chain and return without executing any of the code below.
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, entity.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Otherwise, we expect the value of the Authorization header to be in the format
+		// "Bearer <token>". We try to split this into its constituent parts, and if the
+		// header isn't in the expected format we return a 401 Unauthorized response.
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		// Extract the actual authentication token from the header parts.
+		token := headerParts[1]
+
+		// Validate the token to make sure it is in a sensible format.
+		v := validator.New()
+
+		// If the token isn't valid, use the invalidAuthenticationTokenResponse()
+		// helper to send a response, rather than the failedValidationResponse() helper
+		// that we'd normally use.
+		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		// Retrieve the details of the user associated with the authentication token,
+		// again calling the invalidAuthenticationTokenResponse() helper if no
+		// matching record was found.
+		user, err := app.users.GetForToken(data.ScopeAuthentication, token)
+		if errors.Is(err, repository.ErrUserNotFound) {
+			// Staff who haven't enrolled in two-factor authentication yet log in with
+			// an enrollment token. It's kept apart from the user in the context, so that
+			// it's only accepted by the handlers wrapped in requireTOTPUser().
+			user, err = app.users.GetForToken(data.ScopeTOTPEnrollment, token)
+			if err == nil {
+				r = app.contextSetEnrollmentUser(r, user)
+				user = entity.AnonymousUser
+			}
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, repository.ErrUserNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		// Call the contextSetUser() helper to add the user information to the request
+		// context.
+		r = app.contextSetUser(r, user)
+
+		// Call the next handler in the chain.
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The authenticateAPIKey() helper looks up the key sent in the X-API-Key header and adds
+// it to the request context, together with the AnonymousUser.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, plaintext string, next http.Handler) {
+	key, err := app.models.APIKeys.GetForPlaintext(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Update the usage counters in the background, so that the bookkeeping doesn't
+	// slow down the request itself.
+	app.background(func() {
+		err := app.models.APIKeys.RecordUsage(key.ID)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	r = app.contextSetUser(r, entity.AnonymousUser)
+	r = app.contextSetAPIKey(r, key)
+
+	next.ServeHTTP(w, r)
+}
+
+// The rateLimit() middleware must run after realIP() and authenticate(), so that
+// requests from authenticated users are limited by their user ID and anonymous requests
+// are limited by the client IP address. Requests made with an API key are limited by
+// the key, using the limits stored with it.
+func (app *application) rateLimit(l *ratelimit.Limiter, next http.Handler) http.Handler {
+	limited := l.Handler(func(r *http.Request) (string, float64, int) {
+		if apiKey := app.contextGetAPIKey(r); apiKey != nil {
+			return fmt.Sprintf("apikey:%d", apiKey.ID), apiKey.RateLimitRPS, apiKey.RateLimitBurst
+		}
+		if user := app.contextGetUser(r); !user.IsAnonymous() {
+			return fmt.Sprintf("user:%d", user.ID), l.RPS, l.Burst
+		}
+		return "ip:" + r.RemoteAddr, l.RPS, l.Burst
+	}, app.rateLimitExceededResponse, next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only carry out the check if rate limiting is enabled.
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limited.ServeHTTP(w, r)
+	})
+}
+
+// The limitFailedAuthentication() middleware must run after realIP() and before
+// authenticate(). Every request whose credentials are rejected takes a token from the
+// bucket of the client IP address, and once the bucket is empty the requests carrying
+// credentials are refused before they are checked at all. This slows down the guessing
+// of tokens and API keys, which rateLimit() can't do since it runs after authenticate().
+func (app *application) limitFailedAuthentication(l *ratelimit.Limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled || (r.Header.Get("Authorization") == "" && r.Header.Get("X-API-Key") == "") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := "ip:" + r.RemoteAddr
+
+		if delay := l.Delay(key); delay > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		if sw.status == http.StatusUnauthorized {
+			l.Allow(key, l.RPS, l.Burst)
+		}
+	})
+}
+
+// statusWriter records the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush it.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// The realIP() middleware replaces r.RemoteAddr with the IP address of the client, so
+// that the handlers and middleware further down the chain don't need to know anything
+// about proxies. X-Forwarded-For is only honoured behind the proxies listed in the
+// -limiter-trusted-proxies flag.
+func (app *application) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = app.config.limiter.trustedProxies.ClientIP(r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireAuthenticatedUser() middleware checks that a user is not anonymous.
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireStaff() middleware only lets staff and admin users through.
+func (app *application) requireStaff(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if !user.IsStaff() {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		// Tokens issued before two-factor authentication became mandatory are still
+		// around, so the enrollment is checked here as well as at login.
+		if !user.TOTPEnabled {
+			app.twoFactorRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	// Wrap this with the requireAuthenticatedUser() middleware before returning it.
+	return app.requireAuthenticatedUser(fn)
+}
+
+// The requireAdmin() middleware only lets admin users through.
+func (app *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.Role != entity.RoleAdmin {
+			app.notAdminResponse(w, r)
+			return
+		}
+
+		if !user.TOTPEnabled {
+			app.twoFactorRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+// The requireTOTPUser() middleware is used for the two-factor enrollment handlers. On
+// top of authenticated users it lets through staff who only hold an enrollment token,
+// and puts them into the context as the user.
+func (app *application) requireTOTPUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			enrollmentUser := app.contextGetEnrollmentUser(r)
+			if enrollmentUser == nil {
+				app.authenticationRequiredResponse(w, r)
+				return
+			}
+			r = app.contextSetUser(r, enrollmentUser)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireScope() middleware checks that a request made with an API key was granted
+// the given scope. Requests made by users (or anonymous clients) are let through, so
+// that the usual user checks still apply to them.
+func (app *application) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey := app.contextGetAPIKey(r)
+
+		if apiKey != nil && !apiKey.HasScope(scope) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireStaffOrScope() middleware guards the routes which change the catalog.
+// Requests made with an API key need the given scope, all others a staff user, so that
+// anonymous clients get a 401.
+func (app *application) requireStaffOrScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	staff := app.requireStaff(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.requireScope(scope, next).ServeHTTP(w, r)
+			return
+		}
+
+		staff.ServeHTTP(w, r)
+	})
+}
+
+// The requestID() middleware makes sure that every request has an ID, which is sent
+// back in the X-Request-ID header and recorded in the audit log. An ID set by a
+// proxy in front of us is reused, as long as it looks sensible.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+
+		if id == "" || len(id) > 64 || strings.ContainsAny(id, " \t\r\n") {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		r.Header.Set("X-Request-ID", id)
+		w.Header().Set("X-Request-ID", id)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The auditContext() middleware must run after authenticate(). It adds the audit actor,
+// which is who made the request and from where, to the request context so that the
+// models can record it together with any change.
+func (app *application) auditContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := audit.Actor{
+			IP:        r.RemoteAddr,
+			RequestID: r.Header.Get("X-Request-ID"),
+		}
+
+		if user := app.contextGetUser(r); !user.IsAnonymous() {
+			actor.UserID = int64(user.ID)
+		}
+		if apiKey := app.contextGetAPIKey(r); apiKey != nil {
+			actor.APIKeyID = apiKey.ID
+		}
+
+		r = r.WithContext(audit.NewContext(r.Context(), actor))
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"toy-rental-system/internal/repository"
+	"toy-rental-system/internal/service"
+)
+
+// The exportMeHandler() sends a ZIP archive with all the data we hold about the
+// authenticated user.
+func (app *application) exportMeHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	// Build the archive in memory first, so that a failure half way through results in
+	// a proper error response instead of a truncated download.
+	buf := new(bytes.Buffer)
+
+	err := app.privacy.Export(int64(user.ID), buf)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="toy-rental-export-%d.zip"`, user.ID))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// The deleteMeHandler() requests the erasure of the authenticated user's account. The
+// work is done by the erasure worker, so we respond with 202 Accepted and the job.
+func (app *application) deleteMeHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	job, err := app.privacy.RequestErasure(int64(user.ID))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrErasureInProgress):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", "/me/erasure")
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"erasure_job": job}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showMyErasureHandler() reports the status of the user's latest erasure job. Once
+// the job has completed the user's tokens are gone, so from then on only staff can see
+// the job through showErasureJobHandler().
+func (app *application) showMyErasureHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	job, err := app.privacy.LatestErasureJob(int64(user.ID))
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrErasureJobNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"erasure_job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showErasureJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := app.privacy.GetErasureJob(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrErasureJobNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"erasure_job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"toy-rental-system/helpers"
+	"toy-rental-system/internal/domain/entity"
+	"toy-rental-system/internal/repository"
+	"toy-rental-system/internal/validator"
+)
+
+func (app *application) showMeHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.userService.GetProfile(int64(app.contextGetUser(r).ID))
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user.Profile()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateMeHandler() applies a partial update to the profile of the authenticated
+// user. Fields which are missing from the request body are left unchanged, while the
+// addresses, when given, replace the whole list.
+func (app *application) updateMeHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.userService.GetProfile(int64(app.contextGetUser(r).ID))
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Email              *string           `json:"email"`
+		DisplayName        *string           `json:"display_name"`
+		Phone              *string           `json:"phone"`
+		Addresses          *[]entity.Address `json:"addresses"`
+		ContactPreferences *struct {
+			Email     *bool `json:"email"`
+			SMS       *bool `json:"sms"`
+			Marketing *bool `json:"marketing"`
+		} `json:"contact_preferences"`
+	}
+
+	err = helpers.ReadJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if input.Email != nil {
+		// Unlike the other fields, an email can't be removed again once it's been set.
+		user.Email = strings.TrimSpace(*input.Email)
+		entity.ValidateEmail(v, user.Email)
+	}
+	if input.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*input.DisplayName)
+	}
+	if input.Phone != nil {
+		user.Phone = strings.TrimSpace(*input.Phone)
+	}
+	if input.Addresses != nil {
+		user.Addresses = *input.Addresses
+	}
+	if prefs := input.ContactPreferences; prefs != nil {
+		if prefs.Email != nil {
+			user.ContactPreferences.Email = *prefs.Email
+		}
+		if prefs.SMS != nil {
+			user.ContactPreferences.SMS = *prefs.SMS
+		}
+		if prefs.Marketing != nil {
+			user.ContactPreferences.Marketing = *prefs.Marketing
+		}
+	}
+
+	// Text messages can't be sent without a phone number.
+	v.Check(!user.ContactPreferences.SMS || user.Phone != "", "contact_preferences.sms", "requires a phone number")
+
+	if entity.ValidateProfile(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.userService.UpdateProfile(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, repository.ErrUserNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user.Profile()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
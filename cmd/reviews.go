@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/validator"
+)
+
+// The listToyReviewsHandler() lists the reviews of a toy, the newest first unless
+// another sort is asked for.
+func (app *application) listToyReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 24, v),
+		Sort:         app.readString(qs, "sort", "-created_at"),
+		SortSafeList: []string{"created_at", "rating", "-created_at", "-rating"},
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Toys.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetAllForToy(id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createToyReviewHandler() lets a user who has returned the toy review it once.
+func (app *application) createToyReviewHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Rating int    `json:"rating"`
+		Body   string `json:"body"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	review := &data.Review{
+		ToyID:  id,
+		UserID: int64(app.contextGetUser(r).ID),
+		Rating: input.Rating,
+		Body:   input.Body,
+	}
+
+	v := validator.New()
+
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reviews.InsertAudited(review, audit.FromContext(r.Context()))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrNotReturned):
+			app.errorResponse(w, r, http.StatusForbidden, "only users who have returned the toy can review it")
+		case errors.Is(err, data.ErrDuplicateReview):
+			app.errorResponse(w, r, http.StatusConflict, "you have already reviewed this toy")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"review": review}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteToyReviewHandler() lets users delete their own reviews. Staff delete the
+// reviews of others through deleteReviewHandler().
+func (app *application) deleteToyReviewHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	reviewID, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("review_id"), 10, 64)
+	if err != nil || reviewID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	review, err := app.models.Reviews.Get(reviewID)
+	if err != nil || review.ToyID != id {
+		app.reviewErrorResponse(w, r, err)
+		return
+	}
+
+	if review.UserID != int64(app.contextGetUser(r).ID) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	app.deleteReview(w, r, review)
+}
+
+func (app *application) deleteReviewHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	review, err := app.models.Reviews.Get(id)
+	if err != nil {
+		app.reviewErrorResponse(w, r, err)
+		return
+	}
+
+	app.deleteReview(w, r, review)
+}
+
+func (app *application) deleteReview(w http.ResponseWriter, r *http.Request, review *data.Review) {
+	err := app.models.Reviews.DeleteAudited(review, audit.FromContext(r.Context()))
+	if err != nil {
+		app.reviewErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "review successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The recordToyReturnHandler() records that a user has returned the toy, which lets
+// them review it. Partner stores record returns with an API key.
+func (app *application) recordToyReturnHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		UserID int64 `json:"user_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.UserID > 0, "user_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ret := &data.ToyReturn{ToyID: id, UserID: input.UserID}
+
+	err = app.models.Reviews.RecordReturnAudited(ret, audit.FromContext(r.Context()))
+	if err != nil {
+		app.reviewErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"return": ret}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reviewErrorResponse also answers with 404 for a nil error, which the handlers use
+// for a review which belongs to another toy.
+func (app *application) reviewErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case err == nil, errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"github.com/julienschmidt/httprouter"
 	"net/http"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/ratelimit"
 )
 
 // Update the routes() method to return a http.Handler instead of a *httprouter.Router.
@@ -17,13 +19,90 @@ func (app *application) routes() http.Handler {
 	// likewise, convert to 405 error, basically making custom which is supported by http.Handler
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodPost, "/subscribe", app.subscriptionHandler.Subscribe)
-	router.HandlerFunc(http.MethodPost, "/toy", toysHandler.CreateToyHandler)
-	router.HandlerFunc(http.MethodGet, "/toy/:id", toysHandler.ShowToyHandler)
-	router.HandlerFunc(http.MethodGet, "/toys", toysHandler.ListToysHandler)
-	router.HandlerFunc(http.MethodDelete, "/toy/:id", toysHandler.DeleteToyHandler)
-	router.HandlerFunc(http.MethodPatch, "/toy/:id", toysHandler.UpdateToyHandler)
+	// Every client gets its own token bucket. The /login route has a separate and much
+	// stricter limiter on top of the global one, to slow down password guessing. Failed
+	// authentications with a token or an API key are limited just as strictly.
+	globalLimiter := ratelimit.New(app.config.limiter.rps, app.config.limiter.burst)
+	loginLimiter := ratelimit.New(app.config.limiter.login.rps, app.config.limiter.login.burst)
+	authLimiter := ratelimit.New(app.config.limiter.login.rps, app.config.limiter.login.burst)
 
-	return router
+	router.HandlerFunc(http.MethodPost, "/register", app.registerUserHandler)
+	router.Handler(http.MethodPost, "/login", app.rateLimit(loginLimiter, app.userRouter))
+	router.Handler(http.MethodPost, "/login/totp", app.rateLimit(loginLimiter, app.userRouter))
+	router.HandlerFunc(http.MethodPost, "/admin/users/:username/unlock", app.requireStaff(app.userRouter.ServeHTTP))
+
+	router.HandlerFunc(http.MethodPost, "/subscribe", app.requireAuthenticatedUser(app.createSubscriptionHandler))
+	router.HandlerFunc(http.MethodPost, "/subscriptions/:id/confirm", app.requireAuthenticatedUser(app.confirmSubscriptionHandler))
+
+	router.HandlerFunc(http.MethodGet, "/me", app.requireAuthenticatedUser(app.showMeHandler))
+	router.HandlerFunc(http.MethodPatch, "/me", app.requireAuthenticatedUser(app.updateMeHandler))
+	router.HandlerFunc(http.MethodGet, "/me/export", app.requireAuthenticatedUser(app.exportMeHandler))
+	router.HandlerFunc(http.MethodDelete, "/me", app.requireAuthenticatedUser(app.deleteMeHandler))
+	router.HandlerFunc(http.MethodGet, "/me/erasure", app.requireAuthenticatedUser(app.showMyErasureHandler))
+
+	router.HandlerFunc(http.MethodPost, "/me/totp", app.requireTOTPUser(app.enrollTOTPHandler))
+	router.HandlerFunc(http.MethodPost, "/me/totp/confirm", app.requireTOTPUser(app.confirmTOTPHandler))
+	router.HandlerFunc(http.MethodDelete, "/me/totp", app.requireAuthenticatedUser(app.disableTOTPHandler))
+	router.HandlerFunc(http.MethodPost, "/me/totp/recovery-codes", app.requireAuthenticatedUser(app.regenerateRecoveryCodesHandler))
+
+	// The catalog routes can also be called by partner integrations, as long as their
+	// API key carries the matching scope.
+	router.HandlerFunc(http.MethodPost, "/toy", app.requireStaffOrScope(data.ScopeCatalogWrite, toysHandler.CreateToyHandler))
+	router.HandlerFunc(http.MethodGet, "/toy/:id", app.requireScope(data.ScopeCatalogRead, toysHandler.ShowToyHandler))
+	router.HandlerFunc(http.MethodGet, "/toys", app.requireScope(data.ScopeCatalogRead, toysHandler.ListToysHandler))
+	router.HandlerFunc(http.MethodGet, "/toys/suggest", app.requireScope(data.ScopeCatalogRead, toysHandler.SuggestToysHandler))
+	router.HandlerFunc(http.MethodDelete, "/toy/:id", app.requireStaffOrScope(data.ScopeCatalogWrite, toysHandler.DeleteToyHandler))
+	router.HandlerFunc(http.MethodPatch, "/toy/:id", app.requireStaffOrScope(data.ScopeCatalogWrite, toysHandler.UpdateToyHandler))
+	router.HandlerFunc(http.MethodPost, "/toy/:id/restore", app.requireAdmin(toysHandler.RestoreToyHandler))
+
+	router.HandlerFunc(http.MethodGet, "/toy/:id/reviews", app.requireScope(data.ScopeCatalogRead, app.listToyReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/toy/:id/reviews", app.requireAuthenticatedUser(app.createToyReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/toy/:id/reviews/:review_id", app.requireAuthenticatedUser(app.deleteToyReviewHandler))
+	router.HandlerFunc(http.MethodPost, "/toy/:id/returns", app.requireStaffOrScope(data.ScopeRentalsWrite, app.recordToyReturnHandler))
+
+	router.HandlerFunc(http.MethodGet, "/toy/:id/images", app.requireScope(data.ScopeCatalogRead, toysHandler.ListToyImagesHandler))
+	router.HandlerFunc(http.MethodPost, "/toy/:id/images", app.requireStaffOrScope(data.ScopeCatalogWrite, toysHandler.UploadToyImageHandler))
+	router.HandlerFunc(http.MethodPatch, "/toy/:id/images", app.requireStaffOrScope(data.ScopeCatalogWrite, toysHandler.ArrangeToyImagesHandler))
+	router.HandlerFunc(http.MethodDelete, "/toy/:id/images/:image_id", app.requireStaffOrScope(data.ScopeCatalogWrite, toysHandler.DeleteToyImageHandler))
+
+	router.HandlerFunc(http.MethodGet, "/categories", app.requireScope(data.ScopeCatalogRead, app.listTermsHandler(app.models.Categories)))
+	router.HandlerFunc(http.MethodGet, "/categories/:id/attributes", app.requireScope(data.ScopeCatalogRead, app.showAttributeSchemaHandler))
+	router.HandlerFunc(http.MethodGet, "/skills", app.requireScope(data.ScopeCatalogRead, app.listTermsHandler(app.models.Skills)))
+
+	router.HandlerFunc(http.MethodGet, "/manufacturers", app.requireScope(data.ScopeCatalogRead, app.listManufacturersHandler))
+	router.HandlerFunc(http.MethodGet, "/manufacturers/:id", app.requireScope(data.ScopeCatalogRead, app.showManufacturerHandler))
+	router.HandlerFunc(http.MethodGet, "/manufacturers/:id/toys", app.requireScope(data.ScopeCatalogRead, app.listManufacturerToysHandler(toysHandler.ListToysHandler)))
+
+	// The uploaded images themselves are public, like the rest of a catalog page.
+	router.Handler(http.MethodGet, "/images/*filepath", app.images)
+
+	router.HandlerFunc(http.MethodGet, "/admin/api-keys", app.requireAdmin(app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/api-keys", app.requireAdmin(app.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/admin/api-keys/:id", app.requireAdmin(app.revokeAPIKeyHandler))
+
+	router.HandlerFunc(http.MethodPost, "/admin/categories", app.requireStaff(app.createTermHandler(app.models.Categories)))
+	router.HandlerFunc(http.MethodPatch, "/admin/categories/:id", app.requireStaff(app.updateTermHandler(app.models.Categories)))
+	router.HandlerFunc(http.MethodDelete, "/admin/categories/:id", app.requireStaff(app.deleteTermHandler(app.models.Categories)))
+	router.HandlerFunc(http.MethodPut, "/admin/categories/:id/attributes", app.requireStaff(app.updateAttributeSchemaHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/skills", app.requireStaff(app.createTermHandler(app.models.Skills)))
+	router.HandlerFunc(http.MethodPatch, "/admin/skills/:id", app.requireStaff(app.updateTermHandler(app.models.Skills)))
+	router.HandlerFunc(http.MethodDelete, "/admin/skills/:id", app.requireStaff(app.deleteTermHandler(app.models.Skills)))
+
+	router.HandlerFunc(http.MethodPost, "/admin/manufacturers", app.requireStaff(app.createManufacturerHandler))
+	router.HandlerFunc(http.MethodPatch, "/admin/manufacturers/:id", app.requireStaff(app.updateManufacturerHandler))
+	router.HandlerFunc(http.MethodDelete, "/admin/manufacturers/:id", app.requireStaff(app.deleteManufacturerHandler))
+
+	router.HandlerFunc(http.MethodPost, "/admin/toys/import", app.requireStaff(toysHandler.ImportToysHandler))
+	router.HandlerFunc(http.MethodGet, "/admin/toys/export", app.requireStaff(toysHandler.ExportToysHandler))
+	router.HandlerFunc(http.MethodGet, "/admin/toys/trash", app.requireAdmin(toysHandler.ListDeletedToysHandler))
+
+	router.HandlerFunc(http.MethodDelete, "/admin/reviews/:id", app.requireStaff(app.deleteReviewHandler))
+
+	router.HandlerFunc(http.MethodGet, "/admin/audit", app.requireStaff(app.listAuditEventsHandler))
+	router.HandlerFunc(http.MethodGet, "/admin/erasure-jobs/:id", app.requireStaff(app.showErasureJobHandler))
+
+	// Authenticate the request first, so that the rate limiter can tell authenticated
+	// users apart from anonymous clients.
+	return app.requestID(app.realIP(app.limitFailedAuthentication(authLimiter, app.authenticate(app.auditContext(app.rateLimit(globalLimiter, router))))))
 
 }
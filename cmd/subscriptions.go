@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/repository/postgres"
+	"toy-rental-system/internal/service"
+	"toy-rental-system/internal/validator"
+)
+
+// The createSubscriptionHandler() starts a subscription of the authenticated user to a
+// plan. The price comes from the plan, and the response carries the client secret of
+// the Stripe payment, which the client completes before confirming the subscription.
+func (app *application) createSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		PlanID int64 `json:"plan_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.PlanID > 0, "plan_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	subscription, err := app.subscriptions.Start(int64(user.ID), input.PlanID, audit.FromContext(r.Context()))
+	if err != nil {
+		switch {
+		case errors.Is(err, postgres.ErrPlanNotFound):
+			v.AddError("plan_id", "no such plan")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"subscription": subscription}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The confirmSubscriptionHandler() credits the tokens of one of the authenticated
+// user's subscriptions, once Stripe reports that it has been paid.
+func (app *application) confirmSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	subscription, err := app.subscriptions.Confirm(int64(user.ID), id, audit.FromContext(r.Context()))
+	if err != nil {
+		switch {
+		case errors.Is(err, postgres.ErrSubscriptionNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, service.ErrPaymentNotConfirmed):
+			app.errorResponse(w, r, http.StatusPaymentRequired, "the payment of this subscription hasn't succeeded yet")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"subscription": subscription}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/validator"
+)
+
+// The taxonomy handlers are shared by the categories and the skills, so they are built
+// for the model of either one.
+
+func (app *application) listTermsHandler(terms data.TaxonomyModel) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		all, err := terms.GetAll()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{string(terms.Kind): all}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// The createTermHandler() derives the slug from the name, unless one is given.
+func (app *application) createTermHandler(terms data.TaxonomyModel) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			ParentID     *int64            `json:"parent_id"`
+			Slug         string            `json:"slug"`
+			Name         string            `json:"name"`
+			Translations map[string]string `json:"translations"`
+		}
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		term := &data.Term{
+			ParentID:     input.ParentID,
+			Slug:         input.Slug,
+			Name:         input.Name,
+			Translations: input.Translations,
+		}
+		if term.Slug == "" {
+			term.Slug = data.Slugify(term.Name)
+		}
+
+		v := validator.New()
+
+		if data.ValidateTerm(v, term); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = terms.InsertAudited(term, audit.FromContext(r.Context()))
+		if err != nil {
+			app.termErrorResponse(w, r, err)
+			return
+		}
+
+		headers := make(http.Header)
+		headers.Set("Location", fmt.Sprintf("/admin/%s/%d", terms.Kind, term.ID))
+
+		err = app.writeJSON(w, http.StatusCreated, envelope{terms.Kind.Singular(): term}, headers)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// The updateTermHandler() only changes the fields in the body. A parent_id of 0 moves
+// the term to the top of the hierarchy. Renaming a term renames it on its toys as well.
+func (app *application) updateTermHandler(terms data.TaxonomyModel) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		term, err := terms.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		var input struct {
+			ParentID     *int64            `json:"parent_id"`
+			Slug         *string           `json:"slug"`
+			Name         *string           `json:"name"`
+			Translations map[string]string `json:"translations"`
+		}
+
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if input.ParentID != nil {
+			term.ParentID = input.ParentID
+			if *input.ParentID == 0 {
+				term.ParentID = nil
+			}
+		}
+		if input.Slug != nil {
+			term.Slug = *input.Slug
+		}
+		if input.Name != nil {
+			term.Name = *input.Name
+		}
+		if input.Translations != nil {
+			term.Translations = input.Translations
+		}
+
+		v := validator.New()
+
+		if data.ValidateTerm(v, term); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = terms.UpdateAudited(term, audit.FromContext(r.Context()))
+		if err != nil {
+			app.termErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{terms.Kind.Singular(): term}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) deleteTermHandler(terms data.TaxonomyModel) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		err = terms.DeleteAudited(id, audit.FromContext(r.Context()))
+		if err != nil {
+			app.termErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"message": terms.Kind.Singular() + " successfully deleted"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+func (app *application) termErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, data.ErrEditConflict):
+		app.errorResponse(w, r, http.StatusConflict, "unable to update the record due to an edit conflict, please try again")
+	case errors.Is(err, data.ErrDuplicateSlug):
+		app.failedValidationResponse(w, r, map[string]string{"slug": "is already used by another term"})
+	case errors.Is(err, data.ErrInvalidParent):
+		app.failedValidationResponse(w, r, map[string]string{"parent_id": "must be an existing term outside of this one"})
+	case errors.Is(err, data.ErrTermInUse):
+		app.errorResponse(w, r, http.StatusConflict, "the term still has toys or child terms")
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"toy-rental-system/internal/service"
+	"toy-rental-system/internal/validator"
+)
+
+// The enrollTOTPHandler() starts the two-factor enrollment and sends back the secret
+// together with the otpauth:// URI for the QR code.
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	enrollment, err := app.userService.EnrollTOTP(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrTOTPAlreadyEnabled):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"totp": enrollment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The confirmTOTPHandler() enables two-factor authentication once the user sends a
+// valid code from their app, and responds with the recovery codes.
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	code, ok := app.readTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := app.userService.ConfirmTOTP(app.contextGetUser(r), code)
+	if err != nil {
+		app.totpErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	code, ok := app.readTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.userService.DisableTOTP(app.contextGetUser(r), code)
+	if err != nil {
+		app.totpErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	code, ok := app.readTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := app.userService.RegenerateRecoveryCodes(app.contextGetUser(r), code)
+	if err != nil {
+		app.totpErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readTOTPCode() helper reads the code from the request body. If it's missing, an
+// error response has already been sent when ok is false.
+func (app *application) readTOTPCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return "", false
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return "", false
+	}
+
+	return input.Code, true
+}
+
+func (app *application) totpErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidTOTPCode):
+		app.failedValidationResponse(w, r, map[string]string{"code": "is invalid"})
+	case errors.Is(err, service.ErrTOTPAlreadyEnabled),
+		errors.Is(err, service.ErrTOTPNotEnrolled):
+		app.errorResponse(w, r, http.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrTOTPMandatory):
+		app.errorResponse(w, r, http.StatusForbidden, err.Error())
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
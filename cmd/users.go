@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"toy-rental-system/internal/domain/entity"
+	"toy-rental-system/internal/repository"
+	"toy-rental-system/internal/validator"
+)
+
+// The registerUserHandler() creates a new account and emails the user a welcome
+// message.
+func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Email    string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := &entity.User{
+		Username: strings.TrimSpace(input.Username),
+		Password: input.Password,
+		Email:    strings.TrimSpace(input.Email),
+		Role:     entity.RoleUser,
+	}
+
+	v := validator.New()
+
+	v.Check(user.Username != "", "username", "must be provided")
+	v.Check(user.Password != "", "password", "must be provided")
+	entity.ValidateEmail(v, user.Email)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.userService.Register(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.sendEmail(user.Email, "user_welcome.tmpl", map[string]any{
+		"username": user.Username,
+		"userID":   user.ID,
+	})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user.Profile()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
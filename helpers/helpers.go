@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 	"toy-rental-system/internal/validator"
 )
 
@@ -22,6 +23,48 @@ type Helpers interface {
 	ReadString(qs url.Values, key string, defaultValue string) string
 	ReadCSV(qs url.Values, key string, defaultValue []string) []string
 	ReadInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int
+	ReadBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool
+	ReadTime(qs url.Values, key string, v *validator.Validator) time.Time
+}
+
+// helpers is the default implementation of the Helpers interface, which simply calls
+// the package level functions.
+type helpers struct{}
+
+func New() Helpers {
+	return helpers{}
+}
+
+func (helpers) ReadIdParam(r *http.Request) (int64, error) {
+	return ReadIdParam(r)
+}
+
+func (helpers) WriteJSON(w http.ResponseWriter, status int, data interface{}, headers http.Header) error {
+	return WriteJSON(w, status, data, headers)
+}
+
+func (helpers) ReadJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	return ReadJSON(w, r, dst)
+}
+
+func (helpers) ReadString(qs url.Values, key string, defaultValue string) string {
+	return ReadString(qs, key, defaultValue)
+}
+
+func (helpers) ReadCSV(qs url.Values, key string, defaultValue []string) []string {
+	return ReadCSV(qs, key, defaultValue)
+}
+
+func (helpers) ReadInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	return ReadInt(qs, key, defaultValue, v)
+}
+
+func (helpers) ReadBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	return ReadBool(qs, key, defaultValue, v)
+}
+
+func (helpers) ReadTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	return ReadTime(qs, key, v)
 }
 
 func ReadIdParam(r *http.Request) (int64, error) {
@@ -210,3 +253,39 @@ func ReadInt(qs url.Values, key string, defaultValue int, v *validator.Validator
 	// Otherwise, return the converted integer value.
 	return i
 }
+
+// The ReadBool() helper reads a boolean value from the query string. It accepts the
+// same values as strconv.ParseBool(), so "true", "1", "false" and "0" all work.
+func ReadBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// The ReadTime() helper reads a timestamp from the query string, either as an RFC 3339
+// timestamp or as a plain date. The zero time is returned if the key is missing.
+func ReadTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t
+		}
+	}
+
+	v.AddError(key, "must be an RFC 3339 timestamp or a date")
+	return time.Time{}
+}
@@ -3,9 +3,12 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
+	"net"
 	"net/http"
-	"toy-rental-system/internal/domain/entity"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/repository"
 	"toy-rental-system/internal/service"
 )
 
@@ -17,42 +20,86 @@ func NewUserHandler(r *mux.Router, us service.UserService) {
 	handler := &UserHandler{
 		userService: us,
 	}
-	r.HandleFunc("/register", handler.Register).Methods("POST")
 	r.HandleFunc("/login", handler.Login).Methods("POST")
+	r.HandleFunc("/login/totp", handler.LoginTOTP).Methods("POST")
+	r.HandleFunc("/admin/users/{username}/unlock", handler.Unlock).Methods("POST")
 }
 
-func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
-	var user entity.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var creds struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := h.userService.Register(&user); err != nil {
+	// The client IP is used to count failed attempts across different usernames.
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	result, err := h.userService.Login(creds.Username, creds.Password, ip)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	// The scope of the token tells the client whether it's logged in, or still has to
+	// send a TOTP code to /login/totp or enroll first.
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
 }
 
-func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
-	var creds struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+func (h *UserHandler) LoginTOTP(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token string `json:"token"`
+		Code  string `json:"code"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	token, err := h.userService.Login(creds.Username, creds.Password)
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		ip = r.RemoteAddr
+	}
+
+	result, err := h.userService.LoginTOTP(input.Token, input.Code, ip)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(token))
+	json.NewEncoder(w).Encode(result)
 }
 
+func (h *UserHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+
+	if err := h.userService.Unlock(username, audit.FromContext(r.Context())); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "account unlocked"})
+}
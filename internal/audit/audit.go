@@ -0,0 +1,177 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// Actor describes who made a change and where the request came from. It's added to
+// the request context by the middleware, and passed down to the models which record
+// the audit events.
+type Actor struct {
+	UserID    int64
+	APIKeyID  int64
+	IP        string
+	RequestID string
+}
+
+type contextKey string
+
+const actorContextKey = contextKey("auditActor")
+
+// NewContext returns a copy of ctx carrying the actor.
+func NewContext(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorContextKey, actor)
+}
+
+// FromContext returns the actor stored in ctx, or an empty Actor if there is none.
+func FromContext(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorContextKey).(Actor)
+	return actor
+}
+
+// Event is a single entry of the append-only audit log.
+type Event struct {
+	ID            int64           `json:"id"`
+	CreatedAt     time.Time       `json:"created_at"`
+	ActorUserID   *int64          `json:"actor_user_id,omitempty"`
+	ActorAPIKeyID *int64          `json:"actor_api_key_id,omitempty"`
+	Action        string          `json:"action"`
+	TargetType    string          `json:"target_type"`
+	TargetID      string          `json:"target_id"`
+	Before        json.RawMessage `json:"before,omitempty"`
+	After         json.RawMessage `json:"after,omitempty"`
+	Changes       json.RawMessage `json:"changes,omitempty"`
+	IP            string          `json:"ip"`
+	RequestID     string          `json:"request_id"`
+}
+
+// NewEvent builds an event for the given change. Before is nil for a newly created
+// target and after is nil for a deleted one. Both are stored as JSON, together with a
+// diff of the top-level fields which changed.
+func NewEvent(actor Actor, action, targetType, targetID string, before, after any) (*Event, error) {
+	e := &Event{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IP:         actor.IP,
+		RequestID:  actor.RequestID,
+	}
+	if actor.UserID != 0 {
+		e.ActorUserID = &actor.UserID
+	}
+	if actor.APIKeyID != 0 {
+		e.ActorAPIKeyID = &actor.APIKeyID
+	}
+
+	var err error
+
+	e.Before, err = marshal(before)
+	if err != nil {
+		return nil, err
+	}
+
+	e.After, err = marshal(after)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Changes, err = diff(e.Before, e.After)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func marshal(v any) (json.RawMessage, error) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Pointer && reflect.ValueOf(v).IsNil()) {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// diff compares the top-level fields of two JSON objects and returns an object which
+// maps every changed field to its old and new value.
+func diff(before, after json.RawMessage) (json.RawMessage, error) {
+	beforeFields := map[string]any{}
+	afterFields := map[string]any{}
+
+	if before != nil {
+		if err := json.Unmarshal(before, &beforeFields); err != nil {
+			return nil, err
+		}
+	}
+	if after != nil {
+		if err := json.Unmarshal(after, &afterFields); err != nil {
+			return nil, err
+		}
+	}
+
+	type change struct {
+		Before any `json:"before"`
+		After  any `json:"after"`
+	}
+	changes := map[string]change{}
+
+	for key, value := range beforeFields {
+		if !reflect.DeepEqual(value, afterFields[key]) {
+			changes[key] = change{Before: value, After: afterFields[key]}
+		}
+	}
+	for key, value := range afterFields {
+		if _, found := beforeFields[key]; !found {
+			changes[key] = change{After: value}
+		}
+	}
+
+	return json.Marshal(changes)
+}
+
+// Insert records the event inside tx, so that it's committed or rolled back together
+// with the change it describes.
+func Insert(ctx context.Context, tx *sql.Tx, e *Event) error {
+	query := `
+INSERT INTO audit_events (actor_user_id, actor_api_key_id, action, target_type, target_id, before, after, changes, ip, request_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING id, created_at`
+
+	args := []any{e.ActorUserID, e.ActorAPIKeyID, e.Action, e.TargetType, e.TargetID, nullJSON(e.Before), nullJSON(e.After), nullJSON(e.Changes), e.IP, e.RequestID}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&e.ID, &e.CreatedAt)
+}
+
+// Run executes fn inside a transaction and records the event returned by it in the
+// same transaction. If fn or the insert fails, the whole transaction is rolled back.
+func Run(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) (*Event, error)) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	e, err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	err = Insert(ctx, tx, e)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// nullJSON turns an empty message into a SQL NULL instead of an empty string, which
+// isn't valid jsonb.
+func nullJSON(m json.RawMessage) any {
+	if m == nil {
+		return nil
+	}
+	return []byte(m)
+}
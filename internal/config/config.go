@@ -12,8 +12,11 @@ type Config struct {
 	StripePublishable string `mapstructure:"STRIPE_PUBLISHABLE"`
 	StripeSecret      string `mapstructure:"STRIPE_SECRET"`
 
+	SMTPHost     string `mapstructure:"SMTP_HOST"`
+	SMTPPort     int    `mapstructure:"SMTP_PORT"`
 	SMTPUsername string `mapstructure:"SMTP_USERNAME"`
 	SMTPPassword string `mapstructure:"SMTP_PASSWORD"`
+	SMTPSender   string `mapstructure:"SMTP_SENDER"`
 
 	RabbitMQSource string `mapstructure:"RABBITMQ_SOURCE"`
 }
@@ -0,0 +1,295 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"github.com/lib/pq"
+	"strconv"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/validator"
+)
+
+// Scopes which can be granted to an API key. A key can only call the routes which
+// require one of its scopes.
+const (
+	ScopeCatalogRead  = "catalog:read"
+	ScopeCatalogWrite = "catalog:write"
+	ScopeRentalsWrite = "rentals:write"
+)
+
+// Every plaintext key starts with this marker, which makes leaked keys easy to spot.
+const apiKeyMarker = "trk_"
+
+var APIKeyScopes = []string{ScopeCatalogRead, ScopeCatalogWrite, ScopeRentalsWrite}
+
+// APIKey is a credential for partner integrations, such as daycare centres and partner
+// stores. Only the hash of the key is stored, the plaintext is shown once on creation.
+type APIKey struct {
+	ID             int64      `json:"id"`
+	Name           string     `json:"name"`
+	Prefix         string     `json:"prefix"`
+	Plaintext      string     `json:"key,omitempty"`
+	Hash           []byte     `json:"-"`
+	Scopes         []string   `json:"scopes"`
+	RateLimitRPS   float64    `json:"rate_limit_rps"`
+	RateLimitBurst int        `json:"rate_limit_burst"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	CreatedBy      int64      `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+	UsageCount     int64      `json:"usage_count"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+}
+
+// HasScope reports whether the key was granted the given scope.
+func (k *APIKey) HasScope(scope string) bool {
+	return validator.PermittedValue(scope, k.Scopes...)
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(len(key.Scopes) >= 1, "scopes", "must contain at least 1 scope")
+	v.Check(validator.Unique(key.Scopes), "scopes", "must not contain duplicate values")
+	for _, scope := range key.Scopes {
+		v.Check(validator.PermittedValue(scope, APIKeyScopes...), "scopes", "contains an unknown scope")
+	}
+	v.Check(key.RateLimitRPS > 0, "rate_limit_rps", "must be more than zero")
+	v.Check(key.RateLimitRPS <= 100, "rate_limit_rps", "must not be more than 100")
+	v.Check(key.RateLimitBurst > 0, "rate_limit_burst", "must be more than zero")
+	v.Check(key.RateLimitBurst <= 200, "rate_limit_burst", "must not be more than 200")
+	if key.ExpiresAt != nil {
+		v.Check(key.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	}
+}
+
+// generateAPIKey fills in the plaintext, prefix and hash of a new key. The plaintext
+// looks like "trk_" followed by 32 base-32 characters.
+func generateAPIKey(key *APIKey) error {
+	randomBytes := make([]byte, 20)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	key.Plaintext = apiKeyMarker + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	key.Prefix = key.Plaintext[:len(apiKeyMarker)+6]
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	return nil
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+type APIKeyRepository interface {
+	Insert(key *APIKey) error
+	InsertAudited(key *APIKey, actor audit.Actor) error
+	GetForPlaintext(plaintext string) (*APIKey, error)
+	GetAll() ([]*APIKey, error)
+	Revoke(id int64) error
+	RevokeAudited(id int64, actor audit.Actor) error
+	RecordUsage(id int64) error
+}
+
+// Insert generates a new key and stores its hash. The plaintext is left in
+// key.Plaintext, so that it can be shown to the admin exactly once.
+func (m APIKeyModel) Insert(key *APIKey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertAPIKey(ctx, m.DB, key)
+}
+
+// InsertAudited works like Insert, and records an "api_key.create" audit event in the
+// same transaction. The plaintext key is left out of the event.
+func (m APIKeyModel) InsertAudited(key *APIKey, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return audit.Run(ctx, m.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		err := insertAPIKey(ctx, tx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		after := *key
+		after.Plaintext = ""
+		return audit.NewEvent(actor, "api_key.create", "api_key", strconv.FormatInt(key.ID, 10), nil, after)
+	})
+}
+
+func insertAPIKey(ctx context.Context, q querier, key *APIKey) error {
+	err := generateAPIKey(key)
+	if err != nil {
+		return err
+	}
+
+	query := `
+INSERT INTO api_keys (name, prefix, hash, scopes, rate_limit_rps, rate_limit_burst, expires_at, created_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, created_at`
+
+	args := []any{key.Name, key.Prefix, key.Hash, pq.Array(key.Scopes), key.RateLimitRPS, key.RateLimitBurst, key.ExpiresAt, key.CreatedBy}
+
+	return q.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+// GetForPlaintext returns the active (not revoked and not expired) key matching the
+// plaintext sent by the client.
+func (m APIKeyModel) GetForPlaintext(plaintext string) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+SELECT id, name, prefix, scopes, rate_limit_rps, rate_limit_burst, expires_at, created_by, created_at, last_used_at, usage_count, revoked_at
+FROM api_keys
+WHERE hash = $1
+AND revoked_at IS NULL
+AND (expires_at IS NULL OR expires_at > now())`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	key, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, hash[:]))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+func (m APIKeyModel) GetAll() ([]*APIKey, error) {
+	query := `
+SELECT id, name, prefix, scopes, rate_limit_rps, rate_limit_burst, expires_at, created_by, created_at, last_used_at, usage_count, revoked_at
+FROM api_keys
+ORDER BY id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (m APIKeyModel) Revoke(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return revokeAPIKey(ctx, m.DB, id)
+}
+
+// RevokeAudited works like Revoke, and records an "api_key.revoke" audit event in the
+// same transaction.
+func (m APIKeyModel) RevokeAudited(id int64, actor audit.Actor) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return audit.Run(ctx, m.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		err := revokeAPIKey(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, "api_key.revoke", "api_key", strconv.FormatInt(id, 10), nil, nil)
+	})
+}
+
+func revokeAPIKey(ctx context.Context, q querier, id int64) error {
+	query := `
+UPDATE api_keys
+SET revoked_at = now()
+WHERE id = $1 AND revoked_at IS NULL`
+
+	result, err := q.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// RecordUsage bumps the usage counter and the last used time of a key.
+func (m APIKeyModel) RecordUsage(id int64) error {
+	query := `
+UPDATE api_keys
+SET usage_count = usage_count + 1, last_used_at = now()
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+func scanAPIKey(row scanner) (*APIKey, error) {
+	var key APIKey
+
+	err := row.Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Scopes),
+		&key.RateLimitRPS,
+		&key.RateLimitBurst,
+		&key.ExpiresAt,
+		&key.CreatedBy,
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.UsageCount,
+		&key.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
@@ -0,0 +1,424 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/validator"
+)
+
+// AttributeNameRX matches the names of attributes. They end up in the JSON paths of
+// the attribute filters, so they're kept to a safe set of characters.
+var AttributeNameRX = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+
+type AttributeType string
+
+const (
+	AttributeInteger AttributeType = "integer"
+	AttributeNumber  AttributeType = "number"
+	AttributeBoolean AttributeType = "boolean"
+	AttributeString  AttributeType = "string"
+	AttributeEnum    AttributeType = "enum"
+	AttributeList    AttributeType = "list"
+)
+
+var AttributeTypes = []AttributeType{AttributeInteger, AttributeNumber, AttributeBoolean, AttributeString, AttributeEnum, AttributeList}
+
+// AttributeDefinition describes one attribute. Values are the permitted values of an
+// enum, and of the items of a list if there are any. Min and Max only apply to numbers.
+// Unit is for display, e.g. "g" for a weight.
+type AttributeDefinition struct {
+	Type   AttributeType `json:"type"`
+	Unit   string        `json:"unit,omitempty"`
+	Values []string      `json:"values,omitempty"`
+	Min    *float64      `json:"min,omitempty"`
+	Max    *float64      `json:"max,omitempty"`
+}
+
+// AttributeSchema maps the names of the attributes of a category to their definition,
+// for example
+//
+//	{
+//	  "piece_count":  {"type": "integer", "min": 1},
+//	  "battery_type": {"type": "enum", "values": ["none", "AA", "AAA", "rechargeable"]},
+//	  "width_cm":     {"type": "number", "unit": "cm"},
+//	  "weight_g":     {"type": "number", "unit": "g"},
+//	  "materials":    {"type": "list", "values": ["wood", "plastic", "fabric", "metal"]},
+//	  "noise_level":  {"type": "enum", "values": ["silent", "quiet", "loud"]}
+//	}
+//
+// A category has the attributes of its own schema and those of the categories above
+// it, and a toy may have the attributes of all its categories. Attributes are optional.
+type AttributeSchema map[string]AttributeDefinition
+
+func ValidateAttributeSchema(v *validator.Validator, schema AttributeSchema) {
+	v.Check(len(schema) <= 50, "attributes", "must not have more than 50 attributes")
+
+	for name, def := range schema {
+		key := "attributes." + name
+		v.Check(validator.Matches(name, AttributeNameRX), key, "name must be lowercase letters, digits and underscores, starting with a letter")
+		v.Check(validator.PermittedValue(def.Type, AttributeTypes...), key, "type must be integer, number, boolean, string, enum or list")
+		v.Check(len(def.Unit) <= 20, key, "unit must not be more than 20 bytes long")
+		v.Check(def.Type != AttributeEnum || len(def.Values) > 0, key, "values must be provided for an enum")
+		v.Check(def.Type == AttributeEnum || def.Type == AttributeList || len(def.Values) == 0, key, "values are only allowed for enums and lists")
+		v.Check(validator.Unique(def.Values), key, "values must not contain duplicates")
+
+		numeric := def.Type == AttributeInteger || def.Type == AttributeNumber
+		v.Check(numeric || (def.Min == nil && def.Max == nil), key, "min and max are only allowed for numbers")
+		v.Check(def.Min == nil || def.Max == nil || *def.Min <= *def.Max, key, "min must not be more than max")
+	}
+}
+
+// Merge returns the attributes of both schemas. Those of other replace those of s
+// with the same name.
+func (s AttributeSchema) Merge(other AttributeSchema) AttributeSchema {
+	merged := AttributeSchema{}
+	for name, def := range s {
+		merged[name] = def
+	}
+	for name, def := range other {
+		merged[name] = def
+	}
+	return merged
+}
+
+// Attributes are the typed specifications of a toy, stored as JSONB. Numbers are
+// float64, as decoded from JSON.
+type Attributes map[string]any
+
+func (a Attributes) Value() (driver.Value, error) {
+	if a == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(a)
+}
+
+func (a *Attributes) Scan(src any) error {
+	var b []byte
+	switch src := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case []byte:
+		b = src
+	case string:
+		b = []byte(src)
+	default:
+		return fmt.Errorf("cannot scan %T into Attributes", src)
+	}
+	return json.Unmarshal(b, a)
+}
+
+// ValidateAttributes checks the attributes of a toy against the schema of its
+// categories.
+func ValidateAttributes(v *validator.Validator, schema AttributeSchema, attributes Attributes) {
+	for name, value := range attributes {
+		key := "attributes." + name
+
+		def, ok := schema[name]
+		if !ok {
+			v.AddError(key, "is not an attribute of the toy's categories")
+			continue
+		}
+
+		switch def.Type {
+		case AttributeInteger, AttributeNumber:
+			n, ok := value.(float64)
+			switch {
+			case !ok:
+				v.AddError(key, "must be a number")
+			case def.Type == AttributeInteger && n != math.Trunc(n):
+				v.AddError(key, "must be an integer")
+			case def.Min != nil && n < *def.Min:
+				v.AddError(key, fmt.Sprintf("must not be less than %g", *def.Min))
+			case def.Max != nil && n > *def.Max:
+				v.AddError(key, fmt.Sprintf("must not be more than %g", *def.Max))
+			}
+		case AttributeBoolean:
+			_, ok := value.(bool)
+			v.Check(ok, key, "must be true or false")
+		case AttributeString:
+			s, ok := value.(string)
+			v.Check(ok && s != "" && len(s) <= 200, key, "must be a string of 1 to 200 bytes")
+		case AttributeEnum:
+			s, ok := value.(string)
+			v.Check(ok && validator.PermittedValue(s, def.Values...), key, "must be one of "+strings.Join(def.Values, ", "))
+		case AttributeList:
+			items, ok := value.([]any)
+			if !ok {
+				v.AddError(key, "must be a list of strings")
+				continue
+			}
+			values := make([]string, 0, len(items))
+			for _, item := range items {
+				s, ok := item.(string)
+				switch {
+				case !ok || s == "":
+					v.AddError(key, "must be a list of strings")
+				case len(def.Values) > 0 && !validator.PermittedValue(s, def.Values...):
+					v.AddError(key, "must only contain "+strings.Join(def.Values, ", "))
+				}
+				values = append(values, s)
+			}
+			v.Check(validator.Unique(values), key, "must not contain duplicates")
+		}
+	}
+}
+
+// AttributeError is returned when the attributes of a toy don't match the schema of
+// its categories. Errors are keyed like those of a validator.
+type AttributeError struct {
+	Errors map[string]string
+}
+
+func (e *AttributeError) Error() string {
+	return "invalid attributes"
+}
+
+// loadAttributeSchemas returns the schema of each of the categories, including the
+// attributes of the categories above it.
+func loadAttributeSchemas(ctx context.Context, q querier, categoryIDs []int64) (map[int64]AttributeSchema, error) {
+	schemas := map[int64]AttributeSchema{}
+	if len(categoryIDs) == 0 {
+		return schemas, nil
+	}
+
+	query := `
+WITH RECURSIVE tree AS (
+	SELECT id AS category_id, parent_id, attribute_schema, 0 AS depth FROM categories WHERE id = ANY($1)
+	UNION ALL
+	SELECT tree.category_id, c.parent_id, c.attribute_schema, tree.depth + 1 FROM categories c JOIN tree ON c.id = tree.parent_id
+)
+SELECT category_id, attribute_schema FROM tree ORDER BY category_id, depth DESC`
+
+	rows, err := q.QueryContext(ctx, query, pq.Array(categoryIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// The categories further up come first, so that a subcategory can redefine their
+	// attributes.
+	for rows.Next() {
+		var id int64
+		var raw []byte
+
+		err := rows.Scan(&id, &raw)
+		if err != nil {
+			return nil, err
+		}
+
+		var schema AttributeSchema
+		err = json.Unmarshal(raw, &schema)
+		if err != nil {
+			return nil, err
+		}
+		schemas[id] = schemas[id].Merge(schema)
+	}
+	return schemas, rows.Err()
+}
+
+// validateToyAttributes checks the attributes of the toys against the schemas of their
+// categories, whose IDs resolveToyTerms has found.
+func validateToyAttributes(ctx context.Context, q querier, terms *toyTerms, toys ...*Toy) error {
+	ids := []int64{}
+	for i, toy := range toys {
+		if len(toy.Attributes) > 0 {
+			ids = append(ids, terms.categories[i]...)
+		}
+	}
+
+	// Attributes are optional, so toys without any don't need the schemas.
+	if len(ids) == 0 {
+		return nil
+	}
+
+	schemas, err := loadAttributeSchemas(ctx, q, ids)
+	if err != nil {
+		return err
+	}
+
+	for i, toy := range toys {
+		schema := AttributeSchema{}
+		for _, id := range terms.categories[i] {
+			schema = schema.Merge(schemas[id])
+		}
+
+		v := validator.New()
+		if ValidateAttributes(v, schema, toy.Attributes); !v.Valid() {
+			return &AttributeError{Errors: v.Errors}
+		}
+	}
+	return nil
+}
+
+// AttributeSchemas returns the schemas of the given categories, keyed by slug. The
+// schema of a toy is the merge of those of its categories.
+func (t ToyModel) AttributeSchemas(categories []string) (map[string]AttributeSchema, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	terms, err := loadTerms(ctx, t.DB, TaxonomyCategories, categories)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(terms))
+	for _, ref := range terms {
+		ids = append(ids, ref.ID)
+	}
+
+	schemas, err := loadAttributeSchemas(ctx, t.DB, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	bySlug := map[string]AttributeSchema{}
+	for slug, ref := range terms {
+		bySlug[slug] = schemas[ref.ID]
+	}
+	return bySlug, nil
+}
+
+// AttributeSchemaModel manages the attribute schemas of the categories.
+type AttributeSchemaModel struct {
+	DB *sql.DB
+}
+
+// Get returns the schema of the category itself, and the one including the attributes
+// of the categories above it.
+func (m AttributeSchemaModel) Get(categoryID int64) (AttributeSchema, AttributeSchema, error) {
+	if categoryID < 1 {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var raw []byte
+	err := m.DB.QueryRowContext(ctx, `SELECT attribute_schema FROM categories WHERE id = $1`, categoryID).Scan(&raw)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrRecordNotFound
+		}
+		return nil, nil, err
+	}
+
+	var own AttributeSchema
+	err = json.Unmarshal(raw, &own)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	schemas, err := loadAttributeSchemas(ctx, m.DB, []int64{categoryID})
+	if err != nil {
+		return nil, nil, err
+	}
+	return own, schemas[categoryID].Merge(nil), nil
+}
+
+// SetAudited replaces the schema of the category and records a "category.attributes"
+// audit event in the same transaction. The attributes of existing toys are checked
+// against the new schema the next time they're written.
+func (m AttributeSchemaModel) SetAudited(categoryID int64, schema AttributeSchema, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	raw, err := json.Marshal(schema.Merge(nil))
+	if err != nil {
+		return err
+	}
+
+	return audit.Run(ctx, m.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		var before json.RawMessage
+		err := tx.QueryRowContext(ctx, `SELECT attribute_schema FROM categories WHERE id = $1 FOR UPDATE`, categoryID).Scan(&before)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrRecordNotFound
+			}
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE categories SET attribute_schema = $1, version = version + 1 WHERE id = $2`, raw, categoryID)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, "category.attributes", "category", strconv.FormatInt(categoryID, 10), before, json.RawMessage(raw))
+	})
+}
+
+// Attribute filter operators. Without an operator, the value is compared for equality,
+// which for a list means that it contains the value.
+var AttributeOperators = []string{"eq", "ne", "lt", "lte", "gt", "gte"}
+
+// AttributeFilter restricts the listing to toys with an attribute value. Value is a
+// float64, bool or string.
+type AttributeFilter struct {
+	Name  string
+	Op    string
+	Value any
+}
+
+// ParseAttributeFilters reads filters like "lt:100" or "none", keyed by the attribute
+// name. Values which look like numbers or booleans are compared as such.
+func ParseAttributeFilters(values map[string]string) []AttributeFilter {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	filters := make([]AttributeFilter, 0, len(names))
+	for _, name := range names {
+		f := AttributeFilter{Name: name, Op: "eq"}
+
+		raw := values[name]
+		if op, rest, ok := strings.Cut(raw, ":"); ok && validator.PermittedValue(op, AttributeOperators...) {
+			f.Op, raw = op, rest
+		}
+
+		if n, err := strconv.ParseFloat(raw, 64); err == nil && !math.IsInf(n, 0) && !math.IsNaN(n) {
+			f.Value = n
+		} else if b, err := strconv.ParseBool(raw); err == nil && (raw == "true" || raw == "false") {
+			f.Value = b
+		} else {
+			f.Value = raw
+		}
+		filters = append(filters, f)
+	}
+	return filters
+}
+
+func validateAttributeFilters(v *validator.Validator, filters []AttributeFilter) {
+	for _, f := range filters {
+		key := "attr." + f.Name
+		v.Check(validator.Matches(f.Name, AttributeNameRX), key, "is not a valid attribute name")
+		v.Check(validator.PermittedValue(f.Op, AttributeOperators...), key, "has an invalid operator")
+
+		if f.Op != "eq" && f.Op != "ne" {
+			_, ok := f.Value.(float64)
+			v.Check(ok, key, "must be a number for "+f.Op)
+		}
+	}
+}
+
+// jsonPath returns the JSON path matching the toys whose attribute compares to the
+// value, for the @? operator. The name is validated by then, and the value is encoded
+// as JSON, so the path can't be escaped from. "ne" uses the path of "eq", negated.
+func (f AttributeFilter) jsonPath() string {
+	operators := map[string]string{"eq": "==", "ne": "==", "lt": "<", "lte": "<=", "gt": ">", "gte": ">="}
+
+	value, _ := json.Marshal(f.Value)
+	return fmt.Sprintf(`$.%q ? (@ %s %s)`, f.Name, operators[f.Op], value)
+}
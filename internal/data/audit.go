@@ -0,0 +1,94 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+	"toy-rental-system/internal/audit"
+)
+
+// AuditFilter holds the optional filters for the audit log listing. Zero values mean
+// "don't filter on this field".
+type AuditFilter struct {
+	ActorUserID   int64
+	ActorAPIKeyID int64
+	Action        string
+	TargetType    string
+	TargetID      string
+	Since         time.Time
+	Until         time.Time
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+func (m AuditModel) GetAll(filter AuditFilter, filters Filters) ([]*audit.Event, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), id, created_at, actor_user_id, actor_api_key_id, action, target_type, target_id, before, after, changes, ip, request_id
+FROM audit_events
+WHERE (actor_user_id = $1 OR $1 = 0)
+AND (actor_api_key_id = $2 OR $2 = 0)
+AND (action = $3 OR $3 = '')
+AND (target_type = $4 OR $4 = '')
+AND (target_id = $5 OR $5 = '')
+AND (created_at >= $6 OR $6 IS NULL)
+AND (created_at < $7 OR $7 IS NULL)
+ORDER BY %s %s, id DESC
+LIMIT $8 OFFSET $9`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{filter.ActorUserID, filter.ActorAPIKeyID, filter.Action, filter.TargetType, filter.TargetID, nullTime(filter.Since), nullTime(filter.Until), filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*audit.Event{}
+
+	for rows.Next() {
+		var e audit.Event
+		var before, after, changes []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&e.ID,
+			&e.CreatedAt,
+			&e.ActorUserID,
+			&e.ActorAPIKeyID,
+			&e.Action,
+			&e.TargetType,
+			&e.TargetID,
+			&before,
+			&after,
+			&changes,
+			&e.IP,
+			&e.RequestID,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		e.Before, e.After, e.Changes = before, after, changes
+		events = append(events, &e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
+
+// nullTime converts the zero time to a SQL NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,177 @@
+package data
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// CursorSortList are the sort values which support cursor pagination. Skills and
+// categories are arrays, which make poor keys, so they can only be paginated by page
+// number.
+var CursorSortList = []string{"id", "title", "relevance", "rating", "-id", "-title", "-rating"}
+
+// cursor points at the first or last toy of a page. It's handed out to clients as
+// base64 encoded JSON, which they are not supposed to look into.
+type cursor struct {
+	// Sort is the sort value the cursor was created for.
+	Sort string `json:"s"`
+	// Key is the value of the sort column, which is empty when sorting by id.
+	Key string `json:"k,omitempty"`
+	ID  int64  `json:"id"`
+	// Backward cursors fetch the page before the toy instead of the one after it.
+	Backward bool `json:"b,omitempty"`
+}
+
+func (c cursor) encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal(js, &c)
+	return c, err
+}
+
+// cursorKey returns the expression of the sort column in a keyset condition, and the
+// value of it for a listed toy.
+func cursorKey(filter ToyFilter, column string, toy *Toy, relevance float64) (string, string) {
+	switch column {
+	case "title":
+		return "title", toy.Title
+	case "relevance":
+		// ts_rank() returns a real, so the key is formatted with the same precision to
+		// compare equal to it.
+		if filter.Title == "" {
+			return "0::real", "0"
+		}
+		return "ts_rank(search, q.query)", strconv.FormatFloat(relevance, 'g', -1, 32)
+	case "rating":
+		return "rating", strconv.FormatFloat(toy.Rating, 'f', 2, 64)
+	default:
+		return "id", ""
+	}
+}
+
+// getAllByCursor lists the toys after (or before) the cursor in filters. Instead of
+// skipping the toys of the previous pages with OFFSET, the query continues from the
+// sort key and id in the cursor, which an index can do no matter how deep the page is.
+func (t ToyModel) getAllByCursor(filter ToyFilter, filters Filters) ([]*Toy, Metadata, error) {
+	from, where, b := toyListQuery(filter)
+	metadata := Metadata{PageSize: filters.PageSize}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// The total is counted before the cursor condition is added, so that it covers all
+	// the pages.
+	if filters.WithTotal {
+		query := fmt.Sprintf(`SELECT count(*) FROM %s WHERE %s`, from, where)
+
+		err := t.DB.QueryRowContext(ctx, query, b.args...).Scan(&metadata.TotalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	column := filters.sortColumn()
+	ascending := filters.sortDirection() == "ASC" && column != "relevance"
+
+	var c cursor
+	if filters.Cursor != "" {
+		var err error
+		c, err = decodeCursor(filters.Cursor)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		// Going forward in ascending order, or backward in descending order, the next
+		// toys have greater keys.
+		operator := "<"
+		if ascending != c.Backward {
+			operator = ">"
+		}
+
+		key, _ := cursorKey(filter, column, &Toy{}, 0)
+		if column == "id" {
+			b.Where(fmt.Sprintf("id %s %s", operator, b.Arg(c.ID)))
+		} else {
+			b.Where(fmt.Sprintf("(%s, id) %s (%s, %s)", key, operator, b.Arg(c.Key), b.Arg(c.ID)))
+		}
+		where = b.where()
+	}
+
+	// One more toy than fits on the page is fetched, to tell whether there is another
+	// page after this one.
+	query := fmt.Sprintf(`
+SELECT %s,
+	%s
+FROM %s
+WHERE %s
+ORDER BY %s
+LIMIT %s`, toyListColumns, toySearchColumns(filter), from, where, filters.orderBy(c.Backward), b.Arg(filters.limit()+1))
+
+	rows, err := t.DB.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	toys := []*Toy{}
+	keys := []string{}
+
+	for rows.Next() {
+		toy, relevance, err := scanListedToy(rows, filter)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		_, key := cursorKey(filter, column, toy, relevance)
+		toys = append(toys, toy)
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	more := len(toys) > filters.limit()
+	if more {
+		toys = toys[:filters.limit()]
+		keys = keys[:filters.limit()]
+	}
+
+	// A backward page was fetched in reverse order.
+	if c.Backward {
+		for i, j := 0, len(toys)-1; i < j; i, j = i+1, j-1 {
+			toys[i], toys[j] = toys[j], toys[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	if len(toys) == 0 {
+		return toys, metadata, nil
+	}
+
+	// There is a next page if more toys were found going forward, or if we came back
+	// from it. Likewise for the previous page.
+	first, last := 0, len(toys)-1
+	if (!c.Backward && more) || (c.Backward && filters.Cursor != "") {
+		metadata.NextCursor = cursor{Sort: filters.Sort, Key: keys[last], ID: toys[last].ID}.encode()
+	}
+	if (c.Backward && more) || (!c.Backward && filters.Cursor != "") {
+		metadata.PrevCursor = cursor{Sort: filters.Sort, Key: keys[first], ID: toys[first].ID, Backward: true}.encode()
+	}
+
+	return toys, metadata, nil
+}
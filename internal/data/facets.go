@@ -0,0 +1,92 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FacetNames are the facets which can be requested for the toy listing.
+var FacetNames = []string{"categories", "skills", "age", "manufacturer", "availability"}
+
+// facetQueries count the toys per value of each facet, for the toys in the filtered
+// CTE. Categories and skills are arrays, so a toy counts towards each of its values.
+var facetQueries = map[string]string{
+	"categories":   `SELECT 'categories', value, count(*) FROM filtered, unnest(categories) AS value GROUP BY value`,
+	"skills":       `SELECT 'skills', value, count(*) FROM filtered, unnest(skills) AS value GROUP BY value`,
+	"age":          `SELECT 'age', recommended_age, count(*) FROM filtered GROUP BY recommended_age`,
+	"manufacturer": `SELECT 'manufacturer', manufacturer, count(*) FROM filtered GROUP BY manufacturer`,
+	"availability": `SELECT 'availability', CASE WHEN is_available THEN 'available' ELSE 'rented' END, count(*) FROM filtered GROUP BY is_available`,
+}
+
+// FacetValue is the number of toys with a certain value of a facet.
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets maps a facet name to its values, ordered by count.
+type Facets map[string][]FacetValue
+
+// GetFacets counts the toys matching the same filter as GetAll() by the values of the
+// given facets, which must be taken from FacetNames.
+func (t ToyModel) GetFacets(filter ToyFilter, names []string) (Facets, error) {
+	facets := Facets{}
+	if len(names) == 0 {
+		return facets, nil
+	}
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		part, ok := facetQueries[name]
+		if !ok {
+			panic("unknown facet: " + name)
+		}
+		parts = append(parts, part)
+
+		// Requested facets without any values are returned as empty lists.
+		facets[name] = []FacetValue{}
+	}
+
+	from, where, b := toyListQuery(filter)
+
+	query := fmt.Sprintf(`
+WITH filtered AS (
+	SELECT categories, skills, recommended_age, manufacturer, is_available
+	FROM %s
+	WHERE %s
+)
+SELECT facet, value, count
+FROM (
+%s
+) AS facets (facet, value, count)
+ORDER BY facet, count DESC, value`, from, where, strings.Join(parts, "\nUNION ALL\n"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var value FacetValue
+
+		err := rows.Scan(&name, &value.Value, &value.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		facets[name] = append(facets[name], value)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return facets, nil
+}
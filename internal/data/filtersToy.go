@@ -1,6 +1,7 @@
 package data
 
 import (
+	"fmt"
 	"math"
 	"strings"
 	"toy-rental-system/internal/validator"
@@ -11,6 +12,13 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+
+	// UseCursor switches from page numbers to keyset pagination. Cursor is the opaque
+	// cursor of the page to fetch, or empty for the first page. The total number of
+	// records takes an extra query in this mode, so it's only counted if WithTotal is set.
+	UseCursor bool
+	Cursor    string
+	WithTotal bool
 }
 
 func (f Filters) sortColumn() string {
@@ -29,6 +37,31 @@ func (f Filters) sortDirection() string {
 	return "ASC"
 }
 
+// orderBy returns the ORDER BY clause of the sort. The id breaks ties in the same
+// direction, so that the order is stable and can be used as a keyset. The reverse
+// order is needed to fetch the page before a cursor.
+func (f Filters) orderBy(reverse bool) string {
+	column := f.sortColumn()
+	direction := f.sortDirection()
+	if column == "relevance" {
+		// The most relevant toys come first.
+		direction = "DESC"
+	}
+
+	if reverse {
+		if direction == "ASC" {
+			direction = "DESC"
+		} else {
+			direction = "ASC"
+		}
+	}
+
+	if column == "id" {
+		return "id " + direction
+	}
+	return fmt.Sprintf("%s %s, id %s", column, direction, direction)
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
@@ -42,14 +75,31 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be more than zero")
 	v.Check(f.PageSize <= 24, "page_size", "must be a maximum of 24")
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+
+	if f.UseCursor {
+		v.Check(f.Page == 1, "page", "must not be used together with cursor")
+		v.Check(validator.PermittedValue(f.Sort, CursorSortList...), "sort", "cursor pagination is not supported for this sort value")
+
+		if f.Cursor != "" {
+			c, err := decodeCursor(f.Cursor)
+			switch {
+			case err != nil:
+				v.AddError("cursor", "invalid cursor")
+			case c.Sort != f.Sort:
+				v.AddError("cursor", "was created for a different sort value")
+			}
+		}
+	}
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
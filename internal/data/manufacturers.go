@@ -0,0 +1,333 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/validator"
+)
+
+var (
+	// ErrDuplicateManufacturer is returned when a manufacturer would get the name of
+	// another one, ignoring case.
+	ErrDuplicateManufacturer = errors.New("duplicate manufacturer")
+	// ErrManufacturerInUse is returned when a manufacturer which still has toys is
+	// deleted.
+	ErrManufacturerInUse = errors.New("manufacturer in use")
+)
+
+// Manufacturer makes toys. Toys refer to it by name, which is matched ignoring case
+// whenever a toy is written, and keep its ID along with a copy of the name for display
+// and search. Country is an ISO 3166-1 alpha-2 code, and SafetyContact the email
+// address or phone number for product safety reports.
+type Manufacturer struct {
+	ID            int64     `json:"id"`
+	Name          string    `json:"name"`
+	Country       string    `json:"country,omitempty"`
+	Website       string    `json:"website,omitempty"`
+	SafetyContact string    `json:"safety_contact,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	Version       int32     `json:"version"`
+}
+
+func ValidateManufacturer(v *validator.Validator, m *Manufacturer) {
+	v.Check(strings.TrimSpace(m.Name) != "", "name", "must be provided")
+	v.Check(len(m.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(m.Country == "" || validator.Matches(m.Country, validator.CountryRX), "country", "must be an ISO 3166-1 alpha-2 code")
+	v.Check(len(m.Website) <= 500, "website", "must not be more than 500 bytes long")
+	v.Check(len(m.SafetyContact) <= 200, "safety_contact", "must not be more than 200 bytes long")
+
+	if m.Website != "" {
+		u, err := url.Parse(m.Website)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "website", "must be an http or https URL")
+	}
+	if m.SafetyContact != "" {
+		ok := validator.Matches(m.SafetyContact, validator.EmailRX) || validator.Matches(m.SafetyContact, validator.PhoneRX)
+		v.Check(ok, "safety_contact", "must be an email address or phone number")
+	}
+}
+
+type ManufacturerModel struct {
+	DB *sql.DB
+}
+
+type ManufacturerRepository interface {
+	Get(id int64) (*Manufacturer, error)
+	GetAll(name string, filters Filters) ([]*Manufacturer, Metadata, error)
+	InsertAudited(m *Manufacturer, actor audit.Actor) error
+	UpdateAudited(m *Manufacturer, actor audit.Actor) error
+	DeleteAudited(id int64, actor audit.Actor) error
+}
+
+const manufacturerColumns = `id, name, country, website, safety_contact, created_at, version`
+
+func scanManufacturer(row scanner, extra ...any) (*Manufacturer, error) {
+	var m Manufacturer
+
+	dest := append(extra, &m.ID, &m.Name, &m.Country, &m.Website, &m.SafetyContact, &m.CreatedAt, &m.Version)
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (mm ManufacturerModel) Get(id int64) (*Manufacturer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getManufacturer(ctx, mm.DB, id, false)
+}
+
+func getManufacturer(ctx context.Context, q querier, id int64, forUpdate bool) (*Manufacturer, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + manufacturerColumns + ` FROM manufacturers WHERE id = $1`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	m, err := scanManufacturer(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetAll lists the manufacturers, optionally only those whose name contains name.
+func (mm ManufacturerModel) GetAll(name string, filters Filters) ([]*Manufacturer, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s
+FROM manufacturers
+WHERE (strpos(lower(name), lower($1)) > 0 OR $1 = '')
+ORDER BY %s
+LIMIT $2 OFFSET $3`, manufacturerColumns, filters.orderBy(false))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := mm.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	manufacturers := []*Manufacturer{}
+
+	for rows.Next() {
+		m, err := scanManufacturer(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		manufacturers = append(manufacturers, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return manufacturers, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// InsertAudited inserts the manufacturer and records a "manufacturer.create" audit
+// event in the same transaction.
+func (mm ManufacturerModel) InsertAudited(m *Manufacturer, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+INSERT INTO manufacturers (name, country, website, safety_contact)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, version`
+
+	err := audit.Run(ctx, mm.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		err := tx.QueryRowContext(ctx, query, m.Name, m.Country, m.Website, m.SafetyContact).Scan(&m.ID, &m.CreatedAt, &m.Version)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, "manufacturer.create", "manufacturer", strconv.FormatInt(m.ID, 10), nil, m)
+	})
+	return manufacturerError(err)
+}
+
+// UpdateAudited updates the manufacturer, as long as it still has the version it was
+// read with, and records an audit event in the same transaction. A new name is also
+// given to its toys.
+func (mm ManufacturerModel) UpdateAudited(m *Manufacturer, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := audit.Run(ctx, mm.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		before, err := getManufacturer(ctx, tx, m.ID, true)
+		if err != nil {
+			if errors.Is(err, ErrRecordNotFound) {
+				return nil, ErrEditConflict
+			}
+			return nil, err
+		}
+
+		if before.Version != m.Version {
+			return nil, ErrEditConflict
+		}
+
+		query := `
+UPDATE manufacturers
+SET name = $1, country = $2, website = $3, safety_contact = $4, version = version + 1
+WHERE id = $5 AND version = $6
+RETURNING version`
+
+		err = tx.QueryRowContext(ctx, query, m.Name, m.Country, m.Website, m.SafetyContact, m.ID, m.Version).Scan(&m.Version)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrEditConflict
+			}
+			return nil, err
+		}
+
+		if m.Name != before.Name {
+			query := `
+UPDATE toys
+SET manufacturer = $1, version = version + 1, updated_at = now()
+WHERE manufacturer_id = $2`
+
+			_, err := tx.ExecContext(ctx, query, m.Name, m.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return audit.NewEvent(actor, "manufacturer.update", "manufacturer", strconv.FormatInt(m.ID, 10), before, m)
+	})
+	return manufacturerError(err)
+}
+
+// DeleteAudited deletes the manufacturer and records an audit event in the same
+// transaction. Manufacturers which still have toys, even ones in the trash, can't be
+// deleted.
+func (mm ManufacturerModel) DeleteAudited(id int64, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := audit.Run(ctx, mm.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		before, err := getManufacturer(ctx, tx, id, true)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM manufacturers WHERE id = $1`, id)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, "manufacturer.delete", "manufacturer", strconv.FormatInt(id, 10), before, nil)
+	})
+	return manufacturerError(err)
+}
+
+// manufacturerError translates the constraint violations of the manufacturers table.
+func manufacturerError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23505":
+			return ErrDuplicateManufacturer
+		case "23503":
+			return ErrManufacturerInUse
+		}
+	}
+	return err
+}
+
+// UnknownManufacturerError is returned when a toy refers to a manufacturer which
+// doesn't exist.
+type UnknownManufacturerError struct {
+	Name string
+}
+
+func (e *UnknownManufacturerError) Error() string {
+	return "unknown manufacturer: " + e.Name
+}
+
+// loadManufacturers looks up the manufacturers of the given names, keyed by their
+// lowercased name.
+func loadManufacturers(ctx context.Context, q querier, names []string) (map[string]*Manufacturer, error) {
+	manufacturers := map[string]*Manufacturer{}
+
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, strings.ToLower(strings.TrimSpace(name)))
+	}
+	if len(keys) == 0 {
+		return manufacturers, nil
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT `+manufacturerColumns+` FROM manufacturers WHERE lower(name) = ANY($1)`, pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m, err := scanManufacturer(rows)
+		if err != nil {
+			return nil, err
+		}
+		manufacturers[strings.ToLower(m.Name)] = m
+	}
+	return manufacturers, rows.Err()
+}
+
+// resolveManufacturers sets the manufacturer ID of the toys, and replaces their
+// manufacturer with its name as spelled in the manufacturers table.
+func resolveManufacturers(ctx context.Context, q querier, toys ...*Toy) error {
+	names := make([]string, len(toys))
+	for i, toy := range toys {
+		names[i] = toy.Manufacturer
+	}
+
+	manufacturers, err := loadManufacturers(ctx, q, names)
+	if err != nil {
+		return err
+	}
+
+	for _, toy := range toys {
+		m, ok := manufacturers[strings.ToLower(strings.TrimSpace(toy.Manufacturer))]
+		if !ok {
+			return &UnknownManufacturerError{Name: toy.Manufacturer}
+		}
+		toy.ManufacturerID = m.ID
+		toy.Manufacturer = m.Name
+	}
+	return nil
+}
+
+// UnknownManufacturers returns the names which aren't the name of a manufacturer.
+func (t ToyModel) UnknownManufacturers(names []string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	manufacturers, err := loadManufacturers(ctx, t.DB, names)
+	if err != nil {
+		return nil, err
+	}
+
+	unknown := []string{}
+	for _, name := range names {
+		if _, ok := manufacturers[strings.ToLower(strings.TrimSpace(name))]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown, nil
+}
@@ -11,11 +11,29 @@ var (
 )
 
 type Models struct {
-	Toys ToyModel
+	Toys             ToyModel
+	ToyImages        ToyImageModel
+	Tokens           TokenModel
+	APIKeys          APIKeyModel
+	Audit            AuditModel
+	Categories       TaxonomyModel
+	Skills           TaxonomyModel
+	AttributeSchemas AttributeSchemaModel
+	Manufacturers    ManufacturerModel
+	Reviews          ReviewModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Toys: ToyModel{DB: db},
+		Toys:             ToyModel{DB: db},
+		ToyImages:        ToyImageModel{DB: db},
+		Tokens:           TokenModel{DB: db},
+		APIKeys:          APIKeyModel{DB: db},
+		Audit:            AuditModel{DB: db},
+		Categories:       TaxonomyModel{DB: db, Kind: TaxonomyCategories},
+		Skills:           TaxonomyModel{DB: db, Kind: TaxonomySkills},
+		AttributeSchemas: AttributeSchemaModel{DB: db},
+		Manufacturers:    ManufacturerModel{DB: db},
+		Reviews:          ReviewModel{DB: db},
 	}
 }
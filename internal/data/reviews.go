@@ -0,0 +1,264 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strconv"
+	"strings"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/validator"
+)
+
+var (
+	// ErrNotReturned is returned when a user reviews a toy they haven't returned.
+	ErrNotReturned = errors.New("toy not returned")
+	// ErrDuplicateReview is returned when a user reviews the same toy twice.
+	ErrDuplicateReview = errors.New("duplicate review")
+)
+
+// Review is the rating of a toy by a user who has rented it, from 1 to 5 stars, with an
+// optional text. Author is the display name of the user, if they have one.
+type Review struct {
+	ID        int64     `json:"id"`
+	ToyID     int64     `json:"toy_id"`
+	UserID    int64     `json:"user_id"`
+	Author    string    `json:"author,omitempty"`
+	Rating    int       `json:"rating"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating >= 1 && review.Rating <= 5, "rating", "must be between 1 and 5")
+	v.Check(len(review.Body) <= 5000, "body", "must not be more than 5000 bytes long")
+	v.Check(review.Body == "" || strings.TrimSpace(review.Body) != "", "body", "must not be blank")
+}
+
+// ToyReturn records that a user has brought a rented toy back, which allows them to
+// review it.
+type ToyReturn struct {
+	ID         int64     `json:"id"`
+	ToyID      int64     `json:"toy_id"`
+	UserID     int64     `json:"user_id"`
+	ReturnedAt time.Time `json:"returned_at"`
+}
+
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+type ReviewRepository interface {
+	Get(id int64) (*Review, error)
+	GetAllForToy(toyID int64, filters Filters) ([]*Review, Metadata, error)
+	InsertAudited(review *Review, actor audit.Actor) error
+	DeleteAudited(review *Review, actor audit.Actor) error
+	RecordReturnAudited(ret *ToyReturn, actor audit.Actor) error
+}
+
+const reviewColumns = `id, toy_id, user_id, COALESCE((SELECT display_name FROM users WHERE users.id = toy_reviews.user_id), ''), rating, body, created_at`
+
+func scanReview(row scanner, extra ...any) (*Review, error) {
+	var review Review
+
+	dest := append(extra, &review.ID, &review.ToyID, &review.UserID, &review.Author, &review.Rating, &review.Body, &review.CreatedAt)
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+func (m ReviewModel) Get(id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	review, err := scanReview(m.DB.QueryRowContext(ctx, `SELECT `+reviewColumns+` FROM toy_reviews WHERE id = $1`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return review, nil
+}
+
+// GetAllForToy lists the reviews of a toy.
+func (m ReviewModel) GetAllForToy(toyID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), %s
+FROM toy_reviews
+WHERE toy_id = $1
+ORDER BY %s
+LIMIT $2 OFFSET $3`, reviewColumns, filters.orderBy(false))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, toyID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		review, err := scanReview(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reviews = append(reviews, review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return reviews, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// InsertAudited inserts the review, updates the rating of the toy and records a
+// "review.create" audit event in the same transaction. The user must have returned
+// the toy, and can review it only once.
+func (m ReviewModel) InsertAudited(review *Review, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return audit.Run(ctx, m.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		err := lockRatedToy(ctx, tx, review.ToyID)
+		if err != nil {
+			return nil, err
+		}
+
+		var returned bool
+		query := `SELECT EXISTS (SELECT 1 FROM toy_returns WHERE toy_id = $1 AND user_id = $2)`
+
+		err = tx.QueryRowContext(ctx, query, review.ToyID, review.UserID).Scan(&returned)
+		if err != nil {
+			return nil, err
+		}
+		if !returned {
+			return nil, ErrNotReturned
+		}
+
+		query = `
+INSERT INTO toy_reviews (toy_id, user_id, rating, body)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at`
+
+		err = tx.QueryRowContext(ctx, query, review.ToyID, review.UserID, review.Rating, review.Body).Scan(&review.ID, &review.CreatedAt)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				return nil, ErrDuplicateReview
+			}
+			return nil, err
+		}
+
+		err = updateToyRating(ctx, tx, review.ToyID)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, "review.create", "review", strconv.FormatInt(review.ID, 10), nil, review)
+	})
+}
+
+// DeleteAudited deletes the review, updates the rating of the toy and records a
+// "review.delete" audit event in the same transaction.
+func (m ReviewModel) DeleteAudited(review *Review, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return audit.Run(ctx, m.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		err := lockRatedToy(ctx, tx, review.ToyID)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := tx.ExecContext(ctx, `DELETE FROM toy_reviews WHERE id = $1`, review.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if rowsAffected == 0 {
+			return nil, ErrRecordNotFound
+		}
+
+		err = updateToyRating(ctx, tx, review.ToyID)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, "review.delete", "review", strconv.FormatInt(review.ID, 10), review, nil)
+	})
+}
+
+// lockRatedToy locks the toy whose rating is about to change. Concurrent reviews of the
+// same toy wait for each other, so that each one computes the rating from all the
+// reviews before it.
+func lockRatedToy(ctx context.Context, tx *sql.Tx, toyID int64) error {
+	err := tx.QueryRowContext(ctx, `SELECT id FROM toys WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, toyID).Scan(&toyID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// updateToyRating recomputes the average rating and number of reviews of the toy. The
+// version is left alone, since a review isn't an edit of the toy, but updated_at moves
+// on so that cached copies of the toy with the old rating are revalidated.
+func updateToyRating(ctx context.Context, tx *sql.Tx, toyID int64) error {
+	query := `
+UPDATE toys
+SET rating = COALESCE((SELECT round(avg(rating), 2) FROM toy_reviews WHERE toy_id = $1), 0),
+	rating_count = (SELECT count(*) FROM toy_reviews WHERE toy_id = $1),
+	updated_at = now()
+WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, toyID)
+	return err
+}
+
+// RecordReturnAudited records the return of a toy by a user and a "toy.return" audit
+// event in the same transaction. ErrRecordNotFound is returned if either of them
+// doesn't exist.
+func (m ReviewModel) RecordReturnAudited(ret *ToyReturn, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+INSERT INTO toy_returns (toy_id, user_id)
+SELECT id, $2 FROM toys WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, toy_id, user_id, returned_at`
+
+	return audit.Run(ctx, m.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		err := tx.QueryRowContext(ctx, query, ret.ToyID, ret.UserID).Scan(&ret.ID, &ret.ToyID, &ret.UserID, &ret.ReturnedAt)
+		if err != nil {
+			var pqErr *pq.Error
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return nil, ErrRecordNotFound
+			case errors.As(err, &pqErr) && pqErr.Code == "23503":
+				return nil, ErrRecordNotFound
+			default:
+				return nil, err
+			}
+		}
+		return audit.NewEvent(actor, "toy.return", "toy", strconv.FormatInt(ret.ToyID, 10), nil, ret)
+	})
+}
@@ -0,0 +1,22 @@
+package data
+
+// SearchLanguages are the text search configurations a toy can be indexed with. The
+// title, manufacturer and description are stemmed in the language of the toy, while a
+// search term is stemmed in all of them, since we don't know the language it's in.
+var SearchLanguages = []string{"english", "russian"}
+
+const DefaultSearchLanguage = "english"
+
+// headlineOptions wrap the matched words in <mark> tags. The description can be long,
+// so only the best fragments of it are returned.
+const (
+	titleHeadlineOptions       = `StartSel=<mark>, StopSel=</mark>, HighlightAll=true`
+	descriptionHeadlineOptions = `StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=8, FragmentDelimiter=" … "`
+)
+
+// ToyHighlight holds the title and description of a toy matched by a search term, with
+// the matching words highlighted by ts_headline.
+type ToyHighlight struct {
+	Title       string `json:"title"`
+	Description string `json:"desc"`
+}
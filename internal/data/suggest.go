@@ -0,0 +1,132 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Suggestion is a completion for a partly typed search term. Kind is one of "title",
+// "manufacturer", "category" or "word", and Toys is the number of toys it occurs in.
+type Suggestion struct {
+	Term string `json:"term"`
+	Kind string `json:"kind"`
+	Toys int    `json:"toys"`
+}
+
+// Suggest returns the search terms which start with q or are similar to it. Prefix
+// matches come first, then the terms ranked by trigram similarity. For terms of at
+// least four characters, words within an edit distance of two are included as well,
+// since trigrams don't catch swapped letters in short words like "lgeo".
+func (t ToyModel) Suggest(q string, limit int) ([]*Suggestion, error) {
+	query := `
+SELECT term, kind, toys
+FROM (
+	SELECT DISTINCT ON (lower(term)) term, kind, toys,
+		lower(term) LIKE $2 AS prefix,
+		similarity(lower(term), $1) AS score,
+		levenshtein_less_equal(lower(term), $1, 3) AS distance
+	FROM toy_search_terms
+	WHERE lower(term) LIKE $2
+	OR lower(term) % $1
+	OR (length($1) >= 4 AND levenshtein_less_equal(lower(term), $1, 2) <= 2)
+	ORDER BY lower(term), array_position(ARRAY['title', 'manufacturer', 'category', 'word'], kind)
+) AS matches
+ORDER BY prefix DESC, score DESC, distance, toys DESC, term
+LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	q = strings.ToLower(strings.TrimSpace(q))
+
+	rows, err := t.DB.QueryContext(ctx, query, q, escapeLike(q)+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*Suggestion{}
+
+	for rows.Next() {
+		var s Suggestion
+
+		err := rows.Scan(&s.Term, &s.Kind, &s.Toys)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+// DidYouMean corrects every word of a search term to the closest known word. It returns
+// an empty string if there is nothing to correct.
+func (t ToyModel) DidYouMean(q string) (string, error) {
+	query := `
+SELECT term
+FROM toy_search_terms
+WHERE kind = 'word'
+AND (term % $1 OR levenshtein_less_equal(term, $1, 2) <= 2)
+ORDER BY term = $1 DESC, levenshtein_less_equal(term, $1, 3), similarity(term, $1) DESC, toys DESC
+LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	words := strings.Fields(strings.ToLower(q))
+	corrected := make([]string, len(words))
+	changed := false
+
+	for i, word := range words {
+		corrected[i] = word
+
+		// Short words are left alone, since almost anything is within two edits of them.
+		if len([]rune(word)) < 3 {
+			continue
+		}
+
+		var term string
+
+		err := t.DB.QueryRowContext(ctx, query, word).Scan(&term)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			return "", err
+		}
+
+		if term != word {
+			corrected[i] = term
+			changed = true
+		}
+	}
+
+	if !changed {
+		return "", nil
+	}
+	return strings.Join(corrected, " "), nil
+}
+
+// RefreshSearchTerms rebuilds the vocabulary used by Suggest() and DidYouMean() from the
+// current toys. It doesn't block readers while it runs.
+func (t ToyModel) RefreshSearchTerms() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, "REFRESH MATERIALIZED VIEW CONCURRENTLY toy_search_terms")
+	return err
+}
+
+// escapeLike escapes the wildcard characters of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
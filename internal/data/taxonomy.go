@@ -0,0 +1,525 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strconv"
+	"strings"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/validator"
+	"unicode"
+)
+
+var (
+	// ErrDuplicateSlug is returned when a term would get the slug of another term.
+	ErrDuplicateSlug = errors.New("duplicate slug")
+	// ErrInvalidParent is returned when the parent of a term doesn't exist, or is the
+	// term itself or one of its descendants.
+	ErrInvalidParent = errors.New("invalid parent")
+	// ErrTermInUse is returned when a term which still has toys or children is deleted.
+	ErrTermInUse = errors.New("term in use")
+)
+
+// TaxonomyKind is one of the two taxonomies of the catalog. Its value is the name of
+// both the table with the terms and the array column of toys with their names.
+type TaxonomyKind string
+
+const (
+	TaxonomyCategories TaxonomyKind = "categories"
+	TaxonomySkills     TaxonomyKind = "skills"
+)
+
+// Singular returns the name of a single term, as used in audit actions and responses.
+func (k TaxonomyKind) Singular() string {
+	switch k {
+	case TaxonomyCategories:
+		return "category"
+	case TaxonomySkills:
+		return "skill"
+	default:
+		panic("unknown taxonomy: " + string(k))
+	}
+}
+
+// links returns the table which links toys to the terms, and its column with the term.
+func (k TaxonomyKind) links() (string, string) {
+	return "toy_" + string(k), k.Singular() + "_id"
+}
+
+// Term is a category or skill. Terms form a hierarchy, and filtering the toys by a term
+// includes the toys of all its descendants. Toys refer to terms by their slug or name,
+// and the name is what's stored on the toy. Translations map a search language to the
+// name in that language.
+type Term struct {
+	ID           int64             `json:"id"`
+	ParentID     *int64            `json:"parent_id"`
+	Slug         string            `json:"slug"`
+	Name         string            `json:"name"`
+	Translations map[string]string `json:"translations"`
+	CreatedAt    time.Time         `json:"created_at"`
+	Version      int32             `json:"version"`
+}
+
+func ValidateTerm(v *validator.Validator, term *Term) {
+	v.Check(term.Name != "", "name", "must be provided")
+	v.Check(len(term.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(term.Slug != "", "slug", "must be provided")
+	v.Check(len(term.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+	v.Check(Slugify(term.Slug) == term.Slug, "slug", "must only contain lowercase letters, digits and single dashes")
+	v.Check(term.ParentID == nil || *term.ParentID != term.ID, "parent_id", "must not be the term itself")
+
+	for language, name := range term.Translations {
+		v.Check(validator.PermittedValue(language, SearchLanguages...), "translations", "must only contain the languages english and russian")
+		v.Check(name != "", "translations", "must not contain empty names")
+		v.Check(len(name) <= 100, "translations", "must not contain names more than 100 bytes long")
+	}
+}
+
+// Slugify turns a name into a slug: lowercase letters and digits, with single dashes
+// in place of everything else. The migration which created the taxonomy does the same
+// in SQL.
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(name) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			dash = true
+			continue
+		}
+		if dash && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		dash = false
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+type TaxonomyModel struct {
+	DB   *sql.DB
+	Kind TaxonomyKind
+}
+
+type TaxonomyRepository interface {
+	Get(id int64) (*Term, error)
+	GetAll() ([]*Term, error)
+	InsertAudited(term *Term, actor audit.Actor) error
+	UpdateAudited(term *Term, actor audit.Actor) error
+	DeleteAudited(id int64, actor audit.Actor) error
+}
+
+const termColumns = `id, parent_id, slug, name, translations, created_at, version`
+
+func scanTerm(row scanner) (*Term, error) {
+	var term Term
+	var translations []byte
+
+	err := row.Scan(&term.ID, &term.ParentID, &term.Slug, &term.Name, &translations, &term.CreatedAt, &term.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(translations, &term.Translations)
+	if err != nil {
+		return nil, err
+	}
+	return &term, nil
+}
+
+func (m TaxonomyModel) Get(id int64) (*Term, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getTerm(ctx, m.DB, m.Kind, id, false)
+}
+
+func getTerm(ctx context.Context, q querier, kind TaxonomyKind, id int64, forUpdate bool) (*Term, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + termColumns + ` FROM ` + string(kind) + ` WHERE id = $1`
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	term, err := scanTerm(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return term, nil
+}
+
+// GetAll returns all terms of the taxonomy, ordered by name. The hierarchy is small
+// enough for clients to build the tree from the parent IDs.
+func (m TaxonomyModel) GetAll() ([]*Term, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+termColumns+` FROM `+string(m.Kind)+` ORDER BY name, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := []*Term{}
+	for rows.Next() {
+		term, err := scanTerm(rows)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	return terms, rows.Err()
+}
+
+// InsertAudited inserts the term and records a "category.create" or "skill.create"
+// audit event in the same transaction.
+func (m TaxonomyModel) InsertAudited(term *Term, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+INSERT INTO ` + string(m.Kind) + ` (parent_id, slug, name, translations)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, version`
+
+	translations, err := json.Marshal(nonNilTranslations(term.Translations))
+	if err != nil {
+		return err
+	}
+
+	err = audit.Run(ctx, m.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		err := tx.QueryRowContext(ctx, query, term.ParentID, term.Slug, term.Name, translations).Scan(&term.ID, &term.CreatedAt, &term.Version)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, m.Kind.Singular()+".create", m.Kind.Singular(), strconv.FormatInt(term.ID, 10), nil, term)
+	})
+	return termError(err)
+}
+
+// UpdateAudited updates the term, as long as it still has the version it was read
+// with, and records an audit event in the same transaction. A new name is also given
+// to the toys with the term.
+func (m TaxonomyModel) UpdateAudited(term *Term, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	translations, err := json.Marshal(nonNilTranslations(term.Translations))
+	if err != nil {
+		return err
+	}
+
+	err = audit.Run(ctx, m.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		before, err := getTerm(ctx, tx, m.Kind, term.ID, true)
+		if err != nil {
+			if errors.Is(err, ErrRecordNotFound) {
+				return nil, ErrEditConflict
+			}
+			return nil, err
+		}
+
+		if before.Version != term.Version {
+			return nil, ErrEditConflict
+		}
+
+		// The new parent must not be the term itself or below it, or the hierarchy
+		// would become a cycle.
+		if term.ParentID != nil {
+			query := `
+WITH RECURSIVE ancestors AS (
+	SELECT id, parent_id FROM ` + string(m.Kind) + ` WHERE id = $1
+	UNION ALL
+	SELECT t.id, t.parent_id FROM ` + string(m.Kind) + ` t JOIN ancestors ON t.id = ancestors.parent_id
+)
+SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`
+
+			var cycle bool
+			err := tx.QueryRowContext(ctx, query, *term.ParentID, term.ID).Scan(&cycle)
+			if err != nil {
+				return nil, err
+			}
+			if cycle {
+				return nil, ErrInvalidParent
+			}
+		}
+
+		query := `
+UPDATE ` + string(m.Kind) + `
+SET parent_id = $1, slug = $2, name = $3, translations = $4, version = version + 1
+WHERE id = $5 AND version = $6
+RETURNING version`
+
+		err = tx.QueryRowContext(ctx, query, term.ParentID, term.Slug, term.Name, translations, term.ID, term.Version).Scan(&term.Version)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrEditConflict
+			}
+			return nil, err
+		}
+
+		if term.Name != before.Name {
+			links, column := m.Kind.links()
+			query := fmt.Sprintf(`
+UPDATE toys
+SET %[1]s = array_replace(%[1]s, $1, $2), version = version + 1, updated_at = now()
+WHERE id IN (SELECT toy_id FROM %[2]s WHERE %[3]s = $3)`, string(m.Kind), links, column)
+
+			_, err := tx.ExecContext(ctx, query, before.Name, term.Name, term.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return audit.NewEvent(actor, m.Kind.Singular()+".update", m.Kind.Singular(), strconv.FormatInt(term.ID, 10), before, term)
+	})
+	return termError(err)
+}
+
+// DeleteAudited deletes the term and records an audit event in the same transaction.
+// Terms which still have toys or children can't be deleted.
+func (m TaxonomyModel) DeleteAudited(id int64, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := audit.Run(ctx, m.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		before, err := getTerm(ctx, tx, m.Kind, id, true)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+string(m.Kind)+` WHERE id = $1`, id)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, m.Kind.Singular()+".delete", m.Kind.Singular(), strconv.FormatInt(id, 10), before, nil)
+	})
+	return termError(err)
+}
+
+// termError translates the constraint violations of the taxonomy tables.
+func termError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code == "23505":
+			return ErrDuplicateSlug
+		case pqErr.Code == "23503" && strings.HasSuffix(pqErr.Constraint, "_parent_id_fkey") && strings.HasPrefix(pqErr.Message, "insert or update"):
+			return ErrInvalidParent
+		case pqErr.Code == "23503":
+			return ErrTermInUse
+		}
+	}
+	return err
+}
+
+func nonNilTranslations(translations map[string]string) map[string]string {
+	if translations == nil {
+		return map[string]string{}
+	}
+	return translations
+}
+
+// UnknownTermsError is returned when a toy refers to categories or skills which aren't
+// in the taxonomy.
+type UnknownTermsError struct {
+	Kind   TaxonomyKind
+	Values []string
+}
+
+func (e *UnknownTermsError) Error() string {
+	return fmt.Sprintf("unknown %s: %s", e.Kind, strings.Join(e.Values, ", "))
+}
+
+// termRef is the part of a term a toy needs.
+type termRef struct {
+	ID   int64
+	Name string
+}
+
+// loadTerms looks up the terms of the given names or slugs, keyed by slug.
+func loadTerms(ctx context.Context, q querier, kind TaxonomyKind, values []string) (map[string]termRef, error) {
+	terms := map[string]termRef{}
+
+	slugs := make([]string, 0, len(values))
+	for _, value := range values {
+		slugs = append(slugs, Slugify(value))
+	}
+	if len(slugs) == 0 {
+		return terms, nil
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT id, slug, name FROM `+string(kind)+` WHERE slug = ANY($1)`, pq.Array(slugs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ref termRef
+		var slug string
+
+		err := rows.Scan(&ref.ID, &slug, &ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		terms[slug] = ref
+	}
+	return terms, rows.Err()
+}
+
+// applyTerms replaces the values of a toy by the names of their terms, dropping the
+// values which turn out to be duplicates, and returns the IDs of the terms.
+func applyTerms(kind TaxonomyKind, values *[]string, terms map[string]termRef) ([]int64, error) {
+	ids := []int64{}
+	names := []string{}
+	unknown := []string{}
+
+	for _, value := range *values {
+		ref, ok := terms[Slugify(value)]
+		switch {
+		case !ok:
+			unknown = append(unknown, value)
+		case !validator.PermittedValue(ref.Name, names...):
+			ids = append(ids, ref.ID)
+			names = append(names, ref.Name)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return nil, &UnknownTermsError{Kind: kind, Values: unknown}
+	}
+
+	*values = names
+	return ids, nil
+}
+
+// toyTerms holds the IDs of the terms of toys, by the index of the toy.
+type toyTerms struct {
+	categories [][]int64
+	skills     [][]int64
+}
+
+// resolveToyTerms checks that the categories and skills of the toys are in the
+// taxonomy, and replaces them with the names of their terms.
+func resolveToyTerms(ctx context.Context, q querier, toys ...*Toy) (*toyTerms, error) {
+	var categories, skills []string
+	for _, toy := range toys {
+		categories = append(categories, toy.Categories...)
+		skills = append(skills, toy.Skills...)
+	}
+
+	categoryTerms, err := loadTerms(ctx, q, TaxonomyCategories, categories)
+	if err != nil {
+		return nil, err
+	}
+
+	skillTerms, err := loadTerms(ctx, q, TaxonomySkills, skills)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := &toyTerms{}
+	for _, toy := range toys {
+		ids, err := applyTerms(TaxonomyCategories, &toy.Categories, categoryTerms)
+		if err != nil {
+			return nil, err
+		}
+		terms.categories = append(terms.categories, ids)
+
+		ids, err = applyTerms(TaxonomySkills, &toy.Skills, skillTerms)
+		if err != nil {
+			return nil, err
+		}
+		terms.skills = append(terms.skills, ids)
+	}
+	return terms, nil
+}
+
+// linkToyTerms makes the link tables match the terms of the toys, which must have
+// their IDs by now.
+func linkToyTerms(ctx context.Context, q querier, terms *toyTerms, toys ...*Toy) error {
+	for _, kind := range []TaxonomyKind{TaxonomyCategories, TaxonomySkills} {
+		termIDs := terms.categories
+		if kind == TaxonomySkills {
+			termIDs = terms.skills
+		}
+
+		var toyIDs, linkedToyIDs, linkedTermIDs []int64
+		for i, toy := range toys {
+			toyIDs = append(toyIDs, toy.ID)
+			for _, id := range termIDs[i] {
+				linkedToyIDs = append(linkedToyIDs, toy.ID)
+				linkedTermIDs = append(linkedTermIDs, id)
+			}
+		}
+
+		// Links which are kept are neither deleted nor inserted again, since both
+		// parts of the statement see the table as it was before.
+		links, column := kind.links()
+		query := fmt.Sprintf(`
+WITH links (toy_id, term_id) AS (
+	SELECT * FROM unnest($2::bigint[], $3::bigint[])
+), removed AS (
+	DELETE FROM %[1]s
+	WHERE toy_id = ANY($1) AND (toy_id, %[2]s) NOT IN (SELECT toy_id, term_id FROM links)
+)
+INSERT INTO %[1]s (toy_id, %[2]s)
+SELECT toy_id, term_id FROM links
+ON CONFLICT DO NOTHING`, links, column)
+
+		_, err := q.ExecContext(ctx, query, pq.Array(toyIDs), pq.Array(linkedToyIDs), pq.Array(linkedTermIDs))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnknownTerms returns the values which aren't the name or slug of a term.
+func (t ToyModel) UnknownTerms(kind TaxonomyKind, values []string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	terms, err := loadTerms(ctx, t.DB, kind, values)
+	if err != nil {
+		return nil, err
+	}
+
+	unknown := []string{}
+	for _, value := range values {
+		if _, ok := terms[Slugify(value)]; !ok {
+			unknown = append(unknown, value)
+		}
+	}
+	return unknown, nil
+}
+
+// termFilter returns a condition matching the toys with one of the terms whose slugs
+// are in the placeholder, or with any of their descendants.
+func termFilter(kind TaxonomyKind, slugs string) string {
+	links, column := kind.links()
+
+	return fmt.Sprintf(`EXISTS (
+	SELECT 1 FROM %[1]s
+	WHERE %[1]s.toy_id = toys.id AND %[1]s.%[2]s IN (
+		WITH RECURSIVE tree AS (
+			SELECT id FROM %[3]s WHERE slug = ANY(%[4]s)
+			UNION ALL
+			SELECT t.id FROM %[3]s t JOIN tree ON t.parent_id = tree.id
+		)
+		SELECT id FROM tree
+	)
+)`, links, column, string(kind), slugs)
+}
@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/lib/pq"
+	"strconv"
 	"time"
+	"toy-rental-system/internal/audit"
 	"toy-rental-system/internal/validator"
 )
 
@@ -21,9 +23,33 @@ type Toy struct {
 	Categories     []string  `json:"categories"`
 	RecommendedAge string    `json:"recommended_age"`
 	Manufacturer   string    `json:"manufacturer"`
+	ManufacturerID int64     `json:"manufacturer_id,omitempty"`
 	Value          int64     `json:"value"`
 	IsAvailable    bool      `json:"isAvailable"`
 	WaitList       []string  `json:"waitList,omitempty"`
+	Language       string    `json:"language"`
+	// Version starts at 1 and is incremented on every update. Clients send it back with
+	// their changes, so that they can't overwrite a change they haven't seen.
+	Version   int32     `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// SupplierSKU identifies the toy in the catalog of its supplier, so that imports can
+	// update the toys they created before.
+	SupplierSKU string `json:"supplier_sku,omitempty"`
+	// DeletedAt is set while the toy is in the trash, which is only listed for admins.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Attributes are the typed specifications of the toy, as defined by the attribute
+	// schemas of its categories.
+	Attributes Attributes `json:"attributes,omitempty"`
+	// Rating is the average number of stars in the reviews of the toy, and RatingCount
+	// the number of reviews. Both are 0 until the toy is reviewed.
+	Rating      float64 `json:"rating"`
+	RatingCount int32   `json:"rating_count"`
+
+	// Photos are the uploaded images of the toy, which are only loaded for a single toy.
+	Photos []*ToyImage `json:"photos,omitempty"`
+
+	// Highlight is only set on the toys returned by a search.
+	Highlight *ToyHighlight `json:"highlight,omitempty"`
 }
 
 func ValidateToy(v *validator.Validator, toy *Toy) {
@@ -44,6 +70,9 @@ func ValidateToy(v *validator.Validator, toy *Toy) {
 	v.Check(toy.Manufacturer != "", "manufacturer", "manufacturer must be provided")
 	v.Check(toy.Value >= 1000, "value", "toy value must be more than 1000 tenge")
 	v.Check(toy.Value <= 150000, "value", "limit of toy's value is 150.000 tenge")
+	v.Check(validator.PermittedValue(toy.Language, SearchLanguages...), "language", "language must be one of english, russian")
+	v.Check(len(toy.SupplierSKU) <= 100, "supplier_sku", "supplier_sku must not be more than 100 bytes long")
+	v.Check(len(toy.Attributes) <= 50, "attributes", "attributes must not be more than 50")
 }
 
 type ToyModel struct {
@@ -55,20 +84,89 @@ type ToyRepository interface {
 	Get(id int64) (*Toy, error)
 	Update(toy *Toy) error
 	Delete(id int64) error
-	GetAll(title string, skills []string, categories []string, recAge string, filters Filters) ([]*Toy, Metadata, error)
+	InsertAudited(toy *Toy, actor audit.Actor) error
+	UpdateAudited(toy *Toy, actor audit.Actor) error
+	DeleteAudited(id int64, version int32, actor audit.Actor) error
+	GetAll(filter ToyFilter, filters Filters) ([]*Toy, Metadata, error)
+	GetFacets(filter ToyFilter, names []string) (Facets, error)
+	Suggest(q string, limit int) ([]*Suggestion, error)
+	DidYouMean(q string) (string, error)
+	ExistingSupplierSKUs(skus []string) (existing, trashed map[string]bool, err error)
+	UnknownTerms(kind TaxonomyKind, values []string) ([]string, error)
+	UnknownManufacturers(names []string) ([]string, error)
+	AttributeSchemas(categories []string) (map[string]AttributeSchema, error)
+	Import(toys []*Toy, upsert bool, actor audit.Actor) (created, updated int, err error)
+	Export(ctx context.Context, filter ToyFilter, fn func(toy *Toy) error) error
+	RestoreAudited(id int64, actor audit.Actor) (*Toy, error)
+	GetDeleted(filters Filters) ([]*Toy, Metadata, error)
 }
 
-func (t ToyModel) Insert(toy *Toy) error {
-	query := `
-INSERT INTO toys (title, desc, details, skills, categories, images, recommended_age, manufacturer, value, is_available, wait_list)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
-RETURNING id, created_at`
+// querier is satisfied by both *sql.DB and *sql.Tx, so that the same queries can run on
+// their own or as part of an audited transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
 
-	args := []any{toy.Title, toy.Description, pq.Array(toy.Details), pq.Array(toy.Skills), pq.Array(toy.Categories), pq.Array(toy.Images), toy.RecommendedAge, toy.Manufacturer, toy.Value, toy.IsAvailable, pq.Array(toy.WaitList)}
+func (t ToyModel) Insert(toy *Toy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return insertToy(ctx, t.DB, toy)
+}
 
+// InsertAudited inserts the toy and records a "toy.create" audit event in the same
+// transaction.
+func (t ToyModel) InsertAudited(toy *Toy, actor audit.Actor) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	return t.DB.QueryRowContext(ctx, query, args...).Scan(&toy.ID, &toy.CreatedAt)
+
+	return audit.Run(ctx, t.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		err := insertToy(ctx, tx, toy)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, "toy.create", "toy", strconv.FormatInt(toy.ID, 10), nil, toy)
+	})
+}
+
+// insertToy replaces the categories, skills and manufacturer of the toy with their
+// names as stored in the taxonomy and the manufacturers table, inserts it and links it
+// to them.
+func insertToy(ctx context.Context, q querier, toy *Toy) error {
+	terms, err := resolveToyTerms(ctx, q, toy)
+	if err != nil {
+		return err
+	}
+
+	err = validateToyAttributes(ctx, q, terms, toy)
+	if err != nil {
+		return err
+	}
+
+	err = resolveManufacturers(ctx, q, toy)
+	if err != nil {
+		return err
+	}
+
+	query := `
+INSERT INTO toys (title, "desc", details, skills, categories, images, recommended_age, manufacturer, value, is_available, wait_list, language, supplier_sku, manufacturer_id, attributes)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13, ''), $14, $15)
+RETURNING id, created_at, version, updated_at`
+
+	args := []any{toy.Title, toy.Description, pq.Array(toy.Details), pq.Array(toy.Skills), pq.Array(toy.Categories), pq.Array(toy.Images), toy.RecommendedAge, toy.Manufacturer, toy.Value, toy.IsAvailable, pq.Array(toy.WaitList), toy.Language, toy.SupplierSKU, toy.ManufacturerID, toy.Attributes}
+
+	err = q.QueryRowContext(ctx, query, args...).Scan(&toy.ID, &toy.CreatedAt, &toy.Version, &toy.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	return linkToyTerms(ctx, q, terms, toy)
 }
 
 func (t ToyModel) Get(id int64) (*Toy, error) {
@@ -76,17 +174,28 @@ func (t ToyModel) Get(id int64) (*Toy, error) {
 		return nil, ErrRecordNotFound
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getToy(ctx, t.DB, id, false)
+}
+
+// getToy fetches a single toy, unless it's in the trash. With forUpdate set the row is
+// locked until the end of the surrounding transaction, so that the audit log sees the
+// exact state being changed.
+func getToy(ctx context.Context, q querier, id int64, forUpdate bool) (*Toy, error) {
 	query := `
-SELECT id, created_at, title, desc, details ,skills, categories, images, recommended_age, manufacturer, value, is_available, wait_list
+SELECT id, created_at, title, "desc", details ,skills, categories, images, recommended_age, manufacturer, value, is_available, wait_list, language::text, version, updated_at, COALESCE(supplier_sku, ''), COALESCE(manufacturer_id, 0), attributes, rating, rating_count
 FROM toys
-WHERE id = $1
+WHERE id = $1 AND deleted_at IS NULL
 `
-	var toy Toy
+	if forUpdate {
+		query += "FOR UPDATE"
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	var toy Toy
 
-	err := t.DB.QueryRowContext(ctx, query, id).Scan(
+	err := q.QueryRowContext(ctx, query, id).Scan(
 		&toy.ID,
 		&toy.CreatedAt,
 		&toy.Title,
@@ -100,6 +209,14 @@ WHERE id = $1
 		&toy.Value,
 		&toy.IsAvailable,
 		pq.Array(&toy.WaitList),
+		&toy.Language,
+		&toy.Version,
+		&toy.UpdatedAt,
+		&toy.SupplierSKU,
+		&toy.ManufacturerID,
+		&toy.Attributes,
+		&toy.Rating,
+		&toy.RatingCount,
 	)
 
 	if err != nil {
@@ -115,30 +232,84 @@ WHERE id = $1
 }
 
 func (t ToyModel) Update(toy *Toy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return updateToy(ctx, t.DB, toy)
+}
+
+// UpdateAudited updates the toy and records a "toy.update" audit event, with the state
+// of the toy before and after the change, in the same transaction.
+func (t ToyModel) UpdateAudited(toy *Toy, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return audit.Run(ctx, t.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		before, err := getToy(ctx, tx, toy.ID, true)
+		if err != nil {
+			if errors.Is(err, ErrRecordNotFound) {
+				return nil, ErrEditConflict
+			}
+			return nil, err
+		}
+
+		// The row is locked now, so the version can't change until the update.
+		if before.Version != toy.Version {
+			return nil, ErrEditConflict
+		}
+
+		err = updateToy(ctx, tx, toy)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, "toy.update", "toy", strconv.FormatInt(toy.ID, 10), before, toy)
+	})
+}
+
+// updateToy only updates the toy if its version is still the one the toy was read
+// with. Otherwise somebody else changed it in the meantime, and ErrEditConflict is
+// returned. Like insertToy it keeps the toy in line with the taxonomy.
+func updateToy(ctx context.Context, q querier, toy *Toy) error {
+	terms, err := resolveToyTerms(ctx, q, toy)
+	if err != nil {
+		return err
+	}
+
+	err = validateToyAttributes(ctx, q, terms, toy)
+	if err != nil {
+		return err
+	}
+
+	err = resolveManufacturers(ctx, q, toy)
+	if err != nil {
+		return err
+	}
 
 	query := `UPDATE toys
-SET title = $1, desc = $2, details = $3, skills = $4, categories = $5, images = $6, recommended_age = $7, manufacturer = $8, value = $9, is_available = $10, wait_list = $11
-WHERE id = $12
-RETURNING id
+SET title = $1, "desc" = $2, details = $3, skills = $4, categories = $5, images = $6, recommended_age = $7, manufacturer = $8, value = $9, is_available = $10, wait_list = $11, language = $12, manufacturer_id = $13, attributes = $14, version = version + 1, updated_at = now()
+WHERE id = $15 AND version = $16
+RETURNING version, updated_at
 `
 	args := []any{
 		toy.Title,
 		toy.Description,
 		pq.Array(toy.Details),
 		pq.Array(toy.Skills),
-		pq.Array(toy.Images),
 		pq.Array(toy.Categories),
+		pq.Array(toy.Images),
 		toy.RecommendedAge,
 		toy.Manufacturer,
 		toy.Value,
 		toy.IsAvailable,
-		toy.WaitList,
+		pq.Array(toy.WaitList),
+		toy.Language,
+		toy.ManufacturerID,
+		toy.Attributes,
+		toy.ID,
+		toy.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := t.DB.QueryRowContext(ctx, query, args...).Scan(&toy.ID)
+	err = q.QueryRowContext(ctx, query, args...).Scan(&toy.Version, &toy.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -148,7 +319,7 @@ RETURNING id
 		}
 
 	}
-	return nil
+	return linkToyTerms(ctx, q, terms, toy)
 
 }
 
@@ -158,15 +329,51 @@ func (t ToyModel) Delete(id int64) error {
 		return ErrRecordNotFound
 	}
 
-	query := `
-DELETE FROM toys
-WHERE id = 1$
-`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return deleteToy(ctx, t.DB, id)
+}
+
+// DeleteAudited moves the toy to the trash and records a "toy.delete" audit event, with
+// the last state of the toy, in the same transaction. Unless the version is 0, the toy is only
+// deleted if it still has that version, and ErrEditConflict is returned otherwise.
+func (t ToyModel) DeleteAudited(id int64, version int32, actor audit.Actor) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := t.DB.ExecContext(ctx, query, id)
+	return audit.Run(ctx, t.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		before, err := getToy(ctx, tx, id, true)
+		if err != nil {
+			return nil, err
+		}
+
+		if version != 0 && before.Version != version {
+			return nil, ErrEditConflict
+		}
+
+		err = deleteToy(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, "toy.delete", "toy", strconv.FormatInt(id, 10), before, nil)
+	})
+}
+
+// deleteToy only moves the toy to the trash, since rentals and reports still refer to
+// it. It's deleted for good by PurgeDeleted() once the retention period is over.
+func deleteToy(ctx context.Context, q querier, id int64) error {
+	query := `
+UPDATE toys
+SET deleted_at = now(), version = version + 1, updated_at = now()
+WHERE id = $1 AND deleted_at IS NULL
+`
+
+	result, err := q.ExecContext(ctx, query, id)
 
 	if err != nil {
 		return err
@@ -184,23 +391,31 @@ WHERE id = 1$
 
 }
 
-func (t ToyModel) GetAll(title string, skills []string, categories []string, recAge string, filters Filters) ([]*Toy, Metadata, error) {
+// GetAll lists the toys matching the filter. The title is a search term which is
+// matched against the weighted search vector of title, manufacturer and description.
+// Sorting by "relevance" orders the toys by their ts_rank for the term.
+//
+// Toys are paginated by page number, unless the filters ask for cursor pagination, in
+// which case getAllByCursor() takes over.
+func (t ToyModel) GetAll(filter ToyFilter, filters Filters) ([]*Toy, Metadata, error) {
+	if filters.UseCursor {
+		return t.getAllByCursor(filter, filters)
+	}
+
+	from, where, b := toyListQuery(filter)
+
 	query := fmt.Sprintf(`
-SELECT count(*) OVER(), id, created_at, title, desc, details, skills, categories, recommended_age, manufacturer, value, is_available, wait_list
-FROM toys
-WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-AND (skills @> $2 OR $2 = '{}')
-AND (categories @> $3 OR $3 = '{}')
-AND (recommended_age @> $4 OR $4 = '{}')
-ORDER BY %s %s, id ASC
-LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+SELECT count(*) OVER(), %s,
+	%s
+FROM %s
+WHERE %s
+ORDER BY %s
+LIMIT %s OFFSET %s`, toyListColumns, toySearchColumns(filter), from, where, filters.orderBy(false), b.Arg(filters.limit()), b.Arg(filters.offset()))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{title, pq.Array(skills), pq.Array(categories), pq.Array(recAge), filters.limit(), filters.offset()}
-
-	rows, err := t.DB.QueryContext(ctx, query, args...)
+	rows, err := t.DB.QueryContext(ctx, query, b.args...)
 	if err != nil {
 		return nil, Metadata{}, err // Update this to return an empty Metadata struct.
 	}
@@ -212,29 +427,12 @@ LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
 	toys := []*Toy{}
 
 	for rows.Next() {
-		var toy Toy
-
-		err := rows.Scan(
-			&totalRecords,
-			&toy.ID,
-			&toy.CreatedAt,
-			&toy.Title,
-			&toy.Description,
-			pq.Array(&toy.Details),
-			pq.Array(&toy.Skills),
-			pq.Array(&toy.Categories),
-			&toy.RecommendedAge,
-			&toy.Manufacturer,
-			&toy.Value,
-			&toy.IsAvailable,
-			pq.Array(&toy.WaitList),
-		)
-
+		toy, _, err := scanListedToy(rows, filter, &totalRecords)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
-		toys = append(toys, &toy)
+		toys = append(toys, toy)
 
 	}
 
@@ -248,3 +446,60 @@ LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
 	return toys, metadata, nil
 
 }
+
+// toyListColumns are the columns of a toy selected by the listings. They are followed by
+// the toySearchColumns() and scanned by scanListedToy().
+const toyListColumns = `id, created_at, title, "desc", details, skills, categories, recommended_age, manufacturer, value, is_available, wait_list, language::text, version, updated_at, attributes, rating, rating_count`
+
+// toySearchColumns returns the relevance and the highlighted title and description of
+// the listed toys. Without a search term there is nothing to rank or highlight.
+func toySearchColumns(filter ToyFilter) string {
+	if filter.Title == "" {
+		return `0 AS relevance, '', ''`
+	}
+	return fmt.Sprintf(`ts_rank(search, q.query) AS relevance, ts_headline(language, title, q.query, '%s'), ts_headline(language, "desc", q.query, '%s')`,
+		titleHeadlineOptions, descriptionHeadlineOptions)
+}
+
+// scanListedToy scans a row of a toy listing. The extra destinations are scanned from
+// the columns selected before the toyListColumns.
+func scanListedToy(rows *sql.Rows, filter ToyFilter, extra ...any) (*Toy, float64, error) {
+	var toy Toy
+	var relevance float64
+	var highlight ToyHighlight
+
+	dest := append(extra,
+		&toy.ID,
+		&toy.CreatedAt,
+		&toy.Title,
+		&toy.Description,
+		pq.Array(&toy.Details),
+		pq.Array(&toy.Skills),
+		pq.Array(&toy.Categories),
+		&toy.RecommendedAge,
+		&toy.Manufacturer,
+		&toy.Value,
+		&toy.IsAvailable,
+		pq.Array(&toy.WaitList),
+		&toy.Language,
+		&toy.Version,
+		&toy.UpdatedAt,
+		&toy.Attributes,
+		&toy.Rating,
+		&toy.RatingCount,
+		&relevance,
+		&highlight.Title,
+		&highlight.Description,
+	)
+
+	err := rows.Scan(dest...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if filter.Title != "" {
+		toy.Highlight = &highlight
+	}
+
+	return &toy, relevance, nil
+}
@@ -0,0 +1,61 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"github.com/lib/pq"
+)
+
+// Export calls fn for every toy matching the filter, in the order of their IDs. The toys
+// are read from the database one by one while fn runs, so the size of an export isn't
+// limited by memory. The export stops at the first error returned by fn, or when ctx is
+// done.
+func (t ToyModel) Export(ctx context.Context, filter ToyFilter, fn func(toy *Toy) error) error {
+	from, where, b := toyListQuery(filter)
+
+	query := fmt.Sprintf(`
+SELECT id, created_at, title, "desc", details, skills, categories, images, recommended_age, manufacturer, value, is_available, language::text, version, updated_at, COALESCE(supplier_sku, ''), attributes
+FROM %s
+WHERE %s
+ORDER BY id`, from, where)
+
+	rows, err := t.DB.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var toy Toy
+
+		err := rows.Scan(
+			&toy.ID,
+			&toy.CreatedAt,
+			&toy.Title,
+			&toy.Description,
+			pq.Array(&toy.Details),
+			pq.Array(&toy.Skills),
+			pq.Array(&toy.Categories),
+			pq.Array(&toy.Images),
+			&toy.RecommendedAge,
+			&toy.Manufacturer,
+			&toy.Value,
+			&toy.IsAvailable,
+			&toy.Language,
+			&toy.Version,
+			&toy.UpdatedAt,
+			&toy.SupplierSKU,
+			&toy.Attributes,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&toy)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,125 @@
+package data
+
+import (
+	"github.com/lib/pq"
+	"strconv"
+	"strings"
+	"time"
+	"toy-rental-system/internal/validator"
+)
+
+// ToyFilter holds the filters of the toy listing. Zero values mean "don't filter on
+// this field", so MinValue and MaxValue of 0 don't restrict the value at all.
+type ToyFilter struct {
+	Title             string
+	Skills            []string
+	Categories        []string
+	ExcludeCategories []string
+	RecommendedAge    string
+	Manufacturer      string
+	ManufacturerID    int64
+	MinValue          int64
+	MaxValue          int64
+	AvailableOnly     bool
+	CreatedAfter      time.Time
+	Attributes        []AttributeFilter
+}
+
+func ValidateToyFilter(v *validator.Validator, f ToyFilter) {
+	v.Check(len(f.Title) <= 200, "title", "must not be more than 200 bytes long")
+	v.Check(f.ManufacturerID >= 0, "manufacturer_id", "must not be negative")
+	v.Check(f.MinValue >= 0, "min_value", "must not be negative")
+	v.Check(f.MaxValue >= 0, "max_value", "must not be negative")
+	v.Check(f.MaxValue == 0 || f.MinValue <= f.MaxValue, "max_value", "must not be less than min_value")
+	v.Check(len(f.Attributes) <= 10, "attr", "must not filter on more than 10 attributes")
+	validateAttributeFilters(v, f.Attributes)
+}
+
+// queryBuilder collects the conditions of a WHERE clause together with their arguments.
+// Values are only ever passed as placeholders, which Arg() numbers in the order the
+// arguments are added, so the query text never contains anything from the request.
+type queryBuilder struct {
+	conditions []string
+	args       []any
+}
+
+// Arg adds an argument and returns its placeholder.
+func (b *queryBuilder) Arg(value any) string {
+	b.args = append(b.args, value)
+	return "$" + strconv.Itoa(len(b.args))
+}
+
+// Where adds a condition. All conditions are combined with AND.
+func (b *queryBuilder) Where(condition string) {
+	b.conditions = append(b.conditions, condition)
+}
+
+func (b *queryBuilder) where() string {
+	if len(b.conditions) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(b.conditions, "\nAND ")
+}
+
+// toyListQuery returns the FROM and WHERE clauses selecting the toys which match the
+// filter, together with the builder holding their arguments. It's shared by GetAll()
+// and GetFacets() so that both see the same toys. When there is a search term, the
+// FROM clause provides it as q.query. Toys in the trash never match.
+func toyListQuery(filter ToyFilter) (string, string, *queryBuilder) {
+	b := &queryBuilder{}
+	from := "toys"
+
+	b.Where("deleted_at IS NULL")
+
+	if filter.Title != "" {
+		term := b.Arg(filter.Title)
+		from += ", (SELECT websearch_to_tsquery('english', " + term + ") || websearch_to_tsquery('russian', " + term + ") AS query) AS q"
+		b.Where("search @@ q.query")
+	}
+	// A toy has to match every skill and category, either directly or through one of
+	// the terms below it in the taxonomy.
+	for _, skill := range filter.Skills {
+		b.Where(termFilter(TaxonomySkills, b.Arg(pq.Array([]string{Slugify(skill)}))))
+	}
+	for _, category := range filter.Categories {
+		b.Where(termFilter(TaxonomyCategories, b.Arg(pq.Array([]string{Slugify(category)}))))
+	}
+	if len(filter.ExcludeCategories) > 0 {
+		slugs := make([]string, len(filter.ExcludeCategories))
+		for i, category := range filter.ExcludeCategories {
+			slugs[i] = Slugify(category)
+		}
+		b.Where("NOT " + termFilter(TaxonomyCategories, b.Arg(pq.Array(slugs))))
+	}
+	if filter.RecommendedAge != "" {
+		b.Where("recommended_age = " + b.Arg(filter.RecommendedAge))
+	}
+	if filter.Manufacturer != "" {
+		b.Where("lower(manufacturer) = lower(" + b.Arg(filter.Manufacturer) + ")")
+	}
+	if filter.ManufacturerID > 0 {
+		b.Where("manufacturer_id = " + b.Arg(filter.ManufacturerID))
+	}
+	if filter.MinValue > 0 {
+		b.Where("value >= " + b.Arg(filter.MinValue))
+	}
+	if filter.MaxValue > 0 {
+		b.Where("value <= " + b.Arg(filter.MaxValue))
+	}
+	if filter.AvailableOnly {
+		b.Where("is_available")
+	}
+	if !filter.CreatedAfter.IsZero() {
+		b.Where("created_at > " + b.Arg(filter.CreatedAfter))
+	}
+	for _, f := range filter.Attributes {
+		condition := "attributes @? " + b.Arg(f.jsonPath()) + "::jsonpath"
+		if f.Op == "ne" {
+			// Toys without the attribute don't have the value either.
+			condition = "NOT " + condition
+		}
+		b.Where(condition)
+	}
+
+	return from, b.where(), b
+}
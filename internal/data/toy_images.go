@@ -0,0 +1,455 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strconv"
+	"strings"
+	"time"
+	"toy-rental-system/internal/audit"
+)
+
+// MaxToyImages is the number of images a toy can have.
+const MaxToyImages = 20
+
+// The thumbnails of an image are generated in the background. Images which can't be
+// scaled, like GIFs, skip this.
+const (
+	ThumbnailsPending = "pending"
+	ThumbnailsDone    = "done"
+	ThumbnailsFailed  = "failed"
+	ThumbnailsSkipped = "skipped"
+)
+
+// ToyImage is a photo of a toy which was uploaded to the blob storage. The images of a
+// toy are ordered by position, and at most one of them is the primary image, which is
+// shown in the catalog.
+type ToyImage struct {
+	ID          int64     `json:"id"`
+	ToyID       int64     `json:"-"`
+	Key         string    `json:"-"`
+	URL         string    `json:"url"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Position    int       `json:"position"`
+	IsPrimary   bool      `json:"primary"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// Width and Height are known once the thumbnails have been generated.
+	Width           int                `json:"width,omitempty"`
+	Height          int                `json:"height,omitempty"`
+	ThumbnailStatus string             `json:"thumbnail_status"`
+	Variants        []*ToyImageVariant `json:"variants,omitempty"`
+	// SrcSet lists the thumbnails and the original in the format of the srcset
+	// attribute of an <img> tag.
+	SrcSet string `json:"srcset,omitempty"`
+}
+
+// ToyImageVariant is a scaled down copy of a toy image.
+type ToyImageVariant struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Key    string `json:"-"`
+	URL    string `json:"url"`
+}
+
+// SetURLs fills in the URLs of the image and its variants, and the srcset, using the
+// url function of the storage the files are in.
+func (i *ToyImage) SetURLs(url func(key string) string) {
+	i.URL = url(i.Key)
+
+	candidates := make([]string, 0, len(i.Variants)+1)
+	for _, variant := range i.Variants {
+		variant.URL = url(variant.Key)
+		candidates = append(candidates, fmt.Sprintf("%s %dw", variant.URL, variant.Width))
+	}
+	if i.Width > 0 {
+		candidates = append(candidates, fmt.Sprintf("%s %dw", i.URL, i.Width))
+	}
+
+	i.SrcSet = strings.Join(candidates, ", ")
+}
+
+// Keys returns the storage keys of the image and all its variants.
+func (i *ToyImage) Keys() []string {
+	keys := []string{i.Key}
+	for _, variant := range i.Variants {
+		keys = append(keys, variant.Key)
+	}
+	return keys
+}
+
+type ToyImageModel struct {
+	DB *sql.DB
+}
+
+type ToyImageRepository interface {
+	InsertAudited(image *ToyImage, actor audit.Actor) error
+	GetAllForToy(toyID int64) ([]*ToyImage, error)
+	ArrangeAudited(toyID int64, order []int64, primaryID int64, actor audit.Actor) error
+	DeleteAudited(toyID, id int64, actor audit.Actor) (*ToyImage, error)
+	ClaimPendingThumbnails(limit int, lease time.Duration) ([]*ToyImage, error)
+	CompleteThumbnails(id int64, width, height int, variants []*ToyImageVariant) error
+	RetryThumbnails(id int64, message string, maxAttempts int) error
+}
+
+// toyImageColumns are selected by all queries which return an image, in the order
+// expected by scanToyImage().
+const toyImageColumns = `id, toy_id, storage_key, content_type, size, position, is_primary, created_at, width, height, thumbnail_status`
+
+func scanToyImage(row scanner) (*ToyImage, error) {
+	var image ToyImage
+
+	err := row.Scan(&image.ID, &image.ToyID, &image.Key, &image.ContentType, &image.Size, &image.Position, &image.IsPrimary, &image.CreatedAt, &image.Width, &image.Height, &image.ThumbnailStatus)
+	if err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// loadVariants adds the variants to the images, ordered by width.
+func loadVariants(ctx context.Context, q querier, images []*ToyImage) error {
+	if len(images) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*ToyImage, len(images))
+	ids := make([]int64, 0, len(images))
+	for _, image := range images {
+		byID[image.ID] = image
+		ids = append(ids, image.ID)
+	}
+
+	query := `
+SELECT image_id, width, height, storage_key
+FROM toy_image_variants
+WHERE image_id = ANY($1)
+ORDER BY image_id, width`
+
+	rows, err := q.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var imageID int64
+		var variant ToyImageVariant
+
+		err := rows.Scan(&imageID, &variant.Width, &variant.Height, &variant.Key)
+		if err != nil {
+			return err
+		}
+		byID[imageID].Variants = append(byID[imageID].Variants, &variant)
+	}
+
+	return rows.Err()
+}
+
+// touchToy increments the version of the toy, since its images are part of it, and
+// locks it until the end of the transaction. This also keeps concurrent changes to the
+// images of the same toy apart.
+func touchToy(ctx context.Context, tx *sql.Tx, toyID int64) error {
+	err := tx.QueryRowContext(ctx, `UPDATE toys SET version = version + 1, updated_at = now() WHERE id = $1 RETURNING id`, toyID).Scan(&toyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+	return err
+}
+
+// InsertAudited adds the image after the other images of the toy and records a
+// "toy_image.create" audit event in the same transaction. The first image of a toy
+// always becomes the primary image.
+func (m ToyImageModel) InsertAudited(image *ToyImage, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return audit.Run(ctx, m.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		err := touchToy(ctx, tx, image.ToyID)
+		if err != nil {
+			return nil, err
+		}
+
+		if image.ThumbnailStatus == "" {
+			image.ThumbnailStatus = ThumbnailsPending
+		}
+
+		if image.IsPrimary {
+			_, err = tx.ExecContext(ctx, `UPDATE toy_images SET is_primary = false WHERE toy_id = $1`, image.ToyID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		query := `
+INSERT INTO toy_images (toy_id, storage_key, content_type, size, position, is_primary, thumbnail_status)
+SELECT $1, $2, $3, $4, COALESCE(max(position) + 1, 0), $5 OR count(*) = 0, $6
+FROM toy_images
+WHERE toy_id = $1
+RETURNING id, position, is_primary, created_at`
+
+		args := []any{image.ToyID, image.Key, image.ContentType, image.Size, image.IsPrimary, image.ThumbnailStatus}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&image.ID, &image.Position, &image.IsPrimary, &image.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, "toy_image.create", "toy_image", strconv.FormatInt(image.ID, 10), nil, image)
+	})
+}
+
+func (m ToyImageModel) GetAllForToy(toyID int64) ([]*ToyImage, error) {
+	query := `
+SELECT ` + toyImageColumns + `
+FROM toy_images
+WHERE toy_id = $1
+ORDER BY position, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, toyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []*ToyImage{}
+	for rows.Next() {
+		image, err := scanToyImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = loadVariants(ctx, m.DB, images)
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// ArrangeAudited moves the images of the toy into the given order, if there is one,
+// and makes the image with primaryID the primary image, unless it's 0. The order must
+// list every image of the toy. A "toy_image.arrange" audit event is recorded for the
+// toy in the same transaction.
+func (m ToyImageModel) ArrangeAudited(toyID int64, order []int64, primaryID int64, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return audit.Run(ctx, m.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		err := touchToy(ctx, tx, toyID)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(order) > 0 {
+			_, err = tx.ExecContext(ctx, `UPDATE toy_images SET position = array_position($2, id) - 1 WHERE toy_id = $1`, toyID, pq.Array(order))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// The old primary image is cleared first, since the unique index is checked row
+		// by row.
+		if primaryID != 0 {
+			_, err = tx.ExecContext(ctx, `UPDATE toy_images SET is_primary = false WHERE toy_id = $1 AND is_primary`, toyID)
+			if err != nil {
+				return nil, err
+			}
+
+			result, err := tx.ExecContext(ctx, `UPDATE toy_images SET is_primary = true WHERE toy_id = $1 AND id = $2`, toyID, primaryID)
+			if err != nil {
+				return nil, err
+			}
+
+			rowsAffected, err := result.RowsAffected()
+			if err != nil {
+				return nil, err
+			}
+			if rowsAffected == 0 {
+				return nil, ErrRecordNotFound
+			}
+		}
+
+		after := map[string]any{"order": order, "primary": primaryID}
+		return audit.NewEvent(actor, "toy_image.arrange", "toy", strconv.FormatInt(toyID, 10), nil, after)
+	})
+}
+
+// DeleteAudited removes the image from the toy and records a "toy_image.delete" audit
+// event in the same transaction. It returns the image with its variants, so that the
+// caller can delete the files. If it was the primary image, the first remaining image
+// takes its place.
+func (m ToyImageModel) DeleteAudited(toyID, id int64, actor audit.Actor) (*ToyImage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var image *ToyImage
+
+	err := audit.Run(ctx, m.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		err := touchToy(ctx, tx, toyID)
+		if err != nil {
+			return nil, err
+		}
+
+		// The variants are deleted along with the image, so their keys are read first.
+		variants := &ToyImage{ID: id}
+		err = loadVariants(ctx, tx, []*ToyImage{variants})
+		if err != nil {
+			return nil, err
+		}
+
+		query := `
+DELETE FROM toy_images
+WHERE toy_id = $1 AND id = $2
+RETURNING ` + toyImageColumns
+
+		image, err = scanToyImage(tx.QueryRowContext(ctx, query, toyID, id))
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return nil, ErrRecordNotFound
+			default:
+				return nil, err
+			}
+		}
+
+		if image.IsPrimary {
+			query = `
+UPDATE toy_images SET is_primary = true
+WHERE id = (SELECT id FROM toy_images WHERE toy_id = $1 ORDER BY position, id LIMIT 1)`
+
+			_, err = tx.ExecContext(ctx, query, toyID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		image.Variants = variants.Variants
+		return audit.NewEvent(actor, "toy_image.delete", "toy_image", strconv.FormatInt(id, 10), image, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// ClaimPendingThumbnails returns up to limit images whose thumbnails are due to be
+// generated. The images are leased to the caller: nobody else claims them until the
+// lease runs out, after which a worker which died on them is retried.
+func (m ToyImageModel) ClaimPendingThumbnails(limit int, lease time.Duration) ([]*ToyImage, error) {
+	query := `
+UPDATE toy_images
+SET thumbnail_next_attempt_at = now() + $2 * interval '1 second'
+WHERE id IN (
+	SELECT id
+	FROM toy_images
+	WHERE thumbnail_status = 'pending' AND thumbnail_next_attempt_at <= now()
+	ORDER BY thumbnail_next_attempt_at
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING ` + toyImageColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []*ToyImage{}
+	for rows.Next() {
+		image, err := scanToyImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+
+	return images, rows.Err()
+}
+
+// CompleteThumbnails stores the variants generated for the image, together with the
+// size of the original. ErrRecordNotFound is returned if the image has been deleted in
+// the meantime.
+func (m ToyImageModel) CompleteThumbnails(id int64, width, height int, variants []*ToyImageVariant) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+UPDATE toy_images
+SET width = $2, height = $3, thumbnail_status = 'done', thumbnail_error = ''
+WHERE id = $1
+RETURNING toy_id`
+
+	var toyID int64
+
+	err = tx.QueryRowContext(ctx, query, id, width, height).Scan(&toyID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	// A retry may have stored some of the variants before.
+	query = `
+INSERT INTO toy_image_variants (image_id, width, height, storage_key)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (image_id, width) DO UPDATE SET height = EXCLUDED.height, storage_key = EXCLUDED.storage_key`
+
+	for _, variant := range variants {
+		_, err = tx.ExecContext(ctx, query, id, variant.Width, variant.Height, variant.Key)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The toy's JSON has changed, so its version is incremented like for any other
+	// change of its images.
+	err = touchToy(ctx, tx, toyID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RetryThumbnails records a failed attempt to generate the thumbnails of the image. The
+// next attempt waits twice as long as the one before, starting at 30 seconds, and after
+// maxAttempts the image is given up on.
+func (m ToyImageModel) RetryThumbnails(id int64, message string, maxAttempts int) error {
+	query := `
+UPDATE toy_images
+SET thumbnail_attempts = thumbnail_attempts + 1,
+	thumbnail_error = $2,
+	thumbnail_status = CASE WHEN thumbnail_attempts + 1 >= $3 THEN 'failed' ELSE 'pending' END,
+	thumbnail_next_attempt_at = now() + interval '30 seconds' * power(2, thumbnail_attempts)
+WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, message, maxAttempts)
+	return err
+}
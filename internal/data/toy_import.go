@@ -0,0 +1,174 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+	"toy-rental-system/internal/audit"
+)
+
+// ErrDuplicateSupplierSKU is returned when an import would create a second toy with the
+// supplier SKU of an existing toy.
+var ErrDuplicateSupplierSKU = errors.New("duplicate supplier sku")
+
+// ErrTrashedSupplierSKU is returned when an import would update a toy which is in the
+// trash. The toy has to be restored first.
+var ErrTrashedSupplierSKU = errors.New("supplier sku of a deleted toy")
+
+// importBatchSize is the number of toys inserted by a single statement. Every toy takes
+// 12 parameters, which keeps a batch far below the limit of 65535 parameters.
+const importBatchSize = 100
+
+// ExistingSupplierSKUs returns which of the SKUs already belong to a toy, and which of
+// those toys are in the trash.
+func (t ToyModel) ExistingSupplierSKUs(skus []string) (existing, trashed map[string]bool, err error) {
+	existing, trashed = map[string]bool{}, map[string]bool{}
+	if len(skus) == 0 {
+		return existing, trashed, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, `SELECT supplier_sku, deleted_at IS NOT NULL FROM toys WHERE supplier_sku = ANY($1)`, pq.Array(skus))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sku string
+		var deleted bool
+		err := rows.Scan(&sku, &deleted)
+		if err != nil {
+			return nil, nil, err
+		}
+		existing[sku] = true
+		trashed[sku] = deleted
+	}
+	return existing, trashed, rows.Err()
+}
+
+// Import inserts the toys in batches, all in one transaction, and records a single
+// "toy.import" audit event for them. With upsert set, a toy whose supplier SKU already
+// exists replaces the catalog data of the existing toy instead, while its availability
+// and wait list are kept. Toys in the trash are never updated: ErrTrashedSupplierSKU is
+// returned for them. The IDs, versions and timestamps of the toys are set from the
+// database.
+func (t ToyModel) Import(toys []*Toy, upsert bool, actor audit.Actor) (created, updated int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err = audit.Run(ctx, t.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		ids := make([]int64, 0, len(toys))
+
+		for start := 0; start < len(toys); start += importBatchSize {
+			end := start + importBatchSize
+			if end > len(toys) {
+				end = len(toys)
+			}
+
+			inserted, err := importToys(ctx, tx, toys[start:end], upsert)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, toy := range toys[start:end] {
+				ids = append(ids, toy.ID)
+			}
+			created += inserted
+			updated += end - start - inserted
+		}
+
+		summary := map[string]any{"created": created, "updated": updated, "ids": ids}
+		return audit.NewEvent(actor, "toy.import", "toy", "", nil, summary)
+	})
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return 0, 0, ErrDuplicateSupplierSKU
+		}
+		return 0, 0, err
+	}
+
+	return created, updated, nil
+}
+
+// importToys inserts a batch of toys with a single statement and returns how many of
+// them were inserted rather than updated.
+func importToys(ctx context.Context, tx *sql.Tx, toys []*Toy, upsert bool) (int, error) {
+	terms, err := resolveToyTerms(ctx, tx, toys...)
+	if err != nil {
+		return 0, err
+	}
+
+	err = validateToyAttributes(ctx, tx, terms, toys...)
+	if err != nil {
+		return 0, err
+	}
+
+	err = resolveManufacturers(ctx, tx, toys...)
+	if err != nil {
+		return 0, err
+	}
+
+	b := &queryBuilder{}
+	values := make([]string, len(toys))
+
+	for i, toy := range toys {
+		values[i] = fmt.Sprintf("(NULLIF(%s, ''), %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, '{}')",
+			b.Arg(toy.SupplierSKU), b.Arg(toy.Title), b.Arg(toy.Description), b.Arg(pq.Array(toy.Details)),
+			b.Arg(pq.Array(toy.Skills)), b.Arg(pq.Array(toy.Categories)), b.Arg(pq.Array(toy.Images)),
+			b.Arg(toy.RecommendedAge), b.Arg(toy.Manufacturer), b.Arg(toy.ManufacturerID), b.Arg(toy.Value), b.Arg(toy.IsAvailable), b.Arg(toy.Language), b.Arg(toy.Attributes))
+	}
+
+	query := `
+INSERT INTO toys (supplier_sku, title, "desc", details, skills, categories, images, recommended_age, manufacturer, manufacturer_id, value, is_available, language, attributes, wait_list)
+VALUES ` + strings.Join(values, ",\n")
+
+	if upsert {
+		query += `
+ON CONFLICT (supplier_sku) DO UPDATE
+SET title = EXCLUDED.title, "desc" = EXCLUDED."desc", details = EXCLUDED.details, skills = EXCLUDED.skills, categories = EXCLUDED.categories, images = EXCLUDED.images, recommended_age = EXCLUDED.recommended_age, manufacturer = EXCLUDED.manufacturer, manufacturer_id = EXCLUDED.manufacturer_id, value = EXCLUDED.value, language = EXCLUDED.language, attributes = EXCLUDED.attributes, version = toys.version + 1, updated_at = now()
+WHERE toys.deleted_at IS NULL`
+	}
+
+	// A row which was updated by the upsert has the xmax of the updating transaction,
+	// while a freshly inserted one has none.
+	query += `
+RETURNING id, created_at, version, updated_at, xmax = 0`
+
+	rows, err := tx.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	// The rows are returned in the order of the VALUES list. A toy in the trash is
+	// skipped by the WHERE clause of the upsert and returns no row at all, which fails
+	// the whole import below.
+	inserted, i := 0, 0
+	for ; rows.Next(); i++ {
+		var isNew bool
+		err := rows.Scan(&toys[i].ID, &toys[i].CreatedAt, &toys[i].Version, &toys[i].UpdatedAt, &isNew)
+		if err != nil {
+			return 0, err
+		}
+		if isNew {
+			inserted++
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	if i < len(toys) {
+		return 0, ErrTrashedSupplierSKU
+	}
+
+	return inserted, linkToyTerms(ctx, tx, terms, toys...)
+}
@@ -0,0 +1,193 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strconv"
+	"time"
+	"toy-rental-system/internal/audit"
+)
+
+// RestoreAudited takes the toy out of the trash and records a "toy.restore" audit event
+// in the same transaction. ErrRecordNotFound is returned if the toy isn't in the trash.
+func (t ToyModel) RestoreAudited(id int64, actor audit.Actor) (*Toy, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var toy *Toy
+
+	err := audit.Run(ctx, t.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		query := `
+UPDATE toys
+SET deleted_at = NULL, version = version + 1, updated_at = now()
+WHERE id = $1 AND deleted_at IS NOT NULL
+RETURNING id`
+
+		err := tx.QueryRowContext(ctx, query, id).Scan(&id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrRecordNotFound
+			}
+			return nil, err
+		}
+
+		toy, err = getToy(ctx, tx, id, false)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, "toy.restore", "toy", strconv.FormatInt(id, 10), nil, toy)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return toy, nil
+}
+
+// GetDeleted lists the toys in the trash, the most recently deleted first.
+func (t ToyModel) GetDeleted(filters Filters) ([]*Toy, Metadata, error) {
+	query := fmt.Sprintf(`
+SELECT count(*) OVER(), deleted_at, %s,
+	%s
+FROM toys
+WHERE deleted_at IS NOT NULL
+ORDER BY deleted_at DESC, id DESC
+LIMIT $1 OFFSET $2`, toyListColumns, toySearchColumns(ToyFilter{}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	toys := []*Toy{}
+
+	for rows.Next() {
+		var deletedAt time.Time
+
+		toy, _, err := scanListedToy(rows, ToyFilter{}, &totalRecords, &deletedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		toy.DeletedAt = &deletedAt
+		toys = append(toys, toy)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return toys, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// PurgeDeleted deletes up to limit toys for good, which were moved to the trash before
+// the cutoff. Their images go with them, and a "toy.purge" audit event is recorded for
+// each toy. Toys which have been returned or reviewed stay in the trash, so that their
+// rental history is kept. It returns the number of purged toys and the storage keys of their image
+// files, which the caller deletes once the rows are gone.
+func (t ToyModel) PurgeDeleted(cutoff time.Time, limit int) (int, []string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	// Toys which are being restored right now are skipped rather than waited for.
+	query := `
+SELECT id
+FROM toys t
+WHERE deleted_at < $1
+	AND NOT EXISTS (SELECT 1 FROM toy_returns r WHERE r.toy_id = t.id)
+	AND NOT EXISTS (SELECT 1 FROM toy_reviews v WHERE v.toy_id = t.id)
+ORDER BY deleted_at, id
+LIMIT $2
+FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query, cutoff, limit)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	if len(ids) == 0 {
+		return 0, nil, nil
+	}
+
+	query = `
+SELECT storage_key FROM toy_images WHERE toy_id = ANY($1)
+UNION ALL
+SELECT v.storage_key FROM toy_image_variants v JOIN toy_images i ON i.id = v.image_id WHERE i.toy_id = ANY($1)`
+
+	rows, err = tx.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		err := rows.Scan(&key)
+		if err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM toys WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for _, id := range ids {
+		e, err := audit.NewEvent(audit.Actor{}, "toy.purge", "toy", strconv.FormatInt(id, 10), nil, nil)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		err = audit.Insert(ctx, tx, e)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return len(ids), keys, nil
+}
@@ -1,11 +1,28 @@
 package entity
 
+import "time"
+
+// Subscription is a purchase of tokens at the price of a plan. It stays pending until
+// the payment is confirmed, and only then are the tokens credited to the user.
 type Subscription struct {
+	ID              int64      `json:"id"`
+	UserID          int64      `json:"user_id"`
+	Tokens          int64      `json:"tokens"`
+	Price           int64      `json:"price"`
+	Currency        string     `json:"currency"`
+	PaymentIntentID string     `json:"payment_intent_id,omitempty"`
+	ConfirmedAt     *time.Time `json:"confirmed_at,omitempty"`
+
+	// ClientSecret lets the client complete the payment with Stripe. It's only returned
+	// when the subscription is created, and never stored.
+	ClientSecret string `json:"client_secret,omitempty"`
+}
 
+// SubscriptionPlan is a number of tokens sold at a price set by us, never by the client.
+type SubscriptionPlan struct {
 	ID       int64  `json:"id"`
-	UserID   int64  `json:"user_id"`
+	Name     string `json:"name"`
 	Tokens   int64  `json:"tokens"`
 	Price    int64  `json:"price"`
 	Currency string `json:"currency"`
-
 }
@@ -0,0 +1,19 @@
+package entity
+
+import "time"
+
+// Reasons for a change of a user's token balance.
+const (
+	TokenLedgerSubscription = "subscription"
+)
+
+// TokenLedgerEntry records one change of a user's token balance. Amount is positive for
+// tokens credited to the user and negative for tokens spent.
+type TokenLedgerEntry struct {
+	ID             int64     `json:"id"`
+	UserID         int64     `json:"user_id"`
+	Amount         int64     `json:"amount"`
+	Reason         string    `json:"reason"`
+	SubscriptionID *int64    `json:"subscription_id,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
@@ -1,10 +1,141 @@
 package entity
 
+import (
+	"fmt"
+	"toy-rental-system/internal/validator"
+)
+
+// Roles which can be assigned to a user. Staff and admins can manage the catalog and
+// other users' accounts.
+const (
+	RoleUser  = "user"
+	RoleStaff = "staff"
+	RoleAdmin = "admin"
+)
 
 type User struct {
 	ID       int    `json:"id"`
 	Username string `json:"username"`
 	Password string `json:"password"`
 	Tokens   int    `json:"tokens"`
+	Role     string `json:"role"`
+
+	// The TOTP secret is only set once the user has started enrolling in two-factor
+	// authentication, and TOTPEnabled once they confirmed it with a valid code.
+	TOTPSecret  string `json:"-"`
+	TOTPEnabled bool   `json:"totp_enabled"`
+
+	Email              string             `json:"email"`
+	DisplayName        string             `json:"display_name"`
+	Phone              string             `json:"phone"`
+	Addresses          []Address          `json:"addresses"`
+	ContactPreferences ContactPreferences `json:"contact_preferences"`
+}
+
+// Address is a delivery address of a user. At most one of them is the default.
+type Address struct {
+	ID         int64  `json:"id"`
+	Label      string `json:"label"`
+	Recipient  string `json:"recipient"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+	IsDefault  bool   `json:"is_default"`
+}
+
+// ContactPreferences records how the user agreed to be contacted. Service messages,
+// like due dates, are sent by email unless Email is false.
+type ContactPreferences struct {
+	Email     bool `json:"email"`
+	SMS       bool `json:"sms"`
+	Marketing bool `json:"marketing"`
+}
+
+// Profile is the part of a user which is shown to the user themselves. Unlike User it
+// never includes the password.
+type Profile struct {
+	ID                 int                `json:"id"`
+	Username           string             `json:"username"`
+	Email              string             `json:"email"`
+	DisplayName        string             `json:"display_name"`
+	Phone              string             `json:"phone"`
+	Role               string             `json:"role"`
+	Tokens             int                `json:"tokens"`
+	TOTPEnabled        bool               `json:"totp_enabled"`
+	Addresses          []Address          `json:"addresses"`
+	ContactPreferences ContactPreferences `json:"contact_preferences"`
+}
+
+func (u *User) Profile() *Profile {
+	addresses := u.Addresses
+	if addresses == nil {
+		addresses = []Address{}
+	}
+
+	return &Profile{
+		ID:                 u.ID,
+		Username:           u.Username,
+		Email:              u.Email,
+		DisplayName:        u.DisplayName,
+		Phone:              u.Phone,
+		Role:               u.Role,
+		Tokens:             u.Tokens,
+		TOTPEnabled:        u.TOTPEnabled,
+		Addresses:          addresses,
+		ContactPreferences: u.ContactPreferences,
+	}
+}
+
+// AnonymousUser represents a request which didn't carry any authentication token.
+var AnonymousUser = &User{}
+
+// IsAnonymous checks if a User instance is the AnonymousUser.
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
+// IsStaff reports whether the user has either the staff or the admin role.
+func (u *User) IsStaff() bool {
+	return u.Role == RoleStaff || u.Role == RoleAdmin
 }
 
+func ValidateEmail(v *validator.Validator, email string) {
+	v.Check(email != "", "email", "must be provided")
+	v.Check(len(email) <= 254, "email", "must not be more than 254 bytes long")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+}
+
+// ValidateProfile checks the fields a user can change about themselves. The email is
+// optional for accounts created before it was introduced, but can't be removed again
+// once it has been set.
+func ValidateProfile(v *validator.Validator, user *User) {
+	if user.Email != "" {
+		ValidateEmail(v, user.Email)
+	}
+
+	v.Check(len(user.DisplayName) <= 100, "display_name", "must not be more than 100 bytes long")
+
+	if user.Phone != "" {
+		v.Check(validator.Matches(user.Phone, validator.PhoneRX), "phone", "must be a valid phone number")
+	}
+
+	v.Check(len(user.Addresses) <= 10, "addresses", "must not contain more than 10 addresses")
+
+	defaults := 0
+	for i, address := range user.Addresses {
+		key := fmt.Sprintf("addresses[%d]", i)
+
+		v.Check(address.Line1 != "", key+".line1", "must be provided")
+		v.Check(address.City != "", key+".city", "must be provided")
+		v.Check(address.PostalCode != "", key+".postal_code", "must be provided")
+		v.Check(validator.Matches(address.Country, validator.CountryRX), key+".country", "must be a two letter ISO 3166 country code")
+
+		if address.IsDefault {
+			defaults++
+		}
+	}
+	v.Check(defaults <= 1, "addresses", "must not contain more than one default address")
+}
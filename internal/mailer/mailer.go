@@ -0,0 +1,123 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"text/template"
+	"time"
+)
+
+// Below we declare a new variable with the type embed.FS (embedded file system) to hold
+// our email templates. This has a comment directive in the format `//go:embed <path>`
+// IMMEDIATELY ABOVE it, which indicates to Go that we want to store the contents of the
+// ./templates directory in the templateFS embedded file system variable.
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Message holds a fully rendered email, ready to be handed to a Sender.
+type Message struct {
+	From      string
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// Sender is the transport which actually delivers a rendered message. The SMTP sender
+// is used in production, while the file and memory senders are meant for development
+// and tests.
+type Sender interface {
+	Deliver(msg *Message) error
+}
+
+// Define a Mailer struct which contains the Sender used to deliver emails and the
+// sender information (the name and address you want the email to be from, such as
+// "Toy Rental <no-reply@toyrental.kz>").
+type Mailer struct {
+	sender  Sender
+	from    string
+	retries int
+
+	// Backoff is the pause between two delivery attempts. Tests set it to zero.
+	Backoff time.Duration
+}
+
+func New(sender Sender, from string) Mailer {
+	return Mailer{
+		sender:  sender,
+		from:    from,
+		retries: 3,
+		Backoff: 500 * time.Millisecond,
+	}
+}
+
+// Define a Send() method on the Mailer type. This takes the recipient email address
+// as the first parameter, the name of the file containing the templates, and any
+// dynamic data for the templates as an any parameter.
+func (m Mailer) Send(recipient, templateFile string, data any) error {
+	msg, err := m.render(recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	// Try sending the email up to m.retries times before aborting and returning the
+	// final error. We sleep for m.Backoff between each attempt, so that temporary
+	// network problems or a busy SMTP server don't cause the email to be lost.
+	for i := 1; i <= m.retries; i++ {
+		err = m.sender.Deliver(msg)
+		// If everything worked, return nil.
+		if nil == err {
+			return nil
+		}
+
+		// If it didn't work, sleep for a short time and retry.
+		if i < m.retries {
+			time.Sleep(m.Backoff)
+		}
+	}
+
+	return err
+}
+
+// render executes the "subject", "plainBody" and "htmlBody" templates from the given
+// file. The plain-text parts are parsed with text/template, while the HTML part goes
+// through html/template so that dynamic data is escaped properly.
+func (m Mailer) render(recipient, templateFile string, data any) (*Message, error) {
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlTmpl, err := htmltemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		From:      m.from,
+		To:        recipient,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileSender writes every message as an .eml file into Dir instead of sending it. This
+// is handy during development, since the files can be opened by any mail client.
+type FileSender struct {
+	Dir string
+}
+
+func NewFileSender(dir string) (*FileSender, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSender{Dir: dir}, nil
+}
+
+func (s *FileSender) Deliver(msg *Message) error {
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	// Replace the characters which are awkward in file names, so that the recipient
+	// address can be used as part of the name.
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(msg.To)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), recipient)
+
+	return os.WriteFile(filepath.Join(s.Dir, name), body, 0o644)
+}
+
+// MemorySender keeps every delivered message in memory. It's meant for tests, which
+// can inspect the rendered messages through the Messages() method.
+type MemorySender struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+func NewMemorySender() *MemorySender {
+	return &MemorySender{}
+}
+
+func (s *MemorySender) Deliver(msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of all the messages delivered so far.
+func (s *MemorySender) Messages() []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := make([]*Message, len(s.messages))
+	copy(messages, s.messages)
+	return messages
+}
@@ -0,0 +1,137 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// ErrInvalidHeader is returned for messages whose sender, recipient or subject would
+// break out of their header line, or aren't valid addresses.
+var ErrInvalidHeader = errors.New("mailer: invalid header value")
+
+// SMTPSender delivers messages through an SMTP server using PLAIN authentication.
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+func NewSMTPSender(host string, port int, username, password string) *SMTPSender {
+	return &SMTPSender{
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+	}
+}
+
+func (s *SMTPSender) Deliver(msg *Message) error {
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	// The envelope takes the bare addresses, without the display names.
+	from, err := parseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+	to, err := parseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+
+	addr := fmt.Sprintf("%s:%d", s.Host, s.Port)
+	auth := smtp.PlainAuth("", s.Username, s.Password, s.Host)
+
+	return smtp.SendMail(addr, auth, from.Address, []string{to.Address}, body)
+}
+
+// parseAddress parses an address like "Toy Rental <no-reply@toyrental.kz>". Values with
+// a CR or LF are refused outright, since they could inject headers of their own.
+func parseAddress(value string) (*mail.Address, error) {
+	if strings.ContainsAny(value, "\r\n") {
+		return nil, fmt.Errorf("%w: line break in %q", ErrInvalidHeader, value)
+	}
+
+	addr, err := mail.ParseAddress(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+	return addr, nil
+}
+
+// Bytes encodes the message as a multipart/alternative MIME document, with the
+// plain-text part first and the HTML part last, so that mail clients prefer the HTML
+// version when they can display it. The addresses are re-encoded by net/mail, and
+// ErrInvalidHeader is returned if any header value contains a line break.
+func (msg *Message) Bytes() ([]byte, error) {
+	from, err := parseAddress(msg.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseAddress(msg.To)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, fmt.Errorf("%w: line break in the subject", ErrInvalidHeader)
+	}
+
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+
+	fmt.Fprintf(buf, "From: %s\r\n", from)
+	fmt.Fprintf(buf, "To: %s\r\n", to)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", msg.PlainBody},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	}
+
+	for _, p := range parts {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Type", p.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+		pw, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+
+		qw := quotedprintable.NewWriter(pw)
+		_, err = qw.Write([]byte(p.body))
+		if err != nil {
+			return nil, err
+		}
+
+		err = qw.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
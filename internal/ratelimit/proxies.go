@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the networks of the reverse proxies whose X-Forwarded-For header
+// can be trusted.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a comma-separated list of IP addresses or CIDR ranges. A
+// bare IP address is treated as a single-host network.
+func ParseTrustedProxies(val string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+
+	for _, proxy := range strings.Split(val, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+// Contains reports whether ip is the address of a trusted proxy.
+func (p TrustedProxies) Contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range p {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP address of the client which made the request. The
+// X-Forwarded-For header is only trusted when the request came through one of the
+// trusted proxies, otherwise anyone could bypass the rate limiter by sending a made-up
+// header.
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if !p.Contains(ip) {
+		return ip
+	}
+
+	// Walk the X-Forwarded-For chain from right to left. Every entry appended by one of
+	// our own proxies is skipped, and the first address which isn't a trusted proxy is
+	// the real client.
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+
+		ip = hop
+		if !p.Contains(hop) {
+			break
+		}
+	}
+
+	return ip
+}
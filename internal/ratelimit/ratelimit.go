@@ -0,0 +1,135 @@
+// Package ratelimit limits requests with a token bucket per client, and works out the
+// IP address of a client behind trusted reverse proxies.
+package ratelimit
+
+import (
+	"golang.org/x/time/rate"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Define a client struct to hold the rate limiter and last seen time for each client.
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter keeps a token bucket for every client it has seen recently. Each route which
+// needs its own limits (like /login) gets a separate Limiter.
+type Limiter struct {
+	RPS   float64
+	Burst int
+
+	mu      sync.Mutex
+	clients map[string]*client
+}
+
+// New returns a Limiter with the given default limits, and starts a background
+// goroutine which forgets clients that haven't been seen for three minutes.
+func New(rps float64, burst int) *Limiter {
+	l := &Limiter{
+		RPS:     rps,
+		Burst:   burst,
+		clients: make(map[string]*client),
+	}
+
+	// Launch a background goroutine which removes old entries from the clients map once
+	// every minute.
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			// Lock the mutex to prevent any rate limiter checks from happening while
+			// the cleanup is taking place.
+			l.mu.Lock()
+
+			// Loop through all clients. If they haven't been seen within the last three
+			// minutes, delete the corresponding entry from the map.
+			for key, client := range l.clients {
+				if time.Since(client.lastSeen) > 3*time.Minute {
+					delete(l.clients, key)
+				}
+			}
+
+			// Importantly, unlock the mutex when the cleanup is complete.
+			l.mu.Unlock()
+		}
+	}()
+
+	return l
+}
+
+// Allow reports whether the client identified by key may make another request right
+// now, creating a fresh token bucket with the given limits for clients we haven't seen
+// before. If it may not, Allow also returns how long the client has to wait for the
+// next token.
+func (l *Limiter) Allow(key string, rps float64, burst int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, found := l.clients[key]; !found {
+		l.clients[key] = &client{
+			limiter: rate.NewLimiter(rate.Limit(rps), burst),
+		}
+	}
+
+	// Update the last seen time for the client.
+	l.clients[key].lastSeen = time.Now()
+
+	// A reservation which would have to wait is cancelled again, so that rejected
+	// requests don't use up the tokens of later ones.
+	reservation := l.clients[key].limiter.Reserve()
+	if !reservation.OK() {
+		return false, time.Minute
+	}
+
+	delay := reservation.Delay()
+	if delay > 0 {
+		reservation.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// Delay returns how long the client identified by key has to wait for its next token,
+// without taking it. Clients we haven't seen before don't have to wait.
+func (l *Limiter) Delay(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	client, found := l.clients[key]
+	if !found {
+		return 0
+	}
+
+	tokens := client.limiter.Tokens()
+	if tokens >= 1 {
+		return 0
+	}
+	if client.limiter.Limit() <= 0 {
+		return time.Minute
+	}
+	return time.Duration((1 - tokens) / float64(client.limiter.Limit()) * float64(time.Second))
+}
+
+// KeyFunc returns the key of the token bucket a request is counted against, and the
+// limits of that bucket.
+type KeyFunc func(r *http.Request) (key string, rps float64, burst int)
+
+// Handler passes requests on to next while the client's bucket has tokens left. Other
+// requests get a Retry-After header in whole seconds and are handed to exceeded.
+func (l *Limiter) Handler(keyFunc KeyFunc, exceeded http.HandlerFunc, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, retryAfter := l.Allow(keyFunc(r))
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			exceeded(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
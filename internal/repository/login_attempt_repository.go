@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"time"
+	"toy-rental-system/internal/audit"
+)
+
+// LoginAttemptRepository keeps track of failed login attempts. The subject is either a
+// username or a client IP address, prefixed with "username:" or "ip:" respectively.
+type LoginAttemptRepository interface {
+	RecordFailure(subject string) (int, error)
+	Lock(subject string, until time.Time) error
+	LockedUntil(subjects ...string) (time.Time, error)
+	Reset(subject string) error
+	ResetAudited(subject string, userID int64, actor audit.Actor) error
+}
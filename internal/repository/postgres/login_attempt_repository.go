@@ -0,0 +1,79 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"strconv"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/repository"
+)
+
+type loginAttemptRepository struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptRepository(db *sql.DB) repository.LoginAttemptRepository {
+	return &loginAttemptRepository{db: db}
+}
+
+// RecordFailure increments the failure counter for the subject and returns the new
+// value. Failures older than a day are forgotten, so the counter starts from 1 again.
+func (r *loginAttemptRepository) RecordFailure(subject string) (int, error) {
+	query := `
+INSERT INTO login_failures (subject, failures, last_failure_at)
+VALUES ($1, 1, now())
+ON CONFLICT (subject) DO UPDATE
+SET failures = CASE
+        WHEN login_failures.last_failure_at < now() - interval '24 hours' THEN 1
+        ELSE login_failures.failures + 1
+    END,
+    last_failure_at = now()
+RETURNING failures`
+
+	var failures int
+	err := r.db.QueryRow(query, subject).Scan(&failures)
+	return failures, err
+}
+
+func (r *loginAttemptRepository) Lock(subject string, until time.Time) error {
+	_, err := r.db.Exec("UPDATE login_failures SET locked_until = $2 WHERE subject = $1", subject, until)
+	return err
+}
+
+// LockedUntil returns the latest lockout expiry among the given subjects, or the zero
+// time if none of them is locked.
+func (r *loginAttemptRepository) LockedUntil(subjects ...string) (time.Time, error) {
+	query := `
+SELECT max(locked_until)
+FROM login_failures
+WHERE subject = ANY($1) AND locked_until > now()`
+
+	var lockedUntil sql.NullTime
+	err := r.db.QueryRow(query, pq.Array(subjects)).Scan(&lockedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return lockedUntil.Time, nil
+}
+
+func (r *loginAttemptRepository) Reset(subject string) error {
+	_, err := r.db.Exec("DELETE FROM login_failures WHERE subject = $1", subject)
+	return err
+}
+
+// ResetAudited forgets the failed attempts of the subject, which lifts its lockout, and
+// records a "user.unlock" audit event for the user in the same transaction.
+func (r *loginAttemptRepository) ResetAudited(subject string, userID int64, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return audit.Run(ctx, r.db, func(tx *sql.Tx) (*audit.Event, error) {
+		_, err := tx.ExecContext(ctx, "DELETE FROM login_failures WHERE subject = $1", subject)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, "user.unlock", "user", strconv.FormatInt(userID, 10), nil, nil)
+	})
+}
@@ -0,0 +1,294 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/domain/entity"
+	"toy-rental-system/internal/repository"
+)
+
+type privacyRepository struct {
+	db *sql.DB
+}
+
+func NewPrivacyRepository(db *sql.DB) repository.PrivacyRepository {
+	return &privacyRepository{db: db}
+}
+
+func (r *privacyRepository) FindByID(userID int64) (*entity.User, error) {
+	row := r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1", userID)
+	user, err := scanUser(row)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Addresses, err = findAddresses(r.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *privacyRepository) Subscriptions(userID int64) ([]*entity.Subscription, error) {
+	rows, err := r.db.Query("SELECT id, user_id, tokens, price, currency FROM subscriptions WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []*entity.Subscription{}
+	for rows.Next() {
+		s := &entity.Subscription{}
+		err := rows.Scan(&s.ID, &s.UserID, &s.Tokens, &s.Price, &s.Currency)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, rows.Err()
+}
+
+// TokenLedger returns every change of the user's token balance, the oldest first.
+func (r *privacyRepository) TokenLedger(userID int64) ([]*entity.TokenLedgerEntry, error) {
+	rows, err := r.db.Query("SELECT id, user_id, amount, reason, subscription_id, created_at FROM token_ledger WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*entity.TokenLedgerEntry{}
+	for rows.Next() {
+		e := &entity.TokenLedgerEntry{}
+		err := rows.Scan(&e.ID, &e.UserID, &e.Amount, &e.Reason, &e.SubscriptionID, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// AuditEvents returns the audit events the user caused, which include the IP addresses
+// their requests came from.
+func (r *privacyRepository) AuditEvents(userID int64) ([]*audit.Event, error) {
+	query := `
+SELECT id, created_at, action, target_type, target_id, ip, request_id
+FROM audit_events
+WHERE actor_user_id = $1
+ORDER BY id`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*audit.Event{}
+	for rows.Next() {
+		e := &audit.Event{ActorUserID: &userID}
+		err := rows.Scan(&e.ID, &e.CreatedAt, &e.Action, &e.TargetType, &e.TargetID, &e.IP, &e.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Reviews returns the reviews the user wrote. Their bodies are empty once the account
+// has been erased.
+func (r *privacyRepository) Reviews(userID int64) ([]*data.Review, error) {
+	rows, err := r.db.Query("SELECT id, toy_id, user_id, rating, body, created_at FROM toy_reviews WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []*data.Review{}
+	for rows.Next() {
+		review := &data.Review{}
+		err := rows.Scan(&review.ID, &review.ToyID, &review.UserID, &review.Rating, &review.Body, &review.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+	return reviews, rows.Err()
+}
+
+// Rentals returns the toys the user rented and brought back, the oldest first.
+func (r *privacyRepository) Rentals(userID int64) ([]*data.ToyReturn, error) {
+	rows, err := r.db.Query("SELECT id, toy_id, user_id, returned_at FROM toy_returns WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rentals := []*data.ToyReturn{}
+	for rows.Next() {
+		rental := &data.ToyReturn{}
+		err := rows.Scan(&rental.ID, &rental.ToyID, &rental.UserID, &rental.ReturnedAt)
+		if err != nil {
+			return nil, err
+		}
+		rentals = append(rentals, rental)
+	}
+	return rentals, rows.Err()
+}
+
+func (r *privacyRepository) CreateErasureJob(userID int64) (*entity.ErasureJob, error) {
+	query := `
+INSERT INTO erasure_jobs (user_id, status)
+VALUES ($1, $2)
+RETURNING id, requested_at`
+
+	job := &entity.ErasureJob{UserID: userID, Status: entity.ErasureStatusPending}
+	err := r.db.QueryRow(query, userID, job.Status).Scan(&job.ID, &job.RequestedAt)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (r *privacyRepository) GetErasureJob(id int64) (*entity.ErasureJob, error) {
+	row := r.db.QueryRow("SELECT id, user_id, status, error, requested_at, completed_at FROM erasure_jobs WHERE id = $1", id)
+	return scanErasureJob(row)
+}
+
+func (r *privacyRepository) LatestErasureJob(userID int64) (*entity.ErasureJob, error) {
+	row := r.db.QueryRow("SELECT id, user_id, status, error, requested_at, completed_at FROM erasure_jobs WHERE user_id = $1 ORDER BY id DESC LIMIT 1", userID)
+	return scanErasureJob(row)
+}
+
+// ClaimErasureJobs returns up to limit pending jobs, together with the running jobs
+// whose worker has died: the jobs are leased to the caller, and a job which is still
+// running when its lease runs out is claimed again.
+func (r *privacyRepository) ClaimErasureJobs(limit int, lease time.Duration) ([]*entity.ErasureJob, error) {
+	query := `
+UPDATE erasure_jobs
+SET status = 'running', lease_expires_at = now() + $2 * interval '1 second'
+WHERE id IN (
+	SELECT id
+	FROM erasure_jobs
+	WHERE status = 'pending' OR (status = 'running' AND (lease_expires_at IS NULL OR lease_expires_at <= now()))
+	ORDER BY id
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING id, user_id, status, error, requested_at, completed_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*entity.ErasureJob{}
+	for rows.Next() {
+		job := &entity.ErasureJob{}
+		err := rows.Scan(&job.ID, &job.UserID, &job.Status, &job.Error, &job.RequestedAt, &job.CompletedAt)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func scanErasureJob(row *sql.Row) (*entity.ErasureJob, error) {
+	job := &entity.ErasureJob{}
+	err := row.Scan(&job.ID, &job.UserID, &job.Status, &job.Error, &job.RequestedAt, &job.CompletedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrErasureJobNotFound
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+func (r *privacyRepository) UpdateErasureJob(job *entity.ErasureJob) error {
+	_, err := r.db.Exec("UPDATE erasure_jobs SET status = $2, error = $3, completed_at = $4 WHERE id = $1",
+		job.ID, job.Status, job.Error, job.CompletedAt)
+	return err
+}
+
+// Anonymize replaces the personal data of a user and removes their credentials. The
+// subscriptions and the token ledger are kept, since financial records must be
+// retained, but they only refer to the anonymized user ID from now on.
+func (r *privacyRepository) Anonymize(userID int64, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The password is replaced by random bytes instead of an empty string, so that
+	// nobody can log into the anonymized account.
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	return audit.Run(ctx, r.db, func(tx *sql.Tx) (*audit.Event, error) {
+		var username string
+		err := tx.QueryRowContext(ctx, "SELECT username FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&username)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, repository.ErrUserNotFound
+			}
+			return nil, err
+		}
+
+		query := `
+UPDATE users
+SET username = 'deleted-user-' || id, password = $2, erased_at = now(),
+	email = NULL, display_name = '', phone = '', contact_by_email = false, contact_by_sms = false, marketing_opt_in = false,
+	totp_secret = '', totp_enabled = false, totp_last_step = 0
+WHERE id = $1`
+
+		_, err = tx.ExecContext(ctx, query, userID, hex.EncodeToString(randomBytes))
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM addresses WHERE user_id = $1", userID)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM tokens WHERE user_id = $1", userID)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM login_failures WHERE subject = $1", "username:"+username)
+		if err != nil {
+			return nil, err
+		}
+
+		// The ratings stay, since the averages of the toys are made of them, but the
+		// text of a review may well tell who wrote it.
+		_, err = tx.ExecContext(ctx, "UPDATE toy_reviews SET body = '' WHERE user_id = $1", userID)
+		if err != nil {
+			return nil, err
+		}
+
+		// The event deliberately doesn't carry the old username, otherwise the audit log
+		// would keep the very data we were asked to erase.
+		return audit.NewEvent(actor, "user.erase", "user", strconv.FormatInt(userID, 10), nil, nil)
+	})
+}
@@ -1,13 +1,30 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	_ "github.com/lib/pq"
+	"strconv"
+	"time"
+	"toy-rental-system/internal/audit"
 	"toy-rental-system/internal/domain/entity"
 )
 
+var (
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrPlanNotFound         = errors.New("subscription plan not found")
+	// ErrSubscriptionConfirmed is returned when a subscription is confirmed a second
+	// time, so that its tokens are only credited once.
+	ErrSubscriptionConfirmed = errors.New("subscription already confirmed")
+)
+
 type SubscriptionRepository interface {
 	Save(subscription *entity.Subscription) error
+	SaveAudited(subscription *entity.Subscription, actor audit.Actor) error
+	Get(id int64) (*entity.Subscription, error)
+	GetPlan(id int64) (*entity.SubscriptionPlan, error)
+	ConfirmAudited(subscription *entity.Subscription, actor audit.Actor) error
 }
 
 type subscriptionRepository struct {
@@ -25,3 +42,91 @@ func (r *subscriptionRepository) Save(subscription *entity.Subscription) error {
 	_, err := r.DB.Exec(query, subscription.ID, subscription.UserID, subscription.Tokens, subscription.Price, subscription.Currency)
 	return err
 }
+
+// SaveAudited stores the pending subscription, sets its ID and records a
+// "subscription.create" audit event in the same transaction. No tokens are credited
+// until the payment is confirmed with ConfirmAudited.
+func (r *subscriptionRepository) SaveAudited(subscription *entity.Subscription, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+INSERT INTO subscriptions (user_id, tokens, price, currency, payment_intent_id)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id`
+
+	return audit.Run(ctx, r.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		err := tx.QueryRowContext(ctx, query, subscription.UserID, subscription.Tokens, subscription.Price, subscription.Currency, subscription.PaymentIntentID).Scan(&subscription.ID)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, "subscription.create", "subscription", strconv.FormatInt(subscription.ID, 10), nil, subscription)
+	})
+}
+
+func (r *subscriptionRepository) Get(id int64) (*entity.Subscription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, user_id, tokens, price, currency, payment_intent_id, confirmed_at FROM subscriptions WHERE id = $1`
+
+	s := &entity.Subscription{}
+	err := r.DB.QueryRowContext(ctx, query, id).Scan(&s.ID, &s.UserID, &s.Tokens, &s.Price, &s.Currency, &s.PaymentIntentID, &s.ConfirmedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSubscriptionNotFound
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+func (r *subscriptionRepository) GetPlan(id int64) (*entity.SubscriptionPlan, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id, name, tokens, price, currency FROM subscription_plans WHERE id = $1`
+
+	p := &entity.SubscriptionPlan{}
+	err := r.DB.QueryRowContext(ctx, query, id).Scan(&p.ID, &p.Name, &p.Tokens, &p.Price, &p.Currency)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPlanNotFound
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// ConfirmAudited marks the subscription as paid, credits its tokens to the user's
+// balance with an entry in the token ledger and records a "subscription.confirm"
+// audit event in the same transaction. ErrSubscriptionConfirmed is returned if it has
+// been confirmed already, so that concurrent confirmations credit the tokens once.
+func (r *subscriptionRepository) ConfirmAudited(subscription *entity.Subscription, actor audit.Actor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return audit.Run(ctx, r.DB, func(tx *sql.Tx) (*audit.Event, error) {
+		query := `UPDATE subscriptions SET confirmed_at = now() WHERE id = $1 AND confirmed_at IS NULL RETURNING confirmed_at`
+
+		err := tx.QueryRowContext(ctx, query, subscription.ID).Scan(&subscription.ConfirmedAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrSubscriptionConfirmed
+			}
+			return nil, err
+		}
+
+		query = `INSERT INTO token_ledger (user_id, amount, reason, subscription_id) VALUES ($1, $2, $3, $4)`
+		_, err = tx.ExecContext(ctx, query, subscription.UserID, subscription.Tokens, entity.TokenLedgerSubscription, subscription.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE users SET tokens = tokens + $2 WHERE id = $1`, subscription.UserID, subscription.Tokens)
+		if err != nil {
+			return nil, err
+		}
+		return audit.NewEvent(actor, "subscription.confirm", "subscription", strconv.FormatInt(subscription.ID, 10), nil, subscription)
+	})
+}
@@ -1,13 +1,20 @@
 package postgres
 
-
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"time"
 	"toy-rental-system/internal/domain/entity"
 	"toy-rental-system/internal/repository"
 )
 
+// userColumns are selected by all queries which return a user, in the order expected
+// by scanUser().
+const userColumns = `users.id, users.username, users.password, users.tokens, users.role, users.totp_secret, users.totp_enabled,
+COALESCE(users.email, ''), users.display_name, users.phone, users.contact_by_email, users.contact_by_sms, users.marketing_opt_in`
+
 type userRepository struct {
 	db *sql.DB
 }
@@ -16,22 +23,173 @@ func NewUserRepository(db *sql.DB) repository.UserRepository {
 	return &userRepository{db: db}
 }
 
+// Save inserts a new user and sets its ID.
 func (r *userRepository) Save(user *entity.User) error {
-	_, err := r.db.Exec("INSERT INTO users (username, password, tokens) VALUES ($1, $2, $3)",
-		user.Username, user.Password, user.Tokens)
-	return err
+	query := `
+INSERT INTO users (username, password, tokens, email)
+VALUES ($1, $2, $3, NULLIF($4, ''))
+RETURNING id`
+
+	err := r.db.QueryRow(query, user.Username, user.Password, user.Tokens, user.Email).Scan(&user.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return repository.ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// FindByID returns the user together with their delivery addresses.
+func (r *userRepository) FindByID(id int64) (*entity.User, error) {
+	row := r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1", id)
+	user, err := scanUser(row)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Addresses, err = findAddresses(r.db, id)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
 func (r *userRepository) FindByUsername(username string) (*entity.User, error) {
-	row := r.db.QueryRow("SELECT id, username, password, tokens FROM users WHERE username = $1", username)
+	row := r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = $1", username)
+	return scanUser(row)
+}
+
+func (r *userRepository) GetForToken(tokenScope, tokenPlaintext string) (*entity.User, error) {
+	// Calculate the SHA-256 hash of the plaintext token provided by the client.
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+SELECT ` + userColumns + `
+FROM users
+INNER JOIN tokens
+ON users.id = tokens.user_id
+WHERE tokens.hash = $1
+AND tokens.scope = $2
+AND tokens.expiry > $3`
+
+	row := r.db.QueryRow(query, tokenHash[:], tokenScope, time.Now())
+	return scanUser(row)
+}
+
+func (r *userRepository) UpdateProfile(user *entity.User) error {
+	query := `
+UPDATE users
+SET email = NULLIF($2, ''), display_name = $3, phone = $4, contact_by_email = $5, contact_by_sms = $6, marketing_opt_in = $7
+WHERE id = $1`
+
+	args := []any{
+		user.ID,
+		user.Email,
+		user.DisplayName,
+		user.Phone,
+		user.ContactPreferences.Email,
+		user.ContactPreferences.SMS,
+		user.ContactPreferences.Marketing,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return repository.ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return repository.ErrUserNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM addresses WHERE user_id = $1", user.ID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+INSERT INTO addresses (user_id, label, recipient, line1, line2, city, region, postal_code, country, is_default)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING id`
+
+	for i := range user.Addresses {
+		a := &user.Addresses[i]
+		err = tx.QueryRowContext(ctx, query, user.ID, a.Label, a.Recipient, a.Line1, a.Line2, a.City, a.Region, a.PostalCode, a.Country, a.IsDefault).Scan(&a.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func scanUser(row *sql.Row) (*entity.User, error) {
 	user := &entity.User{}
-	err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Tokens)
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.Password,
+		&user.Tokens,
+		&user.Role,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.Email,
+		&user.DisplayName,
+		&user.Phone,
+		&user.ContactPreferences.Email,
+		&user.ContactPreferences.SMS,
+		&user.ContactPreferences.Marketing,
+	)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, errors.New("user not found")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.ErrUserNotFound
 		}
 		return nil, err
 	}
 	return user, nil
 }
 
+func findAddresses(db *sql.DB, userID int64) ([]entity.Address, error) {
+	query := `
+SELECT id, label, recipient, line1, line2, city, region, postal_code, country, is_default
+FROM addresses
+WHERE user_id = $1
+ORDER BY is_default DESC, id`
+
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []entity.Address{}
+	for rows.Next() {
+		var a entity.Address
+		err := rows.Scan(&a.ID, &a.Label, &a.Recipient, &a.Line1, &a.Line2, &a.City, &a.Region, &a.PostalCode, &a.Country, &a.IsDefault)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, a)
+	}
+	return addresses, rows.Err()
+}
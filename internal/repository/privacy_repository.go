@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"errors"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/domain/entity"
+)
+
+var ErrErasureJobNotFound = errors.New("erasure job not found")
+
+// PrivacyRepository reads everything we hold about a user for the data export, and
+// anonymizes the account on request.
+type PrivacyRepository interface {
+	FindByID(userID int64) (*entity.User, error)
+	Subscriptions(userID int64) ([]*entity.Subscription, error)
+	TokenLedger(userID int64) ([]*entity.TokenLedgerEntry, error)
+	AuditEvents(userID int64) ([]*audit.Event, error)
+	Reviews(userID int64) ([]*data.Review, error)
+	Rentals(userID int64) ([]*data.ToyReturn, error)
+
+	CreateErasureJob(userID int64) (*entity.ErasureJob, error)
+	GetErasureJob(id int64) (*entity.ErasureJob, error)
+	LatestErasureJob(userID int64) (*entity.ErasureJob, error)
+	ClaimErasureJobs(limit int, lease time.Duration) ([]*entity.ErasureJob, error)
+	UpdateErasureJob(job *entity.ErasureJob) error
+	Anonymize(userID int64, actor audit.Actor) error
+}
@@ -0,0 +1,18 @@
+package repository
+
+// TwoFactorRepository stores the TOTP enrollment of users and their recovery codes.
+// Recovery codes are only ever passed in and stored as SHA-256 hashes.
+type TwoFactorRepository interface {
+	SetTOTPSecret(userID int64, secret string) error
+	EnableTOTP(userID int64, recoveryCodeHashes [][]byte) error
+	DisableTOTP(userID int64) error
+	ReplaceRecoveryCodes(userID int64, recoveryCodeHashes [][]byte) error
+
+	// UseTOTPStep records step as the last accepted time step of the user. It returns
+	// false if the step, or a later one, has already been used.
+	UseTOTPStep(userID int64, step int64) (bool, error)
+
+	// UseRecoveryCode marks the matching unused recovery code as used. It returns false
+	// if there is no such code.
+	UseRecoveryCode(userID int64, hash []byte) (bool, error)
+}
@@ -1,8 +1,22 @@
 package repository
 
-import "toy-rental-system/internal/domain/entity"
+import (
+	"errors"
+	"toy-rental-system/internal/domain/entity"
+)
+
+var (
+	ErrUserNotFound   = errors.New("user not found")
+	ErrDuplicateEmail = errors.New("duplicate email")
+)
 
 type UserRepository interface {
 	Save(user *entity.User) error
+	FindByID(id int64) (*entity.User, error)
 	FindByUsername(username string) (*entity.User, error)
+	GetForToken(tokenScope, tokenPlaintext string) (*entity.User, error)
+
+	// UpdateProfile saves the profile fields of the user and replaces their delivery
+	// addresses with user.Addresses.
+	UpdateProfile(user *entity.User) error
 }
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/domain/entity"
+	"toy-rental-system/internal/repository"
+	"toy-rental-system/pkg/jsonlog"
+)
+
+// ErrErasureInProgress is returned when the user already has an erasure job which
+// hasn't finished yet.
+var ErrErasureInProgress = errors.New("account erasure already in progress")
+
+const (
+	// erasureLease is how long a claimed erasure job is left to the worker which
+	// claimed it, before another worker resumes it.
+	erasureLease = 5 * time.Minute
+	// erasureBatchSize is the number of erasure jobs claimed at a time.
+	erasureBatchSize = 10
+)
+
+type PrivacyService interface {
+	Export(userID int64, w io.Writer) error
+	RequestErasure(userID int64) (*entity.ErasureJob, error)
+	Erase(job *entity.ErasureJob, actor audit.Actor)
+	ProcessPendingErasures() error
+	LatestErasureJob(userID int64) (*entity.ErasureJob, error)
+	GetErasureJob(id int64) (*entity.ErasureJob, error)
+}
+
+type privacyService struct {
+	privacyRepository repository.PrivacyRepository
+	logger            *jsonlog.Logger
+}
+
+func NewPrivacyService(repo repository.PrivacyRepository, logger *jsonlog.Logger) PrivacyService {
+	return &privacyService{
+		privacyRepository: repo,
+		logger:            logger,
+	}
+}
+
+// Export writes a ZIP archive with one JSON file per kind of data we hold about the
+// user.
+func (s *privacyService) Export(userID int64, w io.Writer) error {
+	user, err := s.privacyRepository.FindByID(userID)
+	if err != nil {
+		return err
+	}
+
+	subscriptions, err := s.privacyRepository.Subscriptions(userID)
+	if err != nil {
+		return err
+	}
+
+	ledger, err := s.privacyRepository.TokenLedger(userID)
+	if err != nil {
+		return err
+	}
+
+	events, err := s.privacyRepository.AuditEvents(userID)
+	if err != nil {
+		return err
+	}
+
+	reviews, err := s.privacyRepository.Reviews(userID)
+	if err != nil {
+		return err
+	}
+
+	rentals, err := s.privacyRepository.Rentals(userID)
+	if err != nil {
+		return err
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", user.Profile()},
+		{"subscriptions.json", subscriptions},
+		{"token_ledger.json", ledger},
+		{"activity.json", events},
+		{"rentals.json", rentals},
+		{"reviews.json", reviews},
+	}
+
+	zw := zip.NewWriter(w)
+
+	for _, file := range files {
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+
+		js, err := json.MarshalIndent(file.data, "", "\t")
+		if err != nil {
+			return err
+		}
+
+		_, err = fw.Write(append(js, '\n'))
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// RequestErasure creates a pending erasure job, which is run by the next call of
+// ProcessPendingErasures().
+func (s *privacyService) RequestErasure(userID int64) (*entity.ErasureJob, error) {
+	job, err := s.privacyRepository.LatestErasureJob(userID)
+	switch {
+	case err == nil && (job.Status == entity.ErasureStatusPending || job.Status == entity.ErasureStatusRunning):
+		return nil, ErrErasureInProgress
+	case err != nil && !errors.Is(err, repository.ErrErasureJobNotFound):
+		return nil, err
+	}
+
+	return s.privacyRepository.CreateErasureJob(userID)
+}
+
+// Erase anonymizes the account of the job's user and keeps the job status up to date,
+// so that it can be reported back to the user and to staff.
+func (s *privacyService) Erase(job *entity.ErasureJob, actor audit.Actor) {
+	properties := map[string]string{
+		"erasure_job_id": strconv.FormatInt(job.ID, 10),
+		"user_id":        strconv.FormatInt(job.UserID, 10),
+	}
+
+	err := s.privacyRepository.Anonymize(job.UserID, actor)
+
+	now := time.Now()
+	job.CompletedAt = &now
+	job.Status = entity.ErasureStatusCompleted
+	if err != nil {
+		s.logger.PrintError(err, properties)
+		job.Status = entity.ErasureStatusFailed
+		job.Error = err.Error()
+	}
+
+	err = s.privacyRepository.UpdateErasureJob(job)
+	if err != nil {
+		s.logger.PrintError(err, properties)
+		return
+	}
+
+	s.logger.PrintInfo("account erasure "+job.Status, properties)
+}
+
+// ProcessPendingErasures runs the pending erasure jobs, and resumes the jobs whose
+// worker died before it finished them, e.g. because the server was restarted. It's
+// meant to be run periodically, and only returns an error if it couldn't claim any
+// jobs. Anonymize() can safely run twice for the same user.
+func (s *privacyService) ProcessPendingErasures() error {
+	jobs, err := s.privacyRepository.ClaimErasureJobs(erasureBatchSize, erasureLease)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		// The users asked for the erasure of their own accounts.
+		s.Erase(job, audit.Actor{UserID: job.UserID})
+	}
+
+	return nil
+}
+
+func (s *privacyService) LatestErasureJob(userID int64) (*entity.ErasureJob, error) {
+	return s.privacyRepository.LatestErasureJob(userID)
+}
+
+func (s *privacyService) GetErasureJob(id int64) (*entity.ErasureJob, error) {
+	return s.privacyRepository.GetErasureJob(id)
+}
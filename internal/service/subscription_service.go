@@ -1,24 +1,90 @@
 package service
 
-
 import (
+	"errors"
 	"fmt"
 	"github.com/stripe/stripe-go/v72"
 	"github.com/stripe/stripe-go/v72/paymentintent"
+	"strings"
+	"toy-rental-system/internal/audit"
 	"toy-rental-system/internal/config"
 	"toy-rental-system/internal/domain/entity"
 	"toy-rental-system/internal/repository/postgres"
 )
 
+// ErrPaymentNotConfirmed is returned when a subscription is confirmed before Stripe has
+// received its full price.
+var ErrPaymentNotConfirmed = errors.New("payment not confirmed")
+
+// Payment is the state of a payment, as the payment provider reports it.
+type Payment struct {
+	ID           string
+	ClientSecret string
+	Amount       int64
+	Currency     string
+	Succeeded    bool
+}
+
+// PaymentGateway creates payments and looks up whether they have succeeded. It's
+// Stripe in production.
+type PaymentGateway interface {
+	CreatePayment(amount int64, currency string) (*Payment, error)
+	GetPayment(id string) (*Payment, error)
+}
+
+type stripeGateway struct {
+	key string
+}
+
+func (g stripeGateway) CreatePayment(amount int64, currency string) (*Payment, error) {
+	stripe.Key = g.key
+
+	params := &stripe.PaymentIntentParams{
+		Amount:             stripe.Int64(amount),
+		Currency:           stripe.String(currency),
+		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
+	}
+
+	pi, err := paymentintent.New(params)
+	if err != nil {
+		return nil, err
+	}
+	return stripePayment(pi), nil
+}
+
+func (g stripeGateway) GetPayment(id string) (*Payment, error) {
+	stripe.Key = g.key
+
+	pi, err := paymentintent.Get(id, nil)
+	if err != nil {
+		return nil, err
+	}
+	return stripePayment(pi), nil
+}
+
+func stripePayment(pi *stripe.PaymentIntent) *Payment {
+	return &Payment{
+		ID:           pi.ID,
+		ClientSecret: pi.ClientSecret,
+		Amount:       pi.Amount,
+		Currency:     pi.Currency,
+		Succeeded:    pi.Status == stripe.PaymentIntentStatusSucceeded,
+	}
+}
+
 type SubscriptionService struct {
 	cfg              config.Config
 	subscriptionRepo postgres.SubscriptionRepository
+
+	// Payments is Stripe, unless a test replaces it.
+	Payments PaymentGateway
 }
 
 func NewSubscriptionService(cfg config.Config, subscriptionRepo postgres.SubscriptionRepository) *SubscriptionService {
 	return &SubscriptionService{
 		cfg:              cfg,
 		subscriptionRepo: subscriptionRepo,
+		Payments:         stripeGateway{key: cfg.StripeSecret},
 	}
 }
 
@@ -26,20 +92,78 @@ func (s *SubscriptionService) Subscribe(subscription *entity.Subscription) error
 	return s.subscriptionRepo.Save(subscription)
 }
 
+// ProcessPayment creates the payment of the subscription's price, which the client then
+// completes with the returned client secret.
 func (s *SubscriptionService) ProcessPayment(subscription *entity.Subscription) error {
-	stripe.Key = s.cfg.StripeSecret
+	payment, err := s.Payments.CreatePayment(subscription.Price, subscription.Currency)
+	if err != nil {
+		return fmt.Errorf("failed to create payment intent: %v", err)
+	}
 
-	params := &stripe.PaymentIntentParams{
-		Amount:             stripe.Int64(subscription.Price),
-		Currency:           stripe.String(subscription.Currency),
-		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
+	subscription.PaymentIntentID = payment.ID
+	subscription.ClientSecret = payment.ClientSecret
+	return nil
+}
+
+// Start creates a pending subscription of the user to the plan, together with the
+// payment of the plan's price. The tokens are only credited by Confirm.
+func (s *SubscriptionService) Start(userID int64, planID int64, actor audit.Actor) (*entity.Subscription, error) {
+	plan, err := s.subscriptionRepo.GetPlan(planID)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription := &entity.Subscription{
+		UserID:   userID,
+		Tokens:   plan.Tokens,
+		Price:    plan.Price,
+		Currency: plan.Currency,
 	}
 
-	_, err := paymentintent.New(params)
+	err = s.ProcessPayment(subscription)
 	if err != nil {
-		return fmt.Errorf("failed to create payment intent: %v", err)
+		return nil, err
 	}
-	return nil
+
+	err = s.subscriptionRepo.SaveAudited(subscription, actor)
+	if err != nil {
+		return nil, err
+	}
+	return subscription, nil
 }
 
+// Confirm credits the tokens of the user's subscription once Stripe reports that its
+// full price has been paid. Confirming a subscription again returns it unchanged.
+// postgres.ErrSubscriptionNotFound is returned for the subscriptions of other users.
+func (s *SubscriptionService) Confirm(userID int64, subscriptionID int64, actor audit.Actor) (*entity.Subscription, error) {
+	subscription, err := s.subscriptionRepo.Get(subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.UserID != userID {
+		return nil, postgres.ErrSubscriptionNotFound
+	}
+	if subscription.ConfirmedAt != nil {
+		return subscription, nil
+	}
+	if subscription.PaymentIntentID == "" {
+		return nil, ErrPaymentNotConfirmed
+	}
 
+	payment, err := s.Payments.GetPayment(subscription.PaymentIntentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment intent: %v", err)
+	}
+	if !payment.Succeeded || payment.Amount != subscription.Price || !strings.EqualFold(payment.Currency, subscription.Currency) {
+		return nil, ErrPaymentNotConfirmed
+	}
+
+	err = s.subscriptionRepo.ConfirmAudited(subscription, actor)
+	if err != nil {
+		if errors.Is(err, postgres.ErrSubscriptionConfirmed) {
+			return s.subscriptionRepo.Get(subscriptionID)
+		}
+		return nil, err
+	}
+	return subscription, nil
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/domain/entity"
+	"toy-rental-system/internal/repository"
+	"toy-rental-system/internal/totp"
+)
+
+var (
+	ErrInvalidTOTPCode    = errors.New("invalid two-factor code")
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled    = errors.New("two-factor authentication is not enabled")
+	ErrTOTPMandatory      = errors.New("two-factor authentication is mandatory for staff accounts")
+)
+
+const (
+	totpIssuer        = "Toy Rental"
+	recoveryCodeCount = 10
+)
+
+// TOTPEnrollment is handed to the user when they start enrolling. The URI is meant to
+// be shown as a QR code, the secret is for typing it into the app by hand.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// LoginTOTP is the second login step for accounts with TOTP enabled. It exchanges the
+// two-factor token from Login() and a TOTP or recovery code for an authentication
+// token. Wrong codes count towards the same lockout as wrong passwords.
+func (s *userService) LoginTOTP(token, code, ip string) (*LoginResult, error) {
+	user, err := s.userRepository.GetForToken(data.ScopeTwoFactor, token)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	usernameSubject := "username:" + user.Username
+	ipSubject := "ip:" + ip
+
+	lockedUntil, err := s.loginAttempts.LockedUntil(usernameSubject, ipSubject)
+	if err != nil {
+		return nil, err
+	}
+	if !lockedUntil.IsZero() {
+		return nil, ErrInvalidCredentials
+	}
+
+	ok, err := s.verifySecondFactor(user, code, true)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		err = s.recordFailure(usernameSubject, usernameFailureThreshold)
+		if err != nil {
+			return nil, err
+		}
+		err = s.recordFailure(ipSubject, ipFailureThreshold)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	err = s.loginAttempts.Reset(usernameSubject)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.tokens.DeleteAllForUser(data.ScopeTwoFactor, int64(user.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueToken(user, authenticationTTL, data.ScopeAuthentication)
+}
+
+// EnrollTOTP generates a new secret for the user. TOTP isn't enabled until the user
+// confirms that their app works by sending a valid code to ConfirmTOTP().
+func (s *userService) EnrollTOTP(user *entity.User) (*TOTPEnrollment, error) {
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.twoFactor.SetTOTPSecret(int64(user.ID), secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.ProvisioningURI(totpIssuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables TOTP for the user and returns a fresh set of recovery codes.
+// The codes are only stored as hashes, so this is the only time the user sees them.
+func (s *userService) ConfirmTOTP(user *entity.User, code string) ([]string, error) {
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	ok, err := s.verifySecondFactor(user, code, false)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.twoFactor.EnableTOTP(int64(user.ID), hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	// The enrollment tokens of staff have served their purpose. From now on they log in
+	// with the second step like everybody else.
+	err = s.tokens.DeleteAllForUser(data.ScopeTOTPEnrollment, int64(user.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.PrintInfo("two-factor authentication enabled", map[string]string{
+		"user_id": strconv.Itoa(user.ID),
+	})
+	return codes, nil
+}
+
+// DisableTOTP turns TOTP off again, which requires a valid TOTP or recovery code.
+// Staff can't turn it off at all.
+func (s *userService) DisableTOTP(user *entity.User, code string) error {
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnrolled
+	}
+	if user.IsStaff() {
+		return ErrTOTPMandatory
+	}
+
+	ok, err := s.verifySecondFactor(user, code, true)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+
+	err = s.twoFactor.DisableTOTP(int64(user.ID))
+	if err != nil {
+		return err
+	}
+
+	s.logger.PrintInfo("two-factor authentication disabled", map[string]string{
+		"user_id": strconv.Itoa(user.ID),
+	})
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of the user, used or not. It
+// requires a TOTP code, since a recovery code might be what leaked.
+func (s *userService) RegenerateRecoveryCodes(user *entity.User, code string) ([]string, error) {
+	if !user.TOTPEnabled {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	ok, err := s.verifySecondFactor(user, code, false)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.twoFactor.ReplaceRecoveryCodes(int64(user.ID), hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// verifySecondFactor checks a TOTP code and, if allowRecovery is set, falls back to the
+// recovery codes. Every TOTP time step and every recovery code is only accepted once.
+func (s *userService) verifySecondFactor(user *entity.User, code string, allowRecovery bool) (bool, error) {
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		return s.twoFactor.UseTOTPStep(int64(user.ID), step)
+	}
+
+	if !allowRecovery {
+		return false, nil
+	}
+
+	ok, err := s.twoFactor.UseRecoveryCode(int64(user.ID), hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		s.logger.PrintInfo("recovery code used", map[string]string{
+			"user_id": strconv.Itoa(user.ID),
+		})
+	}
+	return ok, nil
+}
+
+// generateRecoveryCodes returns the plaintext codes for the user together with the
+// hashes to store. The codes look like "k3j9d-x0f2a".
+func generateRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+
+	for i := range codes {
+		randomBytes := make([]byte, 6)
+
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case, dashes and spaces, so that the user doesn't have to
+// type the code exactly as it was shown.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
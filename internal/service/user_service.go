@@ -2,22 +2,75 @@ package service
 
 import (
 	"errors"
+	"strconv"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
 	"toy-rental-system/internal/domain/entity"
 	"toy-rental-system/internal/repository"
+	"toy-rental-system/pkg/jsonlog"
 )
 
+// ErrInvalidCredentials is returned both for a wrong username or password and for a
+// locked account, so that a client can't tell the two situations apart.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Failed login attempts are counted per username and per client IP. Once a counter
+// reaches its threshold the subject is locked out for lockoutBase, and the lockout
+// doubles with every further failure up to lockoutMax.
+const (
+	usernameFailureThreshold = 5
+	ipFailureThreshold       = 20
+	lockoutBase              = time.Minute
+	lockoutMax               = 24 * time.Hour
+)
+
+// Lifetimes of the tokens issued by the login steps.
+const (
+	authenticationTTL = 24 * time.Hour
+	twoFactorTTL      = 5 * time.Minute
+	totpEnrollmentTTL = 15 * time.Minute
+)
+
+// LoginResult holds the token issued by a login step. Unless the scope is
+// data.ScopeAuthentication, the client has to complete another step with it: either
+// the TOTP code (data.ScopeTwoFactor) or the TOTP enrollment (data.ScopeTOTPEnrollment).
+type LoginResult struct {
+	Token  string    `json:"token"`
+	Scope  string    `json:"scope"`
+	Expiry time.Time `json:"expiry"`
+}
+
 type UserService interface {
 	Register(user *entity.User) error
-	Login(username, password string) (string, error)
+	Login(username, password, ip string) (*LoginResult, error)
+	LoginTOTP(token, code, ip string) (*LoginResult, error)
+	Unlock(username string, actor audit.Actor) error
+
+	GetProfile(userID int64) (*entity.User, error)
+	UpdateProfile(user *entity.User) error
+
+	EnrollTOTP(user *entity.User) (*TOTPEnrollment, error)
+	ConfirmTOTP(user *entity.User, code string) ([]string, error)
+	DisableTOTP(user *entity.User, code string) error
+	RegenerateRecoveryCodes(user *entity.User, code string) ([]string, error)
 }
 
 type userService struct {
 	userRepository repository.UserRepository
+	tokens         data.TokenRepository
+	loginAttempts  repository.LoginAttemptRepository
+	twoFactor      repository.TwoFactorRepository
+	logger         *jsonlog.Logger
 }
 
-func NewUserService(repo repository.UserRepository) UserService {
+func NewUserService(repo repository.UserRepository, tokens data.TokenRepository, loginAttempts repository.LoginAttemptRepository, twoFactor repository.TwoFactorRepository, logger *jsonlog.Logger) UserService {
 	return &userService{
 		userRepository: repo,
+		tokens:         tokens,
+		loginAttempts:  loginAttempts,
+		twoFactor:      twoFactor,
+		logger:         logger,
 	}
 }
 
@@ -25,13 +78,132 @@ func (s *userService) Register(user *entity.User) error {
 	return s.userRepository.Save(user)
 }
 
-func (s *userService) Login(username, password string) (string, error) {
+func (s *userService) Login(username, password, ip string) (*LoginResult, error) {
+	usernameSubject := "username:" + username
+	ipSubject := "ip:" + ip
+
+	// Refuse to even look at the password while either the username or the IP address
+	// is locked out.
+	lockedUntil, err := s.loginAttempts.LockedUntil(usernameSubject, ipSubject)
+	if err != nil {
+		return nil, err
+	}
+	if !lockedUntil.IsZero() {
+		return nil, ErrInvalidCredentials
+	}
+
 	user, err := s.userRepository.FindByUsername(username)
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		return nil, err
+	}
 	if err != nil || user.Password != password {
-		return "", errors.New("invalid credentials")
+		err = s.recordFailure(usernameSubject, usernameFailureThreshold)
+		if err != nil {
+			return nil, err
+		}
+		err = s.recordFailure(ipSubject, ipFailureThreshold)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	// With TOTP enabled the password is only the first step. The failures are kept
+	// until the code has been checked as well, otherwise a stolen password would allow
+	// guessing codes without ever being locked out.
+	if user.TOTPEnabled {
+		return s.issueToken(user, twoFactorTTL, data.ScopeTwoFactor)
+	}
+
+	// A successful login clears the failures for the username. The IP counter is left
+	// alone, since one correct password shouldn't excuse guessing at other accounts.
+	err = s.loginAttempts.Reset(usernameSubject)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case user.IsStaff():
+		// Two-factor authentication is mandatory for staff, so until they enroll they
+		// only get a token which is good for the enrollment.
+		return s.issueToken(user, totpEnrollmentTTL, data.ScopeTOTPEnrollment)
+	default:
+		return s.issueToken(user, authenticationTTL, data.ScopeAuthentication)
+	}
+}
+
+func (s *userService) issueToken(user *entity.User, ttl time.Duration, scope string) (*LoginResult, error) {
+	token, err := s.tokens.New(int64(user.ID), ttl, scope)
+	if err != nil {
+		return nil, err
 	}
-	// Mock token generation for simplicity
-	token := "mock-token"
-	return token, nil
+	return &LoginResult{Token: token.Plaintext, Scope: scope, Expiry: token.Expiry}, nil
 }
 
+// GetProfile returns the user with all profile fields, including the delivery
+// addresses, which aren't loaded for the authenticated user of a request.
+func (s *userService) GetProfile(userID int64) (*entity.User, error) {
+	return s.userRepository.FindByID(userID)
+}
+
+// UpdateProfile saves a profile which has already been validated with
+// entity.ValidateProfile().
+func (s *userService) UpdateProfile(user *entity.User) error {
+	return s.userRepository.UpdateProfile(user)
+}
+
+// Unlock lifts the lockout for the user's username and forgets its failed attempts.
+// repository.ErrUserNotFound is returned if there is no such user.
+func (s *userService) Unlock(username string, actor audit.Actor) error {
+	user, err := s.userRepository.FindByUsername(username)
+	if err != nil {
+		return err
+	}
+
+	err = s.loginAttempts.ResetAudited("username:"+username, int64(user.ID), actor)
+	if err != nil {
+		return err
+	}
+
+	s.logger.PrintInfo("account unlocked", map[string]string{
+		"subject": "username:" + username,
+	})
+	return nil
+}
+
+func (s *userService) recordFailure(subject string, threshold int) error {
+	failures, err := s.loginAttempts.RecordFailure(subject)
+	if err != nil {
+		return err
+	}
+	if failures < threshold {
+		return nil
+	}
+
+	lockout := lockoutDuration(failures - threshold)
+	lockedUntil := time.Now().Add(lockout)
+
+	err = s.loginAttempts.Lock(subject, lockedUntil)
+	if err != nil {
+		return err
+	}
+
+	s.logger.PrintInfo("login locked out", map[string]string{
+		"subject":      subject,
+		"failures":     strconv.Itoa(failures),
+		"locked_until": lockedUntil.UTC().Format(time.RFC3339),
+	})
+	return nil
+}
+
+// lockoutDuration doubles the base lockout for every failure past the threshold.
+func lockoutDuration(excess int) time.Duration {
+	lockout := lockoutBase
+	for i := 0; i < excess && lockout < lockoutMax; i++ {
+		lockout *= 2
+	}
+	if lockout > lockoutMax {
+		lockout = lockoutMax
+	}
+	return lockout
+}
@@ -0,0 +1,31 @@
+// Package storage keeps uploaded files, such as the photos of toys, in a blob store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+)
+
+var (
+	ErrNotFound   = errors.New("storage: blob not found")
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+// Storage stores blobs by key. Keys are slash separated relative paths, like
+// "toys/7/3f2a.jpg", and are never chosen by clients.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob. Deleting a blob which doesn't exist is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the address clients can fetch the blob from.
+	URL(key string) string
+}
+
+// ValidKey reports whether the key is a clean relative path, which can't escape the
+// root of the store with ".." elements.
+func ValidKey(key string) bool {
+	return key != "" && path.Clean("/"+key) == "/"+key
+}
@@ -0,0 +1,93 @@
+// Package thumbnail scales uploaded images down to the widths a catalog page needs.
+package thumbnail
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+)
+
+// Widths are the widths the thumbnails are generated in. Images are never scaled up,
+// so an image only gets the thumbnails which are narrower than itself.
+var Widths = []int{160, 320, 640, 1280}
+
+// MaxPixels limits the size of the images which are decoded, so that a small file with
+// huge dimensions can't exhaust the memory of the worker.
+const MaxPixels = 50_000_000
+
+// Supported reports whether thumbnails can be generated for the content type.
+func Supported(contentType string) bool {
+	return contentType == "image/jpeg" || contentType == "image/png"
+}
+
+// Key returns the storage key of the thumbnail of the given width, which is stored next
+// to the original: "toys/7/3f2a.jpg" becomes "toys/7/3f2a_320w.jpg".
+func Key(key string, width int) string {
+	ext := path.Ext(key)
+	return fmt.Sprintf("%s_%dw%s", strings.TrimSuffix(key, ext), width, ext)
+}
+
+// Resize scales the image down to the width, keeping its aspect ratio. Every pixel of
+// the result is the average of the pixels of the source it covers, which is slower
+// than sampling but doesn't alias fine patterns like fabric or Lego studs.
+func Resize(src image.Image, width int) *image.RGBA {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+
+	height := sh * width / sw
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := sb.Min.Y + y*sh/height
+		y1 := sb.Min.Y + (y+1)*sh/height
+		if y1 == y0 {
+			y1++
+		}
+
+		for x := 0; x < width; x++ {
+			x0 := sb.Min.X + x*sw/width
+			x1 := sb.Min.X + (x+1)*sw/width
+			if x1 == x0 {
+				x1++
+			}
+
+			// The colors are premultiplied by alpha, so they can simply be averaged.
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+
+	return dst
+}
+
+// Encode writes the image in the format of the content type.
+func Encode(w io.Writer, img image.Image, contentType string) error {
+	switch contentType {
+	case "image/jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 82})
+	case "image/png":
+		return png.Encode(w, img)
+	default:
+		return fmt.Errorf("thumbnail: unsupported content type %q", contentType)
+	}
+}
@@ -0,0 +1,129 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"strconv"
+	"time"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/storage"
+	"toy-rental-system/pkg/jsonlog"
+)
+
+// Worker generates the thumbnails of uploaded toy images. Failed images are retried
+// with a growing delay, up to MaxAttempts times.
+type Worker struct {
+	Images      data.ToyImageRepository
+	Storage     storage.Storage
+	Logger      *jsonlog.Logger
+	MaxAttempts int
+	// BatchSize is the number of images claimed at a time.
+	BatchSize int
+}
+
+// lease is how long a claimed image is left to the worker which claimed it.
+const lease = 5 * time.Minute
+
+// ProcessPending generates the thumbnails of the images which are due. It's meant to be
+// run periodically, and only returns an error if it couldn't claim any images.
+func (wk *Worker) ProcessPending() error {
+	images, err := wk.Images.ClaimPendingThumbnails(wk.BatchSize, lease)
+	if err != nil {
+		return err
+	}
+
+	for _, image := range images {
+		properties := map[string]string{"toy_image_id": strconv.FormatInt(image.ID, 10)}
+
+		err := wk.Generate(image)
+		if err != nil {
+			wk.Logger.PrintError(err, properties)
+
+			err = wk.Images.RetryThumbnails(image.ID, err.Error(), wk.MaxAttempts)
+			if err != nil {
+				wk.Logger.PrintError(err, properties)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Generate stores a thumbnail of the image for each of the Widths narrower than the
+// image, and records them.
+func (wk *Worker) Generate(img *data.ToyImage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	src, err := wk.decode(ctx, img.Key)
+	if err != nil {
+		return err
+	}
+
+	bounds := src.Bounds()
+	variants := []*data.ToyImageVariant{}
+
+	for _, width := range Widths {
+		if width >= bounds.Dx() {
+			break
+		}
+
+		dst := Resize(src, width)
+
+		var buf bytes.Buffer
+		err := Encode(&buf, dst, img.ContentType)
+		if err != nil {
+			return err
+		}
+
+		variant := &data.ToyImageVariant{Width: width, Height: dst.Bounds().Dy(), Key: Key(img.Key, width)}
+
+		err = wk.Storage.Put(ctx, variant.Key, &buf)
+		if err != nil {
+			return err
+		}
+		variants = append(variants, variant)
+	}
+
+	err = wk.Images.CompleteThumbnails(img.ID, bounds.Dx(), bounds.Dy(), variants)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		// The image was deleted while we were busy, so nothing refers to the thumbnails.
+		for _, variant := range variants {
+			wk.Storage.Delete(ctx, variant.Key)
+		}
+		return nil
+	}
+	return err
+}
+
+// decode reads the image from the storage, after checking that its dimensions are
+// within MaxPixels.
+func (wk *Worker) decode(ctx context.Context, key string) (image.Image, error) {
+	r, err := wk.Storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(r)
+	if err != nil {
+		return nil, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large", config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(&buf)
+	return src, err
+}
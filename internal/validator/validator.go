@@ -12,6 +12,13 @@ import (
 // note further down the page.
 var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+	// PhoneRX accepts international numbers with an optional leading "+" and the usual
+	// separators, e.g. "+7 (701) 123-45-67".
+	PhoneRX = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{5,18}[0-9]$`)
+
+	// CountryRX matches ISO 3166-1 alpha-2 country codes, e.g. "KZ".
+	CountryRX = regexp.MustCompile(`^[A-Z]{2}$`)
 )
 
 // Define a new Validator type which contains a map of validation errors.
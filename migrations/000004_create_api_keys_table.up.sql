@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    hash bytea NOT NULL UNIQUE,
+    scopes text[] NOT NULL,
+    rate_limit_rps double precision NOT NULL DEFAULT 5,
+    rate_limit_burst integer NOT NULL DEFAULT 10,
+    expires_at timestamp(0) with time zone,
+    created_by bigint NOT NULL REFERENCES users,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_used_at timestamp(0) with time zone,
+    usage_count bigint NOT NULL DEFAULT 0,
+    revoked_at timestamp(0) with time zone
+);
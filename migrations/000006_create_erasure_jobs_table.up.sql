@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS erased_at timestamp(0) with time zone;
+
+CREATE TABLE IF NOT EXISTS erasure_jobs (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users,
+    status text NOT NULL,
+    error text NOT NULL DEFAULT '',
+    requested_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    completed_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS erasure_jobs_user_id_idx ON erasure_jobs (user_id);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS addresses;
+
+ALTER TABLE users DROP COLUMN IF EXISTS marketing_opt_in;
+ALTER TABLE users DROP COLUMN IF EXISTS contact_by_sms;
+ALTER TABLE users DROP COLUMN IF EXISTS contact_by_email;
+ALTER TABLE users DROP COLUMN IF EXISTS phone;
+ALTER TABLE users DROP COLUMN IF EXISTS display_name;
+ALTER TABLE users DROP COLUMN IF EXISTS email;
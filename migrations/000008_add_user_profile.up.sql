@@ -0,0 +1,25 @@
+CREATE EXTENSION IF NOT EXISTS citext;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email citext UNIQUE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name text NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone text NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS contact_by_email boolean NOT NULL DEFAULT true;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS contact_by_sms boolean NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS marketing_opt_in boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS addresses (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    label text NOT NULL DEFAULT '',
+    recipient text NOT NULL DEFAULT '',
+    line1 text NOT NULL,
+    line2 text NOT NULL DEFAULT '',
+    city text NOT NULL,
+    region text NOT NULL DEFAULT '',
+    postal_code text NOT NULL,
+    country char(2) NOT NULL,
+    is_default boolean NOT NULL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS addresses_user_id_idx ON addresses (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS addresses_user_id_default_idx ON addresses (user_id) WHERE is_default;
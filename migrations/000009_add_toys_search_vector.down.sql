@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS toys_search_idx;
+
+ALTER TABLE toys DROP COLUMN IF EXISTS search;
+ALTER TABLE toys DROP COLUMN IF EXISTS language;
@@ -0,0 +1,9 @@
+ALTER TABLE toys ADD COLUMN IF NOT EXISTS language regconfig NOT NULL DEFAULT 'english';
+
+ALTER TABLE toys ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector(language, coalesce(title, '')), 'A') ||
+    setweight(to_tsvector(language, coalesce(manufacturer, '')), 'B') ||
+    setweight(to_tsvector(language, coalesce("desc", '')), 'C')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS toys_search_idx ON toys USING GIN (search);
@@ -0,0 +1 @@
+DROP MATERIALIZED VIEW IF EXISTS toy_search_terms;
@@ -0,0 +1,28 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE EXTENSION IF NOT EXISTS fuzzystrmatch;
+
+-- The vocabulary for autocomplete and "did you mean" suggestions: whole titles,
+-- manufacturers and categories, plus the single words they consist of. It's refreshed
+-- periodically by the API server.
+CREATE MATERIALIZED VIEW IF NOT EXISTS toy_search_terms AS
+SELECT term, kind, count(*) AS toys
+FROM (
+    SELECT title AS term, 'title' AS kind FROM toys
+    UNION ALL
+    SELECT manufacturer, 'manufacturer' FROM toys
+    UNION ALL
+    SELECT unnest(categories), 'category' FROM toys
+    UNION ALL
+    SELECT word, 'word'
+    FROM (
+        SELECT DISTINCT id, word
+        FROM toys, regexp_split_to_table(lower(title || ' ' || manufacturer || ' ' || array_to_string(categories, ' ')), '[^[:alnum:]]+') AS word
+    ) AS words
+    WHERE length(word) >= 3
+) AS terms
+WHERE term <> ''
+GROUP BY term, kind;
+
+-- The unique index is required to refresh the view concurrently.
+CREATE UNIQUE INDEX IF NOT EXISTS toy_search_terms_term_kind_idx ON toy_search_terms (term, kind);
+CREATE INDEX IF NOT EXISTS toy_search_terms_trgm_idx ON toy_search_terms USING GIN (lower(term) gin_trgm_ops);
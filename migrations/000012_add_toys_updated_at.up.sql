@@ -0,0 +1,3 @@
+ALTER TABLE toys ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT now();
+
+UPDATE toys SET updated_at = created_at;
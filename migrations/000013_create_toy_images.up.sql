@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS toy_images (
+    id bigserial PRIMARY KEY,
+    toy_id bigint NOT NULL REFERENCES toys ON DELETE CASCADE,
+    storage_key text NOT NULL UNIQUE,
+    content_type text NOT NULL,
+    size bigint NOT NULL,
+    position integer NOT NULL,
+    is_primary boolean NOT NULL DEFAULT false,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS toy_images_toy_id_idx ON toy_images (toy_id, position);
+
+-- A toy has at most one primary image.
+CREATE UNIQUE INDEX IF NOT EXISTS toy_images_primary_idx ON toy_images (toy_id) WHERE is_primary;
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS toy_image_variants;
+
+DROP INDEX IF EXISTS toy_images_thumbnail_pending_idx;
+
+ALTER TABLE toy_images
+    DROP COLUMN IF EXISTS width,
+    DROP COLUMN IF EXISTS height,
+    DROP COLUMN IF EXISTS thumbnail_status,
+    DROP COLUMN IF EXISTS thumbnail_attempts,
+    DROP COLUMN IF EXISTS thumbnail_error,
+    DROP COLUMN IF EXISTS thumbnail_next_attempt_at;
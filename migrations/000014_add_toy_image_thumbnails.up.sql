@@ -0,0 +1,17 @@
+ALTER TABLE toy_images
+    ADD COLUMN IF NOT EXISTS width integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS height integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS thumbnail_status text NOT NULL DEFAULT 'pending',
+    ADD COLUMN IF NOT EXISTS thumbnail_attempts integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS thumbnail_error text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS thumbnail_next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS toy_images_thumbnail_pending_idx ON toy_images (thumbnail_next_attempt_at) WHERE thumbnail_status = 'pending';
+
+CREATE TABLE IF NOT EXISTS toy_image_variants (
+    image_id bigint NOT NULL REFERENCES toy_images ON DELETE CASCADE,
+    width integer NOT NULL,
+    height integer NOT NULL,
+    storage_key text NOT NULL UNIQUE,
+    PRIMARY KEY (image_id, width)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE toys ADD COLUMN IF NOT EXISTS supplier_sku text;
+
+CREATE UNIQUE INDEX IF NOT EXISTS toys_supplier_sku_idx ON toys (supplier_sku);
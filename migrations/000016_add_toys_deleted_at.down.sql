@@ -0,0 +1,27 @@
+DROP MATERIALIZED VIEW IF EXISTS toy_search_terms;
+
+CREATE MATERIALIZED VIEW toy_search_terms AS
+SELECT term, kind, count(*) AS toys
+FROM (
+    SELECT title AS term, 'title' AS kind FROM toys
+    UNION ALL
+    SELECT manufacturer, 'manufacturer' FROM toys
+    UNION ALL
+    SELECT unnest(categories), 'category' FROM toys
+    UNION ALL
+    SELECT word, 'word'
+    FROM (
+        SELECT DISTINCT id, word
+        FROM toys, regexp_split_to_table(lower(title || ' ' || manufacturer || ' ' || array_to_string(categories, ' ')), '[^[:alnum:]]+') AS word
+    ) AS words
+    WHERE length(word) >= 3
+) AS terms
+WHERE term <> ''
+GROUP BY term, kind;
+
+CREATE UNIQUE INDEX IF NOT EXISTS toy_search_terms_term_kind_idx ON toy_search_terms (term, kind);
+CREATE INDEX IF NOT EXISTS toy_search_terms_trgm_idx ON toy_search_terms USING GIN (lower(term) gin_trgm_ops);
+
+DROP INDEX IF EXISTS toys_deleted_at_idx;
+
+ALTER TABLE toys DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,30 @@
+ALTER TABLE toys ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS toys_deleted_at_idx ON toys (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Toys in the trash must not be suggested anymore, so the vocabulary is rebuilt from the
+-- other toys only.
+DROP MATERIALIZED VIEW IF EXISTS toy_search_terms;
+
+CREATE MATERIALIZED VIEW toy_search_terms AS
+SELECT term, kind, count(*) AS toys
+FROM (
+    SELECT title AS term, 'title' AS kind FROM toys WHERE deleted_at IS NULL
+    UNION ALL
+    SELECT manufacturer, 'manufacturer' FROM toys WHERE deleted_at IS NULL
+    UNION ALL
+    SELECT unnest(categories), 'category' FROM toys WHERE deleted_at IS NULL
+    UNION ALL
+    SELECT word, 'word'
+    FROM (
+        SELECT DISTINCT id, word
+        FROM toys, regexp_split_to_table(lower(title || ' ' || manufacturer || ' ' || array_to_string(categories, ' ')), '[^[:alnum:]]+') AS word
+        WHERE deleted_at IS NULL
+    ) AS words
+    WHERE length(word) >= 3
+) AS terms
+WHERE term <> ''
+GROUP BY term, kind;
+
+CREATE UNIQUE INDEX IF NOT EXISTS toy_search_terms_term_kind_idx ON toy_search_terms (term, kind);
+CREATE INDEX IF NOT EXISTS toy_search_terms_trgm_idx ON toy_search_terms USING GIN (lower(term) gin_trgm_ops);
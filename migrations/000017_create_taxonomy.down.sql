@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS toy_skills;
+DROP TABLE IF EXISTS toy_categories;
+DROP TABLE IF EXISTS skills;
+DROP TABLE IF EXISTS categories;
+DROP FUNCTION IF EXISTS taxonomy_slug(text);
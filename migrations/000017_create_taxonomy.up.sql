@@ -0,0 +1,97 @@
+-- taxonomy_slug() is the SQL version of data.Slugify(), used to turn the free-text
+-- categories and skills of the existing toys into slugs.
+CREATE OR REPLACE FUNCTION taxonomy_slug(value text) RETURNS text
+LANGUAGE sql IMMUTABLE AS $$
+    SELECT trim(both '-' FROM regexp_replace(lower(value), '[^[:alnum:]]+', '-', 'g'))
+$$;
+
+CREATE TABLE IF NOT EXISTS categories (
+    id bigserial PRIMARY KEY,
+    parent_id bigint REFERENCES categories ON DELETE RESTRICT,
+    slug text NOT NULL UNIQUE,
+    name text NOT NULL,
+    translations jsonb NOT NULL DEFAULT '{}',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id);
+
+CREATE TABLE IF NOT EXISTS skills (
+    id bigserial PRIMARY KEY,
+    parent_id bigint REFERENCES skills ON DELETE RESTRICT,
+    slug text NOT NULL UNIQUE,
+    name text NOT NULL,
+    translations jsonb NOT NULL DEFAULT '{}',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS skills_parent_id_idx ON skills (parent_id);
+
+-- The arrays on toys keep the names for display and search, while these tables tie
+-- the toys to the terms themselves.
+CREATE TABLE IF NOT EXISTS toy_categories (
+    toy_id bigint NOT NULL REFERENCES toys ON DELETE CASCADE,
+    category_id bigint NOT NULL REFERENCES categories ON DELETE RESTRICT,
+    PRIMARY KEY (toy_id, category_id)
+);
+
+CREATE INDEX IF NOT EXISTS toy_categories_category_id_idx ON toy_categories (category_id);
+
+CREATE TABLE IF NOT EXISTS toy_skills (
+    toy_id bigint NOT NULL REFERENCES toys ON DELETE CASCADE,
+    skill_id bigint NOT NULL REFERENCES skills ON DELETE RESTRICT,
+    PRIMARY KEY (toy_id, skill_id)
+);
+
+CREATE INDEX IF NOT EXISTS toy_skills_skill_id_idx ON toy_skills (skill_id);
+
+-- Every existing value becomes a top-level term. Values which only differ in case or
+-- punctuation, like "STEM" and "stem", share one term, named after the most common
+-- spelling.
+INSERT INTO categories (slug, name)
+SELECT DISTINCT ON (taxonomy_slug(name)) taxonomy_slug(name), name
+FROM toys, unnest(categories) AS name
+WHERE taxonomy_slug(name) <> ''
+GROUP BY name
+ORDER BY taxonomy_slug(name), count(*) DESC, name
+ON CONFLICT (slug) DO NOTHING;
+
+INSERT INTO skills (slug, name)
+SELECT DISTINCT ON (taxonomy_slug(name)) taxonomy_slug(name), name
+FROM toys, unnest(skills) AS name
+WHERE taxonomy_slug(name) <> ''
+GROUP BY name
+ORDER BY taxonomy_slug(name), count(*) DESC, name
+ON CONFLICT (slug) DO NOTHING;
+
+INSERT INTO toy_categories (toy_id, category_id)
+SELECT DISTINCT toys.id, categories.id
+FROM toys, unnest(toys.categories) AS name
+JOIN categories ON categories.slug = taxonomy_slug(name)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO toy_skills (toy_id, skill_id)
+SELECT DISTINCT toys.id, skills.id
+FROM toys, unnest(toys.skills) AS name
+JOIN skills ON skills.slug = taxonomy_slug(name)
+ON CONFLICT DO NOTHING;
+
+-- The arrays get the names of the terms, in their original order and without the
+-- duplicates which only differed in case.
+UPDATE toys SET categories = ARRAY(
+    SELECT categories.name
+    FROM unnest(toys.categories) WITH ORDINALITY AS value (name, position)
+    JOIN categories ON categories.slug = taxonomy_slug(value.name)
+    GROUP BY categories.name
+    ORDER BY min(value.position)
+);
+
+UPDATE toys SET skills = ARRAY(
+    SELECT skills.name
+    FROM unnest(toys.skills) WITH ORDINALITY AS value (name, position)
+    JOIN skills ON skills.slug = taxonomy_slug(value.name)
+    GROUP BY skills.name
+    ORDER BY min(value.position)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE toys DROP COLUMN IF EXISTS manufacturer_id;
+DROP TABLE IF EXISTS manufacturers;
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS manufacturers (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    country text NOT NULL DEFAULT '',
+    website text NOT NULL DEFAULT '',
+    safety_contact text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS manufacturers_name_idx ON manufacturers (lower(name));
+
+-- Every distinct manufacturer of the existing toys becomes a row. Spellings which only
+-- differ in case or surrounding spaces, like "LEGO" and "Lego ", share one row, named
+-- after the most common spelling.
+INSERT INTO manufacturers (name)
+SELECT DISTINCT ON (lower(trim(manufacturer))) trim(manufacturer)
+FROM toys
+WHERE trim(manufacturer) <> ''
+GROUP BY trim(manufacturer)
+ORDER BY lower(trim(manufacturer)), count(*) DESC, trim(manufacturer)
+ON CONFLICT DO NOTHING;
+
+-- The name stays on the toys for display, search and facets, while manufacturer_id
+-- ties them to the manufacturer itself. Old toys without a manufacturer keep a NULL.
+ALTER TABLE toys ADD COLUMN IF NOT EXISTS manufacturer_id bigint REFERENCES manufacturers ON DELETE RESTRICT;
+
+CREATE INDEX IF NOT EXISTS toys_manufacturer_id_idx ON toys (manufacturer_id);
+
+UPDATE toys
+SET manufacturer_id = manufacturers.id, manufacturer = manufacturers.name
+FROM manufacturers
+WHERE lower(manufacturers.name) = lower(trim(toys.manufacturer));
@@ -0,0 +1,6 @@
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS attribute_schema jsonb NOT NULL DEFAULT '{}';
+
+ALTER TABLE toys ADD COLUMN IF NOT EXISTS attributes jsonb NOT NULL DEFAULT '{}';
+
+-- The attribute filters use the @? operator, which this index supports for equality.
+CREATE INDEX IF NOT EXISTS toys_attributes_idx ON toys USING GIN (attributes);
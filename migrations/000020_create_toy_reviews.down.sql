@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS toys_rating_idx;
+ALTER TABLE toys DROP COLUMN IF EXISTS rating_count;
+ALTER TABLE toys DROP COLUMN IF EXISTS rating;
+DROP TABLE IF EXISTS toy_reviews;
+DROP TABLE IF EXISTS toy_returns;
@@ -0,0 +1,29 @@
+-- There is no rental history yet, so staff record the returns of toys here. Only users
+-- who have returned a toy can review it.
+CREATE TABLE IF NOT EXISTS toy_returns (
+    id bigserial PRIMARY KEY,
+    toy_id bigint NOT NULL REFERENCES toys ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    returned_at timestamp(0) with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS toy_returns_toy_id_user_id_idx ON toy_returns (toy_id, user_id);
+
+CREATE TABLE IF NOT EXISTS toy_reviews (
+    id bigserial PRIMARY KEY,
+    toy_id bigint NOT NULL REFERENCES toys ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    rating integer NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    body text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    UNIQUE (toy_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS toy_reviews_toy_id_created_at_idx ON toy_reviews (toy_id, created_at);
+
+-- The average rating and the number of reviews are kept on the toy, so that the
+-- listing can sort by them.
+ALTER TABLE toys ADD COLUMN IF NOT EXISTS rating numeric(3, 2) NOT NULL DEFAULT 0;
+ALTER TABLE toys ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS toys_rating_idx ON toys (rating, id) WHERE deleted_at IS NULL;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS token_ledger (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users,
+    amount bigint NOT NULL,
+    reason text NOT NULL,
+    subscription_id bigint,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS token_ledger_user_id_idx ON token_ledger (user_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS subscriptions_payment_intent_id_idx;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS confirmed_at;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS payment_intent_id;
+DROP TABLE IF EXISTS subscription_plans;
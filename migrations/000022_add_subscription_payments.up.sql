@@ -0,0 +1,19 @@
+-- Subscriptions are sold from plans with a server-side price. A subscription stays
+-- pending until its Stripe payment is confirmed, and only then are its tokens credited.
+CREATE TABLE IF NOT EXISTS subscription_plans (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    tokens bigint NOT NULL CHECK (tokens > 0),
+    price bigint NOT NULL CHECK (price > 0),
+    currency text NOT NULL
+);
+
+-- The IDs of new subscriptions are generated by the database instead of the client.
+CREATE SEQUENCE IF NOT EXISTS subscriptions_id_seq OWNED BY subscriptions.id;
+SELECT setval('subscriptions_id_seq', COALESCE((SELECT max(id) FROM subscriptions), 0) + 1, false);
+ALTER TABLE subscriptions ALTER COLUMN id SET DEFAULT nextval('subscriptions_id_seq');
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS payment_intent_id text NOT NULL DEFAULT '';
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS confirmed_at timestamp(0) with time zone;
+
+CREATE UNIQUE INDEX IF NOT EXISTS subscriptions_payment_intent_id_idx ON subscriptions (payment_intent_id) WHERE payment_intent_id <> '';
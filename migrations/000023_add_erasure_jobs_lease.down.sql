@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS erasure_jobs_unfinished_idx;
+
+ALTER TABLE erasure_jobs DROP COLUMN IF EXISTS lease_expires_at;
@@ -0,0 +1,3 @@
+ALTER TABLE erasure_jobs ADD COLUMN IF NOT EXISTS lease_expires_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS erasure_jobs_unfinished_idx ON erasure_jobs (id) WHERE status IN ('pending', 'running');
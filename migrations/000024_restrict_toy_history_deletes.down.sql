@@ -0,0 +1,5 @@
+ALTER TABLE toy_reviews DROP CONSTRAINT IF EXISTS toy_reviews_toy_id_fkey;
+ALTER TABLE toy_reviews ADD CONSTRAINT toy_reviews_toy_id_fkey FOREIGN KEY (toy_id) REFERENCES toys ON DELETE CASCADE;
+
+ALTER TABLE toy_returns DROP CONSTRAINT IF EXISTS toy_returns_toy_id_fkey;
+ALTER TABLE toy_returns ADD CONSTRAINT toy_returns_toy_id_fkey FOREIGN KEY (toy_id) REFERENCES toys ON DELETE CASCADE;
@@ -0,0 +1,8 @@
+-- The returns and reviews of a toy are its rental history, which must survive the toy
+-- being purged from the trash. Toys with a history are never purged, and the foreign
+-- keys make sure of it.
+ALTER TABLE toy_returns DROP CONSTRAINT IF EXISTS toy_returns_toy_id_fkey;
+ALTER TABLE toy_returns ADD CONSTRAINT toy_returns_toy_id_fkey FOREIGN KEY (toy_id) REFERENCES toys ON DELETE RESTRICT;
+
+ALTER TABLE toy_reviews DROP CONSTRAINT IF EXISTS toy_reviews_toy_id_fkey;
+ALTER TABLE toy_reviews ADD CONSTRAINT toy_reviews_toy_id_fkey FOREIGN KEY (toy_id) REFERENCES toys ON DELETE RESTRICT;
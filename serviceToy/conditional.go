@@ -0,0 +1,92 @@
+package serviceToy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"toy-rental-system/internal/data"
+)
+
+// toyETag returns the strong entity tag of a toy. The version changes with every
+// update, and the number of reviews with every change of the rating, which isn't an
+// update of the toy. Together with the ID they identify the representation of the toy.
+func toyETag(toy *data.Toy) string {
+	return fmt.Sprintf(`"toy-%d-v%d-r%d"`, toy.ID, toy.Version, toy.RatingCount)
+}
+
+// listETag returns the strong entity tag of a listing, which is a hash of the response.
+func listETag(env envelope) (string, error) {
+	js, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(js)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header lists the entity tag,
+// or is "*". If-None-Match uses the weak comparison, which ignores the W/ prefix, while
+// If-Match needs the strong one, which never matches a weak tag.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag and Last-Modified headers, and reports whether the copy the
+// client has cached is still current, in which case a 304 response has been sent. A
+// zero lastModified leaves out the header and If-Modified-Since.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since, which only has a precision
+	// of seconds.
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		if !etagMatches(header, etag, true) {
+			return false
+		}
+	} else {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || lastModified.IsZero() || lastModified.Truncate(time.Second).After(since) {
+			return false
+		}
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// preconditionFailed reports whether the request has an If-Match header which doesn't
+// match the current entity tag of the toy, in which case a 412 response has been sent.
+func (s *toyService) preconditionFailed(w http.ResponseWriter, r *http.Request, toy *data.Toy) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" || etagMatches(header, toyETag(toy), false) {
+		return false
+	}
+
+	s.preconditionFailedResponse(w, r)
+	return true
+}
@@ -0,0 +1,68 @@
+package serviceToy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"toy-rental-system/internal/data"
+)
+
+// The errorResponse() helper sends a JSON error message in the same format as the rest
+// of the API.
+func (s *toyService) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
+	err := s.helper.WriteJSON(w, status, envelope{"error": message}, nil)
+	if err != nil {
+		s.logger.PrintError(err, map[string]string{
+			"request_method": r.Method,
+			"request_url":    r.URL.String(),
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (s *toyService) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	s.logger.PrintError(err, map[string]string{
+		"request_method": r.Method,
+		"request_url":    r.URL.String(),
+	})
+
+	message := "the server encountered a problem and could not process your request"
+	s.errorResponse(w, r, http.StatusInternalServerError, message)
+}
+
+func (s *toyService) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
+	s.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+}
+
+func (s *toyService) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
+	s.errorResponse(w, r, http.StatusBadRequest, err.Error())
+}
+
+func (s *toyService) notFoundResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the requested resource could not be found"
+	s.errorResponse(w, r, http.StatusNotFound, message)
+}
+
+func (s *toyService) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	s.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (s *toyService) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since you last fetched it"
+	s.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (s *toyService) imageTooLargeResponse(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("image must not be larger than %d bytes", s.maxImageSize)
+	s.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+}
+
+func (s *toyService) unknownTermsResponse(w http.ResponseWriter, r *http.Request, err *data.UnknownTermsError) {
+	message := "must only contain values from the taxonomy, unknown: " + strings.Join(err.Values, ", ")
+	s.failedValidationResponse(w, r, map[string]string{string(err.Kind): message})
+}
+
+func (s *toyService) unknownManufacturerResponse(w http.ResponseWriter, r *http.Request) {
+	s.failedValidationResponse(w, r, map[string]string{"manufacturer": "must be the name of a known manufacturer"})
+}
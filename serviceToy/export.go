@@ -0,0 +1,222 @@
+package serviceToy
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/validator"
+)
+
+// exportFlushRows is the number of rows after which an export is flushed to the client.
+const exportFlushRows = 500
+
+// exportColumns are the columns of a CSV export. Apart from the ID, version and
+// timestamps they are the same as the importColumns, so that an edited export can be
+// imported again once those columns are removed.
+var exportColumns = append(append([]string{"id"}, importColumns...), "version", "created_at", "updated_at")
+
+// exportedToy is an NDJSON line of an export.
+type exportedToy struct {
+	ID             int64           `json:"id"`
+	SupplierSKU    string          `json:"supplier_sku"`
+	Title          string          `json:"title"`
+	Description    string          `json:"desc"`
+	Details        []string        `json:"details"`
+	Skills         []string        `json:"skills"`
+	Categories     []string        `json:"categories"`
+	Images         []string        `json:"images"`
+	RecommendedAge string          `json:"recommended_age"`
+	Manufacturer   string          `json:"manufacturer"`
+	Value          int64           `json:"value"`
+	IsAvailable    bool            `json:"is_available"`
+	Language       string          `json:"language"`
+	Attributes     data.Attributes `json:"attributes"`
+	Version        int32           `json:"version"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// ExportToysHandler streams the toys matching the filters of the toy listing as CSV or
+// NDJSON. In a CSV export the lists are joined with the delimiter, "|" by default, see
+// joinList().
+func (s *toyService) ExportToysHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+	filter := s.readToyFilter(qs, v)
+	format := s.helper.ReadString(qs, "format", "csv")
+	delimiter := s.helper.ReadString(qs, "delimiter", "|")
+
+	v.Check(validator.PermittedValue(format, "csv", "ndjson"), "format", "must be csv or ndjson")
+	validateDelimiter(v, delimiter)
+
+	if !v.Valid() {
+		s.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// An export of the whole catalog can take longer than the server's write timeout
+	// allows for ordinary responses. Not every ResponseWriter supports this.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(10 * time.Minute))
+
+	filename := fmt.Sprintf("toys-%s.%s", time.Now().UTC().Format("2006-01-02"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	out := &exportWriter{w: w}
+	bw := bufio.NewWriter(out)
+
+	var write func(toy *data.Toy) error
+	switch format {
+	case "ndjson":
+		w.Header().Set("Content-Type", ndjsonContentType)
+		enc := json.NewEncoder(bw)
+		write = func(toy *data.Toy) error {
+			return enc.Encode(exportedToy{
+				ID:             toy.ID,
+				SupplierSKU:    toy.SupplierSKU,
+				Title:          toy.Title,
+				Description:    toy.Description,
+				Details:        nonNil(toy.Details),
+				Skills:         nonNil(toy.Skills),
+				Categories:     nonNil(toy.Categories),
+				Images:         nonNil(toy.Images),
+				RecommendedAge: toy.RecommendedAge,
+				Manufacturer:   toy.Manufacturer,
+				Value:          toy.Value,
+				IsAvailable:    toy.IsAvailable,
+				Language:       toy.Language,
+				Attributes:     nonNilAttributes(toy.Attributes),
+				Version:        toy.Version,
+				CreatedAt:      toy.CreatedAt,
+				UpdatedAt:      toy.UpdatedAt,
+			})
+		}
+	default:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(bw)
+		write = func(toy *data.Toy) error {
+			attributes, err := json.Marshal(nonNilAttributes(toy.Attributes))
+			if err != nil {
+				return err
+			}
+
+			cw.Write([]string{
+				strconv.FormatInt(toy.ID, 10),
+				csvCell(toy.SupplierSKU),
+				csvCell(toy.Title),
+				csvCell(toy.Description),
+				csvCell(joinList(toy.Details, delimiter)),
+				csvCell(joinList(toy.Skills, delimiter)),
+				csvCell(joinList(toy.Categories, delimiter)),
+				csvCell(joinList(toy.Images, delimiter)),
+				csvCell(toy.RecommendedAge),
+				csvCell(toy.Manufacturer),
+				strconv.FormatInt(toy.Value, 10),
+				strconv.FormatBool(toy.IsAvailable),
+				csvCell(toy.Language),
+				string(attributes),
+				strconv.FormatInt(int64(toy.Version), 10),
+				toy.CreatedAt.Format(time.RFC3339),
+				toy.UpdatedAt.Format(time.RFC3339),
+			})
+			// The csv.Writer buffers as well, so it's flushed into bw on every row.
+			cw.Flush()
+			return cw.Error()
+		}
+		cw.Write(exportColumns)
+	}
+
+	rows := 0
+	err := s.toyRepository.Export(r.Context(), filter, func(toy *data.Toy) error {
+		err := write(toy)
+		if err != nil {
+			return err
+		}
+
+		rows++
+		if rows%exportFlushRows == 0 {
+			err = bw.Flush()
+			if err != nil {
+				return err
+			}
+			http.NewResponseController(w).Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+
+	// Once the first rows are sent, the status can't be changed anymore. The client
+	// notices the failed export by the missing end of the file.
+	if err != nil {
+		if !out.sent {
+			w.Header().Del("Content-Disposition")
+			s.serverErrorResponse(w, r, err)
+			return
+		}
+		s.logger.PrintError(err, map[string]string{
+			"request_method": r.Method,
+			"request_url":    r.URL.String(),
+		})
+	}
+}
+
+// csvFormulaPrefixes are the characters which make a spreadsheet evaluate a cell as a
+// formula.
+const csvFormulaPrefixes = "=+-@"
+
+// csvCell keeps a spreadsheet from running the text of a cell as a formula, by
+// prefixing it with a single quote. The import strips the quote again.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune(csvFormulaPrefixes, rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// joinList joins the values of a list with the delimiter. A delimiter or a backslash
+// within a value is escaped with a backslash, so that splitList() gets the same values
+// back.
+func joinList(values []string, delimiter string) string {
+	escaped := make([]string, len(values))
+	for i, value := range values {
+		value = strings.ReplaceAll(value, `\`, `\\`)
+		escaped[i] = strings.ReplaceAll(value, delimiter, `\`+delimiter)
+	}
+	return strings.Join(escaped, delimiter)
+}
+
+// exportWriter remembers whether anything has been written to the client yet.
+type exportWriter struct {
+	w    http.ResponseWriter
+	sent bool
+}
+
+func (ew *exportWriter) Write(p []byte) (int, error) {
+	ew.sent = true
+	return ew.w.Write(p)
+}
+
+// nonNil turns a nil list into an empty one, so that it's exported as [] rather than
+// null.
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// nonNilAttributes does the same for the attributes, which are exported as {}.
+func nonNilAttributes(attributes data.Attributes) data.Attributes {
+	if attributes == nil {
+		return data.Attributes{}
+	}
+	return attributes
+}
@@ -0,0 +1,339 @@
+package serviceToy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"net/http"
+	"strconv"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/thumbnail"
+	"toy-rental-system/internal/validator"
+)
+
+// imageTypes are the image formats which can be uploaded, with the file extension they
+// are stored with. The type is sniffed from the content, the client's word for it is
+// not trusted.
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// UploadToyImageHandler stores the image in the "image" field of a multipart form as a
+// new photo of the toy. With primary=true, it becomes the primary image.
+func (s *toyService) UploadToyImageHandler(w http.ResponseWriter, r *http.Request) {
+	toy, ok := s.readToy(w, r)
+	if !ok {
+		return
+	}
+
+	if s.preconditionFailed(w, r, toy) {
+		return
+	}
+
+	// The body may be a little larger than the image, for the rest of the form.
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxImageSize+1<<20)
+
+	err := r.ParseMultipartForm(1 << 20)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			s.imageTooLargeResponse(w, r)
+		default:
+			s.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	v := validator.New()
+
+	primary := false
+	if value := r.FormValue("primary"); value != "" {
+		primary, err = strconv.ParseBool(value)
+		v.Check(err == nil, "primary", "must be a boolean value")
+	}
+
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		v.AddError("image", "must be provided")
+	}
+
+	if !v.Valid() {
+		s.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > s.maxImageSize {
+		s.imageTooLargeResponse(w, r)
+		return
+	}
+
+	// http.DetectContentType() looks at no more than the first 512 bytes.
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(file, sniff)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		s.badRequestResponse(w, r, err)
+		return
+	}
+
+	contentType := http.DetectContentType(sniff[:n])
+	extension, ok := imageTypes[contentType]
+	if !ok {
+		s.errorResponse(w, r, http.StatusUnsupportedMediaType, "image must be a JPEG, PNG, GIF or WebP file")
+		return
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+		return
+	}
+
+	images, err := s.images.GetAllForToy(toy.ID)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if len(images) >= data.MaxToyImages {
+		s.failedValidationResponse(w, r, map[string]string{"image": fmt.Sprintf("a toy can't have more than %d images", data.MaxToyImages)})
+		return
+	}
+
+	name := make([]byte, 16)
+	_, err = rand.Read(name)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+		return
+	}
+
+	image := &data.ToyImage{
+		ToyID:       toy.ID,
+		Key:         fmt.Sprintf("toys/%d/%s%s", toy.ID, hex.EncodeToString(name), extension),
+		ContentType: contentType,
+		Size:        header.Size,
+		IsPrimary:   primary,
+		// The thumbnails are generated by a background worker.
+		ThumbnailStatus: data.ThumbnailsPending,
+	}
+	if !thumbnail.Supported(contentType) {
+		image.ThumbnailStatus = data.ThumbnailsSkipped
+	}
+
+	err = s.storage.Put(r.Context(), image.Key, file)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = s.images.InsertAudited(image, audit.FromContext(r.Context()))
+	if err != nil {
+		// Don't leave the file behind if it's not referenced by the toy.
+		s.deleteImageFiles(r, image)
+
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			s.notFoundResponse(w, r)
+		default:
+			s.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	image.SetURLs(s.storage.URL)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/toy/%d/images", toy.ID))
+
+	err = s.helper.WriteJSON(w, http.StatusCreated, envelope{"image": image}, headers)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+	}
+}
+
+func (s *toyService) ListToyImagesHandler(w http.ResponseWriter, r *http.Request) {
+	toy, ok := s.readToy(w, r)
+	if !ok {
+		return
+	}
+
+	images, err := s.toyImages(toy.ID)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = s.helper.WriteJSON(w, http.StatusOK, envelope{"images": images}, nil)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+	}
+}
+
+// ArrangeToyImagesHandler changes the order of the images of a toy, which must list all
+// of them, and/or the primary image.
+func (s *toyService) ArrangeToyImagesHandler(w http.ResponseWriter, r *http.Request) {
+	toy, ok := s.readToy(w, r)
+	if !ok {
+		return
+	}
+
+	if s.preconditionFailed(w, r, toy) {
+		return
+	}
+
+	var input struct {
+		Order   []int64 `json:"order"`
+		Primary int64   `json:"primary"`
+	}
+
+	err := s.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		s.badRequestResponse(w, r, err)
+		return
+	}
+
+	images, err := s.images.GetAllForToy(toy.ID)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ids := make(map[int64]bool, len(images))
+	for _, image := range images {
+		ids[image.ID] = true
+	}
+
+	v := validator.New()
+	v.Check(input.Order != nil || input.Primary != 0, "order", "order or primary must be provided")
+
+	if input.Order != nil {
+		listed := true
+		for _, id := range input.Order {
+			listed = listed && ids[id]
+		}
+		v.Check(listed && len(input.Order) == len(images) && validator.Unique(input.Order), "order", "must list every image of the toy once")
+	}
+	if input.Primary != 0 {
+		v.Check(ids[input.Primary], "primary", "must be an image of the toy")
+	}
+
+	if !v.Valid() {
+		s.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = s.images.ArrangeAudited(toy.ID, input.Order, input.Primary, audit.FromContext(r.Context()))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			s.notFoundResponse(w, r)
+		default:
+			s.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	images, err = s.toyImages(toy.ID)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = s.helper.WriteJSON(w, http.StatusOK, envelope{"images": images}, nil)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+	}
+}
+
+func (s *toyService) DeleteToyImageHandler(w http.ResponseWriter, r *http.Request) {
+	toy, ok := s.readToy(w, r)
+	if !ok {
+		return
+	}
+
+	if s.preconditionFailed(w, r, toy) {
+		return
+	}
+
+	imageID, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("image_id"), 10, 64)
+	if err != nil || imageID < 1 {
+		s.notFoundResponse(w, r)
+		return
+	}
+
+	image, err := s.images.DeleteAudited(toy.ID, imageID, audit.FromContext(r.Context()))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			s.notFoundResponse(w, r)
+		default:
+			s.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	s.deleteImageFiles(r, image)
+
+	err = s.helper.WriteJSON(w, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+	}
+}
+
+// readToy fetches the toy in the id parameter of the route. It returns false if it has
+// sent an error response instead.
+func (s *toyService) readToy(w http.ResponseWriter, r *http.Request) (*data.Toy, bool) {
+	id, err := s.helper.ReadIdParam(r)
+	if err != nil {
+		s.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	toy, err := s.toyRepository.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			s.notFoundResponse(w, r)
+		default:
+			s.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return toy, true
+}
+
+// toyImages returns the images of the toy, with the URLs they and their thumbnails can
+// be fetched from.
+func (s *toyService) toyImages(toyID int64) ([]*data.ToyImage, error) {
+	images, err := s.images.GetAllForToy(toyID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, image := range images {
+		image.SetURLs(s.storage.URL)
+	}
+	return images, nil
+}
+
+// deleteImageFiles removes the files of an image and its thumbnails, which are no
+// longer referenced. This is best effort: a failure is logged, but doesn't fail the
+// request.
+func (s *toyService) deleteImageFiles(r *http.Request, image *data.ToyImage) {
+	for _, key := range image.Keys() {
+		err := s.storage.Delete(r.Context(), key)
+		if err != nil {
+			s.logger.PrintError(err, map[string]string{"storage_key": key})
+		}
+	}
+}
@@ -0,0 +1,552 @@
+package serviceToy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/validator"
+)
+
+const (
+	maxImportSize = 10 << 20
+	maxImportRows = 5000
+
+	ndjsonContentType = "application/x-ndjson"
+)
+
+// importColumns are the columns of a CSV import. An NDJSON import has the same fields,
+// with JSON arrays for the lists. The attributes are a JSON object in either format.
+var importColumns = []string{"supplier_sku", "title", "desc", "details", "skills", "categories", "images", "recommended_age", "manufacturer", "value", "is_available", "language", "attributes"}
+
+// importRow is a toy as read from an import, before it's validated. Row is the line of
+// the file the toy starts on.
+type importRow struct {
+	Row    int
+	Toy    *data.Toy
+	Errors map[string]string
+}
+
+type importRowError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+type importReport struct {
+	DryRun  bool             `json:"dry_run"`
+	Rows    int              `json:"rows"`
+	Created int              `json:"created"`
+	Updated int              `json:"updated"`
+	Failed  int              `json:"failed"`
+	Errors  []importRowError `json:"errors"`
+}
+
+// ImportToysHandler creates toys from a CSV or NDJSON body. Every row is validated on
+// its own, the valid ones are imported and the others are reported back with their
+// errors. With dry_run=true nothing is imported, and with upsert=true the rows whose
+// supplier_sku already exists update that toy instead of being rejected.
+func (s *toyService) ImportToysHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+	dryRun := s.helper.ReadBool(qs, "dry_run", false, v)
+	upsert := s.helper.ReadBool(qs, "upsert", false, v)
+	delimiter := s.helper.ReadString(qs, "delimiter", "|")
+
+	validateDelimiter(v, delimiter)
+
+	if !v.Valid() {
+		s.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	var rows []*importRow
+	var err error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		rows, err = readCSVImport(r.Body, delimiter)
+	case ndjsonContentType:
+		rows, err = readNDJSONImport(r.Body)
+	default:
+		s.errorResponse(w, r, http.StatusUnsupportedMediaType, "import must be a text/csv or "+ndjsonContentType+" body")
+		return
+	}
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			s.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("import must not be larger than %d bytes", maxImportSize))
+		default:
+			s.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	valid, updates, err := s.validateImport(rows, upsert)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+		return
+	}
+
+	report := importReport{DryRun: dryRun, Rows: len(rows), Errors: []importRowError{}}
+	for _, row := range rows {
+		if len(row.Errors) > 0 {
+			report.Errors = append(report.Errors, importRowError{Row: row.Row, Errors: row.Errors})
+		}
+	}
+	report.Failed = len(report.Errors)
+
+	toys := make([]*data.Toy, len(valid))
+	for i, row := range valid {
+		toys[i] = row.Toy
+	}
+	report.Created, report.Updated = len(toys)-updates, updates
+
+	if !dryRun && len(toys) > 0 {
+		report.Created, report.Updated, err = s.toyRepository.Import(toys, upsert, audit.FromContext(r.Context()))
+		if err != nil {
+			var unknownTerms *data.UnknownTermsError
+			var unknownManufacturer *data.UnknownManufacturerError
+			var attributeError *data.AttributeError
+			switch {
+			case errors.As(err, &unknownTerms):
+				s.unknownTermsResponse(w, r, unknownTerms)
+			case errors.As(err, &unknownManufacturer):
+				s.unknownManufacturerResponse(w, r)
+			case errors.As(err, &attributeError):
+				s.failedValidationResponse(w, r, attributeError.Errors)
+			case errors.Is(err, data.ErrDuplicateSupplierSKU):
+				s.errorResponse(w, r, http.StatusConflict, "a toy with one of the supplier_sku values was created in the meantime, please try again")
+			case errors.Is(err, data.ErrTrashedSupplierSKU):
+				s.errorResponse(w, r, http.StatusConflict, "a toy with one of the supplier_sku values was deleted in the meantime, restore it or try again")
+			default:
+				s.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	err = s.helper.WriteJSON(w, http.StatusOK, envelope{"import": report}, nil)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+	}
+}
+
+// validateImport validates the rows which could be read, and returns the ones which can
+// be imported, along with how many of them will update an existing toy.
+func (s *toyService) validateImport(rows []*importRow, upsert bool) ([]*importRow, int, error) {
+	valid := []*importRow{}
+	seen := map[string]int{}
+
+	for _, row := range rows {
+		if len(row.Errors) > 0 {
+			continue
+		}
+
+		if row.Toy.Language == "" {
+			row.Toy.Language = data.DefaultSearchLanguage
+		}
+
+		v := validator.New()
+		data.ValidateToy(v, row.Toy)
+
+		// The same toy twice in one import is most likely a mistake in the file.
+		if sku := row.Toy.SupplierSKU; sku != "" {
+			if first, ok := seen[sku]; ok {
+				v.AddError("supplier_sku", fmt.Sprintf("duplicates the supplier_sku of row %d", first))
+			} else {
+				seen[sku] = row.Row
+			}
+		}
+
+		if !v.Valid() {
+			row.Errors = v.Errors
+			continue
+		}
+		valid = append(valid, row)
+	}
+
+	skus := make([]string, 0, len(seen))
+	for sku := range seen {
+		skus = append(skus, sku)
+	}
+
+	existing, trashed, err := s.toyRepository.ExistingSupplierSKUs(skus)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	unknownCategories, err := s.unknownTerms(valid, data.TaxonomyCategories, func(toy *data.Toy) []string { return toy.Categories })
+	if err != nil {
+		return nil, 0, err
+	}
+
+	unknownSkills, err := s.unknownTerms(valid, data.TaxonomySkills, func(toy *data.Toy) []string { return toy.Skills })
+	if err != nil {
+		return nil, 0, err
+	}
+
+	names := make([]string, len(valid))
+	for i, row := range valid {
+		names[i] = row.Toy.Manufacturer
+	}
+
+	unknownManufacturers, err := s.toyRepository.UnknownManufacturers(names)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	schemas, err := s.attributeSchemas(valid)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	importable := []*importRow{}
+	updates := 0
+	for _, row := range valid {
+		errs := map[string]string{}
+		if validator.PermittedValue(row.Toy.Manufacturer, unknownManufacturers...) {
+			errs["manufacturer"] = "must be the name of a known manufacturer"
+		}
+		if values := unknownIn(row.Toy.Categories, unknownCategories); len(values) > 0 {
+			errs["categories"] = "must only contain values from the taxonomy, unknown: " + strings.Join(values, ", ")
+		}
+		if values := unknownIn(row.Toy.Skills, unknownSkills); len(values) > 0 {
+			errs["skills"] = "must only contain values from the taxonomy, unknown: " + strings.Join(values, ", ")
+		}
+		if len(row.Toy.Attributes) > 0 && len(errs) == 0 {
+			schema := data.AttributeSchema{}
+			for _, category := range row.Toy.Categories {
+				schema = schema.Merge(schemas[data.Slugify(category)])
+			}
+
+			v := validator.New()
+			if data.ValidateAttributes(v, schema, row.Toy.Attributes); !v.Valid() {
+				errs = v.Errors
+			}
+		}
+		if len(errs) > 0 {
+			row.Errors = errs
+			continue
+		}
+
+		// A deleted toy keeps its SKU, so that it can be restored as it was.
+		if trashed[row.Toy.SupplierSKU] {
+			row.Errors = map[string]string{"supplier_sku": "belongs to a deleted toy, which must be restored first"}
+			continue
+		}
+		if existing[row.Toy.SupplierSKU] {
+			if !upsert {
+				row.Errors = map[string]string{"supplier_sku": "a toy with this supplier_sku already exists"}
+				continue
+			}
+			updates++
+		}
+		importable = append(importable, row)
+	}
+
+	return importable, updates, nil
+}
+
+// unknownTerms looks up the values of all rows at once, and returns the ones which
+// aren't in the taxonomy.
+func (s *toyService) unknownTerms(rows []*importRow, kind data.TaxonomyKind, values func(toy *data.Toy) []string) (map[string]bool, error) {
+	all := []string{}
+	for _, row := range rows {
+		all = append(all, values(row.Toy)...)
+	}
+
+	unknown, err := s.toyRepository.UnknownTerms(kind, all)
+	if err != nil {
+		return nil, err
+	}
+
+	set := map[string]bool{}
+	for _, value := range unknown {
+		set[value] = true
+	}
+	return set, nil
+}
+
+// attributeSchemas looks up the schemas of the categories of all rows with attributes
+// at once, keyed by slug.
+func (s *toyService) attributeSchemas(rows []*importRow) (map[string]data.AttributeSchema, error) {
+	categories := []string{}
+	for _, row := range rows {
+		if len(row.Toy.Attributes) > 0 {
+			categories = append(categories, row.Toy.Categories...)
+		}
+	}
+	if len(categories) == 0 {
+		return map[string]data.AttributeSchema{}, nil
+	}
+
+	return s.toyRepository.AttributeSchemas(categories)
+}
+
+func unknownIn(values []string, unknown map[string]bool) []string {
+	found := []string{}
+	for _, value := range values {
+		if unknown[value] {
+			found = append(found, value)
+		}
+	}
+	return found
+}
+
+// validateDelimiter checks the delimiter of the lists in a CSV import or export. The
+// backslash is taken, since it escapes the delimiter within the values.
+func validateDelimiter(v *validator.Validator, delimiter string) {
+	v.Check(delimiter != "", "delimiter", "must not be empty")
+	v.Check(!strings.Contains(delimiter, `\`), "delimiter", "must not contain a backslash")
+}
+
+// readCSVImport reads a CSV file with a header row naming the importColumns it has. The
+// lists are joined with the delimiter, see splitList().
+func readCSVImport(body io.Reader, delimiter string) ([]*importRow, error) {
+	cr := csv.NewReader(body)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("import must not be empty")
+		}
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !validator.PermittedValue(name, importColumns...) {
+			return nil, fmt.Errorf("import contains unknown column %q", name)
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("import contains column %q more than once", name)
+		}
+		columns[name] = i
+	}
+
+	rows := []*importRow{}
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		// A record with the wrong number of fields spoils only its own row, anything
+		// else means the file can't be read any further.
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return nil, err
+		}
+
+		line, _ := cr.FieldPos(0)
+		row := &importRow{Row: line}
+
+		if err != nil {
+			row.Errors = map[string]string{"row": fmt.Sprintf("must have %d fields", len(header))}
+		} else {
+			row.Toy, row.Errors = csvImportToy(record, columns, delimiter)
+		}
+
+		rows = append(rows, row)
+		if len(rows) > maxImportRows {
+			return nil, fmt.Errorf("import must not have more than %d rows", maxImportRows)
+		}
+	}
+
+	return rows, nil
+}
+
+// splitList splits a list joined by joinList(), leaving out the empty values. A
+// backslash takes the character after it, or the delimiter, literally.
+func splitList(s, delimiter string) []string {
+	values := []string{}
+	var value strings.Builder
+
+	add := func() {
+		if v := strings.TrimSpace(value.String()); v != "" {
+			values = append(values, v)
+		}
+		value.Reset()
+	}
+
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '\\' && strings.HasPrefix(s[i+1:], delimiter):
+			value.WriteString(delimiter)
+			i += 1 + len(delimiter)
+		case s[i] == '\\' && i+1 < len(s):
+			value.WriteByte(s[i+1])
+			i += 2
+		case strings.HasPrefix(s[i:], delimiter):
+			add()
+			i += len(delimiter)
+		default:
+			value.WriteByte(s[i])
+			i++
+		}
+	}
+	add()
+
+	return values
+}
+
+func csvImportToy(record []string, columns map[string]int, delimiter string) (*data.Toy, map[string]string) {
+	// The quote which an export puts in front of a would-be formula is dropped.
+	field := func(name string) string {
+		if i, ok := columns[name]; ok {
+			value := strings.TrimSpace(record[i])
+			if len(value) > 1 && value[0] == '\'' && strings.ContainsRune(csvFormulaPrefixes, rune(value[1])) {
+				value = value[1:]
+			}
+			return value
+		}
+		return ""
+	}
+
+	list := func(name string) []string {
+		return splitList(field(name), delimiter)
+	}
+
+	toy := &data.Toy{
+		SupplierSKU:    field("supplier_sku"),
+		Title:          field("title"),
+		Description:    field("desc"),
+		Details:        list("details"),
+		Skills:         list("skills"),
+		Categories:     list("categories"),
+		Images:         list("images"),
+		RecommendedAge: field("recommended_age"),
+		Manufacturer:   field("manufacturer"),
+		IsAvailable:    true,
+		Language:       field("language"),
+	}
+
+	errs := map[string]string{}
+
+	if value := field("value"); value != "" {
+		var err error
+		toy.Value, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			errs["value"] = "must be an integer value"
+		}
+	}
+
+	if value := field("is_available"); value != "" {
+		var err error
+		toy.IsAvailable, err = strconv.ParseBool(value)
+		if err != nil {
+			errs["is_available"] = "must be a boolean value"
+		}
+	}
+
+	if value := field("attributes"); value != "" {
+		err := json.Unmarshal([]byte(value), &toy.Attributes)
+		if err != nil {
+			errs["attributes"] = "must be a JSON object"
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return toy, nil
+}
+
+// readNDJSONImport reads one JSON object per line. Empty lines are skipped.
+func readNDJSONImport(body io.Reader) ([]*importRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+
+	rows := []*importRow{}
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		row := &importRow{Row: line}
+		row.Toy, row.Errors = ndjsonImportToy(scanner.Bytes())
+
+		rows = append(rows, row)
+		if len(rows) > maxImportRows {
+			return nil, fmt.Errorf("import must not have more than %d rows", maxImportRows)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, errors.New("import lines must not be longer than 1MB")
+		}
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+func ndjsonImportToy(line []byte) (*data.Toy, map[string]string) {
+	var input struct {
+		SupplierSKU    string          `json:"supplier_sku"`
+		Title          string          `json:"title"`
+		Description    string          `json:"desc"`
+		Details        []string        `json:"details"`
+		Skills         []string        `json:"skills"`
+		Categories     []string        `json:"categories"`
+		Images         []string        `json:"images"`
+		RecommendedAge string          `json:"recommended_age"`
+		Manufacturer   string          `json:"manufacturer"`
+		Value          int64           `json:"value"`
+		IsAvailable    *bool           `json:"is_available"`
+		Language       string          `json:"language"`
+		Attributes     data.Attributes `json:"attributes"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&input)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+			return nil, map[string]string{unmarshalTypeError.Field: "has an incorrect JSON type"}
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return nil, map[string]string{"row": "contains unknown key " + strings.TrimPrefix(err.Error(), "json: unknown field ")}
+		default:
+			return nil, map[string]string{"row": "must be a single JSON object"}
+		}
+	}
+	if dec.More() {
+		return nil, map[string]string{"row": "must be a single JSON object"}
+	}
+
+	toy := &data.Toy{
+		SupplierSKU:    input.SupplierSKU,
+		Title:          input.Title,
+		Description:    input.Description,
+		Details:        input.Details,
+		Skills:         input.Skills,
+		Categories:     input.Categories,
+		Images:         input.Images,
+		RecommendedAge: input.RecommendedAge,
+		Manufacturer:   input.Manufacturer,
+		Value:          input.Value,
+		IsAvailable:    input.IsAvailable == nil || *input.IsAvailable,
+		Language:       input.Language,
+		Attributes:     input.Attributes,
+	}
+	return toy, nil
+}
@@ -0,0 +1,164 @@
+package serviceToy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/jsonpatch"
+)
+
+// readToyPatch applies the JSON Merge Patch or JSON Patch in the request body to the
+// JSON of the toy, as ShowToyHandler returns it. It returns false if it has sent an
+// error response instead.
+func (s *toyService) readToyPatch(w http.ResponseWriter, r *http.Request, toy *data.Toy, mediaType string) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.badRequestResponse(w, r, err)
+		return false
+	}
+
+	doc, err := toyPatchDocument(toy)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	var patched []byte
+	if mediaType == jsonpatch.MergePatchContentType {
+		patched, err = jsonpatch.MergePatch(doc, patch)
+	} else {
+		patched, err = jsonpatch.Patch(doc, patch)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			s.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			s.failedValidationResponse(w, r, map[string]string{"patch": err.Error()})
+		}
+		return false
+	}
+
+	if errs := checkToyPatchFields(doc, patched); len(errs) > 0 {
+		s.failedValidationResponse(w, r, errs)
+		return false
+	}
+
+	var result data.Toy
+
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&result)
+	if err != nil {
+		s.failedValidationResponse(w, r, map[string]string{"patch": "result is not a valid toy: " + err.Error()})
+		return false
+	}
+
+	// Like in a plain JSON body, a different version means that the patch was made for
+	// a toy which has changed since.
+	if result.Version != toy.Version {
+		s.editConflictResponse(w, r)
+		return false
+	}
+
+	*toy = result
+	return true
+}
+
+// toyPatchFields are the fields of the toy JSON, and whether a patch may change them.
+// The others are kept by the server: the rating comes from the reviews, the photos are
+// uploaded, the trash has its own endpoints, the supplier SKU is set by imports and the
+// availability and wait list follow the rentals of the toy.
+var toyPatchFields = map[string]bool{
+	"title":           true,
+	"desc":            true,
+	"details":         true,
+	"skills":          true,
+	"image":           true,
+	"categories":      true,
+	"recommended_age": true,
+	"manufacturer":    true,
+	"value":           true,
+	"language":        true,
+	"attributes":      true,
+	"version":         true,
+	"isAvailable":     false,
+	"waitList":        false,
+	"id":              false,
+	"created_at":      false,
+	"updated_at":      false,
+	"manufacturer_id": false,
+	"supplier_sku":    false,
+	"deleted_at":      false,
+	"rating":          false,
+	"rating_count":    false,
+	"photos":          false,
+	"highlight":       false,
+}
+
+// checkToyPatchFields compares the patched document with the original one, and returns
+// an error for every read-only field the patch changes and every unknown field it adds.
+// Operations which leave a read-only field as it was, such as a "test", are fine.
+func checkToyPatchFields(doc, patched []byte) map[string]string {
+	var before, after map[string]any
+
+	if err := json.Unmarshal(doc, &before); err != nil {
+		return map[string]string{"patch": err.Error()}
+	}
+	if err := json.Unmarshal(patched, &after); err != nil {
+		return map[string]string{"patch": "result is not a valid toy: " + err.Error()}
+	}
+
+	// A field removed by the patch is only in the original document.
+	keys := make(map[string]bool, len(before)+len(after))
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	errs := make(map[string]string)
+	for key := range keys {
+		writable, known := toyPatchFields[key]
+		switch {
+		case !known:
+			errs[key] = "is not a field of the toy"
+		case !writable && !reflect.DeepEqual(before[key], after[key]):
+			errs[key] = "cannot be changed"
+		}
+	}
+	return errs
+}
+
+// toyPatchDocument returns the JSON of the toy with all its arrays present, even the
+// empty ones, so that a JSON Patch can add to them with a path like "/image/-".
+func toyPatchDocument(toy *data.Toy) ([]byte, error) {
+	js, err := json.Marshal(toy)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]any
+	err = json.Unmarshal(js, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range []string{"details", "skills", "image", "categories", "waitList"} {
+		if doc[key] == nil {
+			doc[key] = []any{}
+		}
+	}
+	if doc["attributes"] == nil {
+		doc["attributes"] = map[string]any{}
+	}
+
+	return json.Marshal(doc)
+}
@@ -1,58 +1,196 @@
 package serviceToy
 
 import (
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 	"toy-rental-system/helpers"
+	"toy-rental-system/internal/audit"
 	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/jsonpatch"
+	"toy-rental-system/internal/storage"
 	"toy-rental-system/internal/validator"
+	"toy-rental-system/pkg/jsonlog"
 )
 
 type ToyService interface {
 	CreateToyHandler(w http.ResponseWriter, r *http.Request)
 	ShowToyHandler(w http.ResponseWriter, r *http.Request)
 	ListToysHandler(w http.ResponseWriter, r *http.Request)
+	SuggestToysHandler(w http.ResponseWriter, r *http.Request)
 	UpdateToyHandler(w http.ResponseWriter, r *http.Request)
 	DeleteToyHandler(w http.ResponseWriter, r *http.Request)
+	UploadToyImageHandler(w http.ResponseWriter, r *http.Request)
+	ListToyImagesHandler(w http.ResponseWriter, r *http.Request)
+	ArrangeToyImagesHandler(w http.ResponseWriter, r *http.Request)
+	DeleteToyImageHandler(w http.ResponseWriter, r *http.Request)
+	ImportToysHandler(w http.ResponseWriter, r *http.Request)
+	ExportToysHandler(w http.ResponseWriter, r *http.Request)
+	RestoreToyHandler(w http.ResponseWriter, r *http.Request)
+	ListDeletedToysHandler(w http.ResponseWriter, r *http.Request)
 }
 
 type toyService struct {
 	toyRepository data.ToyRepository
+	images        data.ToyImageRepository
+	storage       storage.Storage
+	maxImageSize  int64
 	helper        helpers.Helpers
+	logger        *jsonlog.Logger
 }
 
 func (s *toyService) ListToysHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title          string
-		Skills         []string
-		Categories     []string
-		RecommendedAge string
+		data.ToyFilter
+		Facets []string
 		data.Filters
 	}
 
 	v := validator.New()
 
 	qs := r.URL.Query()
-	input.Title = s.helper.ReadString(qs, "title", "")
-	input.Skills = s.helper.ReadCSV(qs, "skills", []string{})
-	input.Categories = s.helper.ReadCSV(qs, "categories", []string{})
-	input.Categories = s.helper.ReadCSV(qs, "recAge", []string{})
+	input.ToyFilter = s.readToyFilter(qs, v)
 	input.Page = s.helper.ReadInt(qs, "page", 1, v)
 	input.PageSize = s.helper.ReadInt(qs, "page_size", 24, v)
 	input.Sort = s.helper.ReadString(qs, "sort", "id")
-	input.SortSafeList = []string{"title", "skills", "categories", "-title", "-skills", "-categories"}
+	input.SortSafeList = []string{"id", "title", "skills", "categories", "relevance", "rating", "-id", "-title", "-skills", "-categories", "-rating"}
+	input.Facets = s.helper.ReadCSV(qs, "facets", []string{})
+
+	// Any cursor parameter, even an empty one for the first page, switches the listing
+	// to cursor pagination.
+	_, input.UseCursor = qs["cursor"]
+	input.Cursor = s.helper.ReadString(qs, "cursor", "")
+	input.WithTotal = s.helper.ReadBool(qs, "include_total", false, v)
+
+	for _, facet := range input.Facets {
+		v.Check(validator.PermittedValue(facet, data.FacetNames...), "facets", "invalid facet value")
+	}
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		s.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	toys, metadata, err := s.toyRepository.GetAll(input.Title, input.Skills, input.Categories, input.RecommendedAge, input.Filters)
+	toys, metadata, err := s.toyRepository.GetAll(input.ToyFilter, input.Filters)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"toys": toys, "metadata": metadata}
+
+	// Facets are only computed on request, since they need an extra query.
+	if len(input.Facets) > 0 {
+		facets, err := s.toyRepository.GetFacets(input.ToyFilter, input.Facets)
+		if err != nil {
+			s.serverErrorResponse(w, r, err)
+			return
+		}
+		env["facets"] = facets
+	}
+
+	// When a search finds nothing, it's most likely misspelled. Offer a corrected term,
+	// if there is one.
+	if input.Title != "" && len(toys) == 0 {
+		suggestion, err := s.toyRepository.DidYouMean(input.Title)
+		if err != nil {
+			s.serverErrorResponse(w, r, err)
+			return
+		}
+		if suggestion != "" {
+			env["did_you_mean"] = suggestion
+		}
+	}
+
+	etag, err := listETag(env)
 	if err != nil {
+		s.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The newest change of a listed toy is sent as Last-Modified, but it's not used for
+	// If-Modified-Since, since it doesn't change when a toy leaves the listing.
+	var lastModified time.Time
+	for _, toy := range toys {
+		if toy.UpdatedAt.After(lastModified) {
+			lastModified = toy.UpdatedAt
+		}
+	}
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(w, r, etag, time.Time{}) {
+		return
+	}
+
+	err = s.helper.WriteJSON(w, http.StatusOK, env, nil)
+
+}
+
+// readToyFilter reads and validates the filters of the toy listing, which the export
+// accepts as well.
+func (s *toyService) readToyFilter(qs url.Values, v *validator.Validator) data.ToyFilter {
+	filter := data.ToyFilter{
+		Title:             s.helper.ReadString(qs, "title", ""),
+		Skills:            s.helper.ReadCSV(qs, "skills", []string{}),
+		Categories:        s.helper.ReadCSV(qs, "categories", []string{}),
+		ExcludeCategories: s.helper.ReadCSV(qs, "exclude_categories", []string{}),
+		RecommendedAge:    s.helper.ReadString(qs, "recAge", ""),
+		Manufacturer:      s.helper.ReadString(qs, "manufacturer", ""),
+		ManufacturerID:    int64(s.helper.ReadInt(qs, "manufacturer_id", 0, v)),
+		MinValue:          int64(s.helper.ReadInt(qs, "min_value", 0, v)),
+		MaxValue:          int64(s.helper.ReadInt(qs, "max_value", 0, v)),
+		AvailableOnly:     s.helper.ReadBool(qs, "available", false, v),
+		CreatedAfter:      s.helper.ReadTime(qs, "created_after", v),
+	}
+
+	// Attribute filters look like attr.piece_count=lt:100 or attr.battery_type=none.
+	attributes := map[string]string{}
+	for key := range qs {
+		if name, ok := strings.CutPrefix(key, "attr."); ok {
+			attributes[name] = qs.Get(key)
+		}
+	}
+	filter.Attributes = data.ParseAttributeFilters(attributes)
+
+	data.ValidateToyFilter(v, filter)
+	return filter
+}
+
+// SuggestToysHandler returns completions for a partly typed search term, for the
+// autocomplete of the search box.
+func (s *toyService) SuggestToysHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+	q := s.helper.ReadString(qs, "q", "")
+	limit := s.helper.ReadInt(qs, "limit", 8, v)
+
+	v.Check(q != "", "q", "must be provided")
+	v.Check(len(q) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be more than zero")
+	v.Check(limit <= 20, "limit", "must be a maximum of 20")
+
+	if !v.Valid() {
+		s.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = s.helper.WriteJSON(w, http.StatusOK, envelope{"toys": toys, "metadata": metadata}, nil)
+	suggestions, err := s.toyRepository.Suggest(q, limit)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+		return
+	}
 
+	err = s.helper.WriteJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+	}
 }
 
 func (s *toyService) UpdateToyHandler(w http.ResponseWriter, r *http.Request) {
@@ -63,25 +201,102 @@ func (s *toyService) UpdateToyHandler(w http.ResponseWriter, r *http.Request) {
 
 	toy, err := s.toyRepository.Get(id)
 	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			s.notFoundResponse(w, r)
+		default:
+			s.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	var input struct {
-		Title          *string   `json:"title"`
-		Description    *string   `json:"desc"`
-		Details        *[]string `json:"details"`
-		Skills         *[]string `json:"skills"`
-		Categories     *[]string `json:"categories"`
-		RecommendedAge *string   `json:"recommendedAge"`
-		Manufacturer   *string   `json:"manufacturer"`
-		Value          *int64    `json:"value"`
+	if s.preconditionFailed(w, r, toy) {
+		return
+	}
+
+	// Patch documents are applied to the toy as a whole, while a plain JSON body only
+	// replaces the fields it contains.
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case jsonpatch.MergePatchContentType, jsonpatch.PatchContentType:
+		if !s.readToyPatch(w, r, toy, mediaType) {
+			return
+		}
+	default:
+		if !s.readToyUpdate(w, r, toy) {
+			return
+		}
 	}
 
-	err = s.helper.ReadJSON(w, r, &input)
+	v := validator.New()
+	if data.ValidateToy(v, toy); !v.Valid() {
+		s.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = s.toyRepository.UpdateAudited(toy, audit.FromContext(r.Context()))
+	if err != nil {
+		var unknownTerms *data.UnknownTermsError
+		var unknownManufacturer *data.UnknownManufacturerError
+		var attributeError *data.AttributeError
+		switch {
+		case errors.As(err, &unknownTerms):
+			s.unknownTermsResponse(w, r, unknownTerms)
+		case errors.As(err, &unknownManufacturer):
+			s.unknownManufacturerResponse(w, r)
+		case errors.As(err, &attributeError):
+			s.failedValidationResponse(w, r, attributeError.Errors)
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			s.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			s.editConflictResponse(w, r)
+		default:
+			s.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", toyETag(toy))
+
+	err = s.helper.WriteJSON(w, http.StatusOK, envelope{"toy": toy}, headers)
 	if err != nil {
 		return
 	}
 
+}
+
+// readToyUpdate reads a JSON body with the fields of the toy to change. It returns false
+// if it has sent an error response instead.
+func (s *toyService) readToyUpdate(w http.ResponseWriter, r *http.Request, toy *data.Toy) bool {
+	// The version is optional for backwards compatibility, but clients which send it
+	// are protected against overwriting changes they haven't seen. So are clients which
+	// send an If-Match header.
+	var input struct {
+		Version        *int32           `json:"version"`
+		Title          *string          `json:"title"`
+		Description    *string          `json:"desc"`
+		Details        *[]string        `json:"details"`
+		Skills         *[]string        `json:"skills"`
+		Categories     *[]string        `json:"categories"`
+		RecommendedAge *string          `json:"recommendedAge"`
+		Manufacturer   *string          `json:"manufacturer"`
+		Value          *int64           `json:"value"`
+		Language       *string          `json:"language"`
+		Attributes     *data.Attributes `json:"attributes"`
+	}
+
+	err := s.helper.ReadJSON(w, r, &input)
+	if err != nil {
+		s.badRequestResponse(w, r, err)
+		return false
+	}
+
+	if input.Version != nil && *input.Version != toy.Version {
+		s.editConflictResponse(w, r)
+		return false
+	}
+
 	if input.Title != nil {
 		toy.Title = *input.Title
 	}
@@ -106,22 +321,14 @@ func (s *toyService) UpdateToyHandler(w http.ResponseWriter, r *http.Request) {
 	if input.Value != nil {
 		toy.Value = *input.Value
 	}
-
-	v := validator.New()
-	if data.ValidateToy(v, toy); !v.Valid() {
-		return
+	if input.Language != nil {
+		toy.Language = *input.Language
 	}
-
-	err = s.toyRepository.Update(toy)
-	if err != nil {
-		return
-	}
-
-	err = s.helper.WriteJSON(w, http.StatusOK, envelope{"toy": toy}, nil)
-	if err != nil {
-		return
+	if input.Attributes != nil {
+		toy.Attributes = *input.Attributes
 	}
 
+	return true
 }
 
 func (s *toyService) DeleteToyHandler(w http.ResponseWriter, r *http.Request) {
@@ -130,8 +337,38 @@ func (s *toyService) DeleteToyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.toyRepository.Delete(id)
+	// With an If-Match header, the toy is only deleted if it's still the version the
+	// client has seen.
+	var version int32
+	if r.Header.Get("If-Match") != "" {
+		toy, err := s.toyRepository.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				s.notFoundResponse(w, r)
+			default:
+				s.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if s.preconditionFailed(w, r, toy) {
+			return
+		}
+		version = toy.Version
+	}
+
+	// The toy only goes to the trash, so its images are kept until it's purged.
+	err = s.toyRepository.DeleteAudited(id, version, audit.FromContext(r.Context()))
 	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			s.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			s.preconditionFailedResponse(w, r)
+		default:
+			s.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -148,6 +385,22 @@ func (s *toyService) ShowToyHandler(w http.ResponseWriter, r *http.Request) {
 
 	toy, err := s.toyRepository.Get(id)
 	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			s.notFoundResponse(w, r)
+		default:
+			s.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	toy.Photos, err = s.toyImages(toy.ID)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if notModified(w, r, toyETag(toy), toy.UpdatedAt) {
 		return
 	}
 
@@ -157,9 +410,16 @@ func (s *toyService) ShowToyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func NewToyService(repo data.ToyRepository) ToyService {
+// NewToyService returns the toy handlers. Uploaded images are kept in the store, and
+// may be up to maxImageSize bytes large.
+func NewToyService(repo data.ToyRepository, images data.ToyImageRepository, store storage.Storage, maxImageSize int64, logger *jsonlog.Logger) ToyService {
 	return &toyService{
 		toyRepository: repo,
+		images:        images,
+		storage:       store,
+		maxImageSize:  maxImageSize,
+		helper:        helpers.New(),
+		logger:        logger,
 	}
 }
 
@@ -168,17 +428,19 @@ type envelope map[string]any
 func (s *toyService) CreateToyHandler(w http.ResponseWriter, r *http.Request) {
 
 	var inputToy struct {
-		Title          string   `json:"title"`
-		Description    string   `json:"desc"`
-		Details        []string `json:"details,omitempty"`
-		Skills         []string `json:"skills"`
-		Images         []string `json:"images"`
-		Categories     []string `json:"categories"`
-		RecommendedAge string   `json:"recommended_age"`
-		Manufacturer   string   `json:"manufacturer"`
-		Value          int64    `json:"value"`
-		IsAvailable    bool     `json:"is_available"`
-		WaitList       []string `json:"wait_list,omitempty"`
+		Title          string          `json:"title"`
+		Description    string          `json:"desc"`
+		Details        []string        `json:"details,omitempty"`
+		Skills         []string        `json:"skills"`
+		Images         []string        `json:"images"`
+		Categories     []string        `json:"categories"`
+		RecommendedAge string          `json:"recommended_age"`
+		Manufacturer   string          `json:"manufacturer"`
+		Value          int64           `json:"value"`
+		IsAvailable    bool            `json:"is_available"`
+		WaitList       []string        `json:"wait_list,omitempty"`
+		Language       string          `json:"language"`
+		Attributes     data.Attributes `json:"attributes"`
 	}
 
 	err := s.helper.ReadJSON(w, r, &inputToy)
@@ -199,16 +461,36 @@ func (s *toyService) CreateToyHandler(w http.ResponseWriter, r *http.Request) {
 		Value:          inputToy.Value,
 		IsAvailable:    inputToy.IsAvailable,
 		WaitList:       inputToy.WaitList,
+		Language:       inputToy.Language,
+		Attributes:     inputToy.Attributes,
+	}
+
+	if toy.Language == "" {
+		toy.Language = data.DefaultSearchLanguage
 	}
 
 	v := validator.New()
 
 	if data.ValidateToy(v, toy); !v.Valid() {
+		s.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = s.toyRepository.Insert(toy)
+	err = s.toyRepository.InsertAudited(toy, audit.FromContext(r.Context()))
 	if err != nil {
+		var unknownTerms *data.UnknownTermsError
+		var unknownManufacturer *data.UnknownManufacturerError
+		var attributeError *data.AttributeError
+		switch {
+		case errors.As(err, &unknownTerms):
+			s.unknownTermsResponse(w, r, unknownTerms)
+		case errors.As(err, &unknownManufacturer):
+			s.unknownManufacturerResponse(w, r)
+		case errors.As(err, &attributeError):
+			s.failedValidationResponse(w, r, attributeError.Errors)
+		default:
+			s.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -0,0 +1,66 @@
+package serviceToy
+
+import (
+	"errors"
+	"net/http"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/validator"
+)
+
+// RestoreToyHandler takes a deleted toy out of the trash, as long as it hasn't been
+// purged yet.
+func (s *toyService) RestoreToyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := s.helper.ReadIdParam(r)
+	if err != nil {
+		return
+	}
+
+	toy, err := s.toyRepository.RestoreAudited(id, audit.FromContext(r.Context()))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			s.notFoundResponse(w, r)
+		default:
+			s.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", toyETag(toy))
+
+	err = s.helper.WriteJSON(w, http.StatusOK, envelope{"toy": toy}, headers)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+	}
+}
+
+// ListDeletedToysHandler lists the toys in the trash, the most recently deleted first.
+func (s *toyService) ListDeletedToysHandler(w http.ResponseWriter, r *http.Request) {
+	var input data.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+	input.Page = s.helper.ReadInt(qs, "page", 1, v)
+	input.PageSize = s.helper.ReadInt(qs, "page_size", 24, v)
+	input.Sort = s.helper.ReadString(qs, "sort", "-deleted_at")
+	input.SortSafeList = []string{"-deleted_at"}
+
+	if data.ValidateFilters(v, input); !v.Valid() {
+		s.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	toys, metadata, err := s.toyRepository.GetDeleted(input)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = s.helper.WriteJSON(w, http.StatusOK, envelope{"toys": toys, "metadata": metadata}, nil)
+	if err != nil {
+		s.serverErrorResponse(w, r, err)
+	}
+}
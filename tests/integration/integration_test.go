@@ -61,8 +61,6 @@ func (suite *IntegrationTestSuite) SetupSuite() {
 	subscriptionService := service.NewSubscriptionService(cfg, subscriptionRepo)
 	userService := service.NewUserService(userRepo)
 
-	subscriptionHandler := handler.NewSubscriptionHandler(subscriptionService)
-
 	muxRouter := mux.NewRouter()
 	handler.NewUserHandler(muxRouter, userService)
 
@@ -0,0 +1,33 @@
+package unit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/validator"
+)
+
+func TestValidateAPIKey(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+
+	v := validator.New()
+	data.ValidateAPIKey(v, &data.APIKey{
+		Name:           "Sunny Daycare",
+		Scopes:         []string{data.ScopeCatalogRead, "catalog:delete"},
+		RateLimitRPS:   5,
+		RateLimitBurst: 10,
+		ExpiresAt:      &past,
+	})
+
+	assert.Contains(t, v.Errors, "scopes")
+	assert.Contains(t, v.Errors, "expires_at")
+	assert.NotContains(t, v.Errors, "name")
+}
+
+func TestAPIKeyHasScope(t *testing.T) {
+	key := &data.APIKey{Scopes: []string{data.ScopeCatalogRead}}
+
+	assert.True(t, key.HasScope(data.ScopeCatalogRead))
+	assert.False(t, key.HasScope(data.ScopeCatalogWrite))
+}
@@ -0,0 +1,177 @@
+package unit
+
+import (
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/validator"
+)
+
+func pieceCountSchema() data.AttributeSchema {
+	one := 1.0
+	return data.AttributeSchema{
+		"piece_count":  {Type: data.AttributeInteger, Min: &one},
+		"battery_type": {Type: data.AttributeEnum, Values: []string{"none", "AA", "AAA"}},
+		"materials":    {Type: data.AttributeList, Values: []string{"wood", "plastic"}},
+	}
+}
+
+func TestValidateAttributeSchema(t *testing.T) {
+	v := validator.New()
+	data.ValidateAttributeSchema(v, pieceCountSchema())
+	assert.True(t, v.Valid())
+
+	max := 0.0
+	v = validator.New()
+	data.ValidateAttributeSchema(v, data.AttributeSchema{
+		"Piece Count":  {Type: data.AttributeInteger},
+		"battery_type": {Type: data.AttributeEnum},
+		"weight_g":     {Type: data.AttributeString, Max: &max},
+		"colour":       {Type: "colour"},
+	})
+	assert.Contains(t, v.Errors, "attributes.Piece Count")
+	assert.Contains(t, v.Errors, "attributes.battery_type")
+	assert.Contains(t, v.Errors, "attributes.weight_g")
+	assert.Contains(t, v.Errors, "attributes.colour")
+}
+
+func TestValidateAttributes(t *testing.T) {
+	v := validator.New()
+	data.ValidateAttributes(v, pieceCountSchema(), data.Attributes{"piece_count": 500.0, "battery_type": "none", "materials": []any{"wood"}})
+	assert.True(t, v.Valid())
+
+	v = validator.New()
+	data.ValidateAttributes(v, pieceCountSchema(), data.Attributes{
+		"piece_count":  2.5,
+		"battery_type": "D",
+		"materials":    []any{"wood", "wood"},
+		"noise_level":  "loud",
+	})
+	assert.Contains(t, v.Errors, "attributes.piece_count")
+	assert.Contains(t, v.Errors, "attributes.battery_type")
+	assert.Contains(t, v.Errors, "attributes.materials")
+	assert.Contains(t, v.Errors, "attributes.noise_level")
+}
+
+func TestParseAttributeFilters(t *testing.T) {
+	filters := data.ParseAttributeFilters(map[string]string{
+		"piece_count":  "lt:100",
+		"battery_type": "none",
+		"rechargeable": "ne:true",
+	})
+
+	assert.Equal(t, []data.AttributeFilter{
+		{Name: "battery_type", Op: "eq", Value: "none"},
+		{Name: "piece_count", Op: "lt", Value: 100.0},
+		{Name: "rechargeable", Op: "ne", Value: true},
+	}, filters)
+}
+
+func TestValidateToyFilterAttributes(t *testing.T) {
+	v := validator.New()
+	data.ValidateToyFilter(v, data.ToyFilter{Attributes: data.ParseAttributeFilters(map[string]string{"piece_count": "lt:many", "$.x": "1"})})
+	assert.Contains(t, v.Errors, "attr.piece_count")
+	assert.Contains(t, v.Errors, "attr.$.x")
+}
+
+func TestGetAllByAttributes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`WHERE deleted_at IS NULL AND NOT attributes @\? \$1::jsonpath AND attributes @\? \$2::jsonpath ORDER BY`).
+		WithArgs(`$."battery_type" ? (@ == "AA")`, `$."piece_count" ? (@ < 100)`, 24, 0).
+		WillReturnRows(sqlmock.NewRows(toyListColumns))
+
+	m := data.ToyModel{DB: db}
+	filters := data.Filters{Page: 1, PageSize: 24, Sort: "id", SortSafeList: []string{"id"}}
+	filter := data.ToyFilter{Attributes: data.ParseAttributeFilters(map[string]string{"battery_type": "ne:AA", "piece_count": "lt:100"})}
+
+	_, _, err = m.GetAll(filter, filters)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInsertToyWithInvalidAttributes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	schema, err := json.Marshal(pieceCountSchema())
+	assert.NoError(t, err)
+
+	expectTermLookup(mock, "categories", "stem", "STEM")
+	expectTermLookup(mock, "skills", "motor", "motor")
+	mock.ExpectQuery(`WITH RECURSIVE tree AS .+ SELECT category_id, attribute_schema FROM tree ORDER BY category_id, depth DESC`).
+		WillReturnRows(sqlmock.NewRows([]string{"category_id", "attribute_schema"}).AddRow(1, schema))
+
+	m := data.ToyModel{DB: db}
+	toy := versionedToy(1)
+	toy.Attributes = data.Attributes{"piece_count": 0.0}
+
+	err = m.Insert(toy)
+
+	var attributeError *data.AttributeError
+	assert.ErrorAs(t, err, &attributeError)
+	assert.Contains(t, attributeError.Errors, "attributes.piece_count")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateToyWithInvalidAttributes(t *testing.T) {
+	service := newTestToyService(t)
+	service.toys.schemas = map[string]data.AttributeSchema{"stem": pieceCountSchema()}
+
+	w := httptest.NewRecorder()
+	service.UpdateToyHandler(w, toyRequest(http.MethodPatch, `{"attributes": {"battery_type": "D"}}`, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "attributes.battery_type")
+
+	w = httptest.NewRecorder()
+	service.UpdateToyHandler(w, toyRequest(http.MethodPatch, `{"attributes": {"battery_type": "none", "piece_count": 250}}`, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, data.Attributes{"battery_type": "none", "piece_count": 250.0}, service.toys.toys[1].Attributes)
+}
+
+func TestImportToysWithAttributes(t *testing.T) {
+	service := newTestToyService(t)
+	service.toys.schemas = map[string]data.AttributeSchema{"stem": pieceCountSchema()}
+
+	body := `{"supplier_sku": "LEGO-1", "title": "Lego Technic", "skills": ["motor"], "categories": ["STEM"], "recommended_age": "8+", "manufacturer": "Lego", "value": 9000, "attributes": {"piece_count": 420}}
+{"supplier_sku": "LEGO-2", "title": "Lego City", "skills": ["motor"], "categories": ["STEM"], "recommended_age": "5+", "manufacturer": "Lego", "value": 9000, "attributes": {"piece_count": "many"}}
+`
+
+	code, response := importRequest(t, service, "?dry_run=true", "application/x-ndjson", body)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, response.Import.Created)
+	assert.Equal(t, 1, response.Import.Failed)
+	assert.Equal(t, 2, response.Import.Errors[0].Row)
+	assert.Contains(t, response.Import.Errors[0].Errors, "attributes.piece_count")
+}
+
+func TestSetAttributeSchemaAudited(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT attribute_schema FROM categories WHERE id = \$1 FOR UPDATE`).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"attribute_schema"}).AddRow([]byte(`{}`)))
+	mock.ExpectExec(`UPDATE categories SET attribute_schema = \$1, version = version \+ 1 WHERE id = \$2`).
+		WithArgs(sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(sqlmock.AnyArg(), nil, "category.attributes", "category", "3", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	m := data.AttributeSchemaModel{DB: db}
+
+	assert.NoError(t, m.SetAudited(3, pieceCountSchema(), audit.Actor{UserID: 2}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
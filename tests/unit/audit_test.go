@@ -0,0 +1,50 @@
+package unit
+
+import (
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+)
+
+func TestAuditEventChanges(t *testing.T) {
+	before := &data.Toy{ID: 7, Title: "Lego", Value: 5000, Skills: []string{"motor"}}
+	after := &data.Toy{ID: 7, Title: "Lego Duplo", Value: 5000, Skills: []string{"motor"}}
+
+	e, err := audit.NewEvent(audit.Actor{UserID: 3, IP: "10.0.0.1"}, "toy.update", "toy", "7", before, after)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), *e.ActorUserID)
+	assert.Nil(t, e.ActorAPIKeyID)
+
+	var changes map[string]map[string]any
+	assert.NoError(t, json.Unmarshal(e.Changes, &changes))
+	assert.Len(t, changes, 1)
+	assert.Equal(t, "Lego", changes["title"]["before"])
+	assert.Equal(t, "Lego Duplo", changes["title"]["after"])
+}
+
+func TestDeleteToyAudited(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	columns := []string{"id", "created_at", "title", "desc", "details", "skills", "categories", "images", "recommended_age", "manufacturer", "value", "is_available", "wait_list", "language", "version", "updated_at", "supplier_sku", "manufacturer_id", "attributes", "rating", "rating_count"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .+ FROM toys WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, time.Now(), "Lego", "", pq.StringArray{}, pq.StringArray{"motor"}, pq.StringArray{"STEM"}, pq.StringArray{}, "3+", "Lego", 5000, true, pq.StringArray{}, "english", 1, time.Now(), "", 1, []byte("{}"), 0, 0))
+	mock.ExpectExec(`UPDATE toys SET deleted_at = now\(\)`).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(sqlmock.AnyArg(), nil, "toy.delete", "toy", "1", sqlmock.AnyArg(), nil, sqlmock.AnyArg(), "10.0.0.1", "req-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	m := data.ToyModel{DB: db}
+	err = m.DeleteAudited(1, 0, audit.Actor{UserID: 2, IP: "10.0.0.1", RequestID: "req-1"})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
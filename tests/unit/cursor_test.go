@@ -0,0 +1,109 @@
+package unit
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/validator"
+)
+
+// cursorRows returns a listing row for each title, without the count(*) column which
+// the cursor queries don't select.
+func cursorRows(ids []int64, titles []string) *sqlmock.Rows {
+	rows := sqlmock.NewRows(toyListColumns[1:])
+	for i := range ids {
+		rows.AddRow(ids[i], time.Now(), titles[i], "", pq.StringArray{}, pq.StringArray{"motor"}, pq.StringArray{"vehicles"}, "3+", "Brio", 12000, true, pq.StringArray{}, "english", 1, time.Now(), []byte("{}"), 0, 0, 0, "", "")
+	}
+	return rows
+}
+
+func TestGetAllByCursorFirstPage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// One toy more than the page size tells that there is a next page.
+	mock.ExpectQuery(`SELECT id, .+ WHERE deleted_at IS NULL\s+ORDER BY title ASC, id ASC\s+LIMIT \$1`).
+		WithArgs(3).
+		WillReturnRows(cursorRows([]int64{4, 2, 9}, []string{"Abacus", "Ball", "Crane"}))
+
+	m := data.ToyModel{DB: db}
+	filters := data.Filters{Page: 1, PageSize: 2, Sort: "title", SortSafeList: data.CursorSortList, UseCursor: true}
+
+	toys, metadata, err := m.GetAll(data.ToyFilter{}, filters)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Len(t, toys, 2)
+	assert.NotEmpty(t, metadata.NextCursor)
+	assert.Empty(t, metadata.PrevCursor)
+	assert.Zero(t, metadata.TotalRecords)
+
+	// The next page continues after the last toy of this one.
+	mock.ExpectQuery(`WHERE deleted_at IS NULL\s+AND \(title, id\) > \(\$1, \$2\)\s+ORDER BY title ASC, id ASC\s+LIMIT \$3`).
+		WithArgs("Ball", 2, 3).
+		WillReturnRows(cursorRows([]int64{9}, []string{"Crane"}))
+
+	filters.Cursor = metadata.NextCursor
+	toys, metadata, err = m.GetAll(data.ToyFilter{}, filters)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Len(t, toys, 1)
+	assert.Empty(t, metadata.NextCursor)
+	assert.NotEmpty(t, metadata.PrevCursor)
+
+	// The previous page is fetched in reverse and handed back in the original order.
+	mock.ExpectQuery(`WHERE deleted_at IS NULL\s+AND \(title, id\) < \(\$1, \$2\)\s+ORDER BY title DESC, id DESC\s+LIMIT \$3`).
+		WithArgs("Crane", 9, 3).
+		WillReturnRows(cursorRows([]int64{2, 4}, []string{"Ball", "Abacus"}))
+
+	filters.Cursor = metadata.PrevCursor
+	toys, metadata, err = m.GetAll(data.ToyFilter{}, filters)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, int64(4), toys[0].ID)
+	assert.Equal(t, int64(2), toys[1].ID)
+	assert.NotEmpty(t, metadata.NextCursor)
+	assert.Empty(t, metadata.PrevCursor)
+}
+
+func TestGetAllByCursorCountsTotalOnRequest(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM toys WHERE deleted_at IS NULL\s+AND is_available`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+	mock.ExpectQuery(`WHERE deleted_at IS NULL\s+AND is_available\s+ORDER BY id DESC\s+LIMIT \$1`).
+		WithArgs(25).
+		WillReturnRows(cursorRows(nil, nil))
+
+	m := data.ToyModel{DB: db}
+	filters := data.Filters{Page: 1, PageSize: 24, Sort: "-id", SortSafeList: data.CursorSortList, UseCursor: true, WithTotal: true}
+
+	_, metadata, err := m.GetAll(data.ToyFilter{AvailableOnly: true}, filters)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 42, metadata.TotalRecords)
+}
+
+func TestValidateFiltersWithCursor(t *testing.T) {
+	safeList := []string{"id", "title", "skills"}
+
+	v := validator.New()
+	data.ValidateFilters(v, data.Filters{Page: 1, PageSize: 24, Sort: "skills", SortSafeList: safeList, UseCursor: true})
+	assert.Contains(t, v.Errors, "sort")
+
+	v = validator.New()
+	data.ValidateFilters(v, data.Filters{Page: 1, PageSize: 24, Sort: "id", SortSafeList: safeList, UseCursor: true, Cursor: "not a cursor"})
+	assert.Contains(t, v.Errors, "cursor")
+
+	v = validator.New()
+	data.ValidateFilters(v, data.Filters{Page: 3, PageSize: 24, Sort: "id", SortSafeList: safeList, UseCursor: true})
+	assert.Contains(t, v.Errors, "page")
+}
@@ -0,0 +1,47 @@
+package unit
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"toy-rental-system/internal/data"
+)
+
+func TestGetFacets(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Only the requested facets are part of the query.
+	mock.ExpectQuery(`WITH filtered AS .+ WHERE deleted_at IS NULL AND EXISTS \( SELECT 1 FROM toy_categories .+ WHERE slug = ANY\(\$1\) .+ unnest\(categories\) .+ UNION ALL .+ is_available`).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"facet", "value", "count"}).
+			AddRow("availability", "available", 15).
+			AddRow("categories", "Puzzles", 12).
+			AddRow("categories", "STEM", 8))
+
+	m := data.ToyModel{DB: db}
+
+	facets, err := m.GetFacets(data.ToyFilter{Categories: []string{"Puzzles"}}, []string{"categories", "availability"})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, []data.FacetValue{{Value: "Puzzles", Count: 12}, {Value: "STEM", Count: 8}}, facets["categories"])
+	assert.Equal(t, []data.FacetValue{{Value: "available", Count: 15}}, facets["availability"])
+	assert.NotContains(t, facets, "skills")
+}
+
+func TestGetFacetsWithoutMatches(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`WITH filtered AS`).
+		WillReturnRows(sqlmock.NewRows([]string{"facet", "value", "count"}))
+
+	m := data.ToyModel{DB: db}
+
+	facets, err := m.GetFacets(data.ToyFilter{Title: "nothing like this"}, []string{"manufacturer"})
+	assert.NoError(t, err)
+	assert.Equal(t, []data.FacetValue{}, facets["manufacturer"])
+}
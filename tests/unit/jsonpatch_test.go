@@ -0,0 +1,65 @@
+package unit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"toy-rental-system/internal/jsonpatch"
+)
+
+func TestMergePatch(t *testing.T) {
+	// The example from RFC 7396, section 3.
+	doc := `{"title": "Goodbye!", "author": {"givenName": "John", "familyName": "Doe"}, "tags": ["example", "sample"], "content": "This will be unchanged"}`
+	patch := `{"title": "Hello!", "phoneNumber": "+01-123-456-7890", "author": {"familyName": null}, "tags": ["example"]}`
+
+	result, err := jsonpatch.MergePatch([]byte(doc), []byte(patch))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"title": "Hello!", "author": {"givenName": "John"}, "tags": ["example"], "content": "This will be unchanged", "phoneNumber": "+01-123-456-7890"}`, string(result))
+}
+
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    string
+		patch  string
+		result string
+	}{
+		{"add member", `{"foo": "bar"}`, `[{"op": "add", "path": "/baz", "value": "qux"}]`, `{"foo": "bar", "baz": "qux"}`},
+		{"insert into array", `{"foo": ["bar", "baz"]}`, `[{"op": "add", "path": "/foo/1", "value": "qux"}]`, `{"foo": ["bar", "qux", "baz"]}`},
+		{"append to array", `{"foo": ["bar"]}`, `[{"op": "add", "path": "/foo/-", "value": "baz"}]`, `{"foo": ["bar", "baz"]}`},
+		{"remove from array", `{"foo": ["bar", "qux", "baz"]}`, `[{"op": "remove", "path": "/foo/1"}]`, `{"foo": ["bar", "baz"]}`},
+		{"replace", `{"baz": "qux", "foo": "bar"}`, `[{"op": "replace", "path": "/baz", "value": "boo"}]`, `{"baz": "boo", "foo": "bar"}`},
+		{"move", `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`, `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`, `{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`},
+		{"copy", `{"foo": [1, 2]}`, `[{"op": "copy", "from": "/foo", "path": "/bar"}]`, `{"foo": [1, 2], "bar": [1, 2]}`},
+		{"escaped pointer", `{"a/b": 1, "m~n": 2}`, `[{"op": "replace", "path": "/a~1b", "value": 3}, {"op": "remove", "path": "/m~0n"}]`, `{"a/b": 3}`},
+		{"test then add null", `{"foo": [1, "2"]}`, `[{"op": "test", "path": "/foo", "value": [1, "2"]}, {"op": "add", "path": "/bar", "value": null}]`, `{"foo": [1, "2"], "bar": null}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := jsonpatch.Patch([]byte(tt.doc), []byte(tt.patch))
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.result, string(result))
+		})
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	doc := []byte(`{"foo": ["bar"], "baz": "qux"}`)
+
+	_, err := jsonpatch.Patch(doc, []byte(`[{"op": "test", "path": "/baz", "value": "bar"}]`))
+	assert.ErrorIs(t, err, jsonpatch.ErrTestFailed)
+
+	for _, patch := range []string{
+		`{"op": "add", "path": "/x", "value": 1}`,
+		`[{"op": "remove", "path": "/missing"}]`,
+		`[{"op": "replace", "path": "/foo/1", "value": "x"}]`,
+		`[{"op": "add", "path": "/foo/01", "value": "x"}]`,
+		`[{"op": "add", "path": "/x"}]`,
+		`[{"op": "move", "from": "/foo", "path": "/foo/0"}]`,
+		`[{"op": "frobnicate", "path": "/foo"}]`,
+		`[{"op": "add", "path": "foo", "value": 1}]`,
+	} {
+		_, err := jsonpatch.Patch(doc, []byte(patch))
+		assert.Error(t, err, patch)
+	}
+}
@@ -0,0 +1,90 @@
+package unit
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"toy-rental-system/internal/mailer"
+)
+
+func TestMailerRendersTemplates(t *testing.T) {
+	sink := mailer.NewMemorySender()
+	m := mailer.New(sink, "Toy Rental <no-reply@toyrental.kz>")
+
+	err := m.Send("parent@example.com", "waitlist_available.tmpl", map[string]any{
+		"username": "aigerim",
+		"toyTitle": "Lego <Duplo>",
+		"toyID":    42,
+	})
+	assert.NoError(t, err)
+
+	messages := sink.Messages()
+	assert.Len(t, messages, 1)
+
+	msg := messages[0]
+	assert.Equal(t, "parent@example.com", msg.To)
+	assert.Equal(t, `"Lego <Duplo>" is available now`, msg.Subject)
+	assert.Contains(t, msg.PlainBody, "Lego <Duplo>")
+	assert.Contains(t, msg.HTMLBody, "Lego &lt;Duplo&gt;")
+
+	body, err := msg.Bytes()
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(body), "multipart/alternative"))
+}
+
+func TestMailerUnknownTemplate(t *testing.T) {
+	sink := mailer.NewMemorySender()
+	m := mailer.New(sink, "no-reply@toyrental.kz")
+
+	err := m.Send("parent@example.com", "missing.tmpl", nil)
+	assert.Error(t, err)
+	assert.Empty(t, sink.Messages())
+}
+
+type failingSender struct {
+	attempts int
+}
+
+func (s *failingSender) Deliver(msg *mailer.Message) error {
+	s.attempts++
+	return errors.New("connection refused")
+}
+
+func TestMailerRetries(t *testing.T) {
+	sender := &failingSender{}
+	m := mailer.New(sender, "no-reply@toyrental.kz")
+	m.Backoff = 0
+
+	err := m.Send("parent@example.com", "user_welcome.tmpl", map[string]any{"username": "aigerim", "userID": 1})
+	assert.Error(t, err)
+	assert.Equal(t, 3, sender.attempts)
+}
+
+func TestMessageRejectsHeaderInjection(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  mailer.Message
+	}{
+		{name: "recipient", msg: mailer.Message{From: "no-reply@toyrental.kz", To: "parent@example.com\r\nBcc: victim@example.com", Subject: "Hi"}},
+		{name: "sender", msg: mailer.Message{From: "Toy Rental\n <no-reply@toyrental.kz>", To: "parent@example.com", Subject: "Hi"}},
+		{name: "subject", msg: mailer.Message{From: "no-reply@toyrental.kz", To: "parent@example.com", Subject: "Hi\r\nBcc: victim@example.com"}},
+		{name: "invalid address", msg: mailer.Message{From: "no-reply@toyrental.kz", To: "parent", Subject: "Hi"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.msg.Bytes()
+			assert.ErrorIs(t, err, mailer.ErrInvalidHeader)
+		})
+	}
+}
+
+func TestMessageFormatsAddresses(t *testing.T) {
+	msg := mailer.Message{From: "Toy Rental <no-reply@toyrental.kz>", To: "Әсел <parent@example.com>", Subject: "Hi"}
+
+	body, err := msg.Bytes()
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "From: \"Toy Rental\" <no-reply@toyrental.kz>\r\n")
+	assert.Contains(t, string(body), "To: =?utf-8?q?=D3=98=D1=81=D0=B5=D0=BB?= <parent@example.com>\r\n")
+}
@@ -0,0 +1,188 @@
+package unit
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/validator"
+)
+
+var manufacturerColumns = []string{"id", "name", "country", "website", "safety_contact", "created_at", "version"}
+
+// expectManufacturerLookup expects the manufacturers of toys to be looked up, and
+// answers with a single manufacturer.
+func expectManufacturerLookup(mock sqlmock.Sqlmock, id int64, name string) {
+	mock.ExpectQuery(`SELECT .+ FROM manufacturers WHERE lower\(name\) = ANY\(\$1\)`).
+		WillReturnRows(sqlmock.NewRows(manufacturerColumns).AddRow(id, name, "", "", "", time.Now(), 1))
+}
+
+func TestValidateManufacturer(t *testing.T) {
+	v := validator.New()
+	data.ValidateManufacturer(v, &data.Manufacturer{Name: " ", Country: "Denmark", Website: "lego.com", SafetyContact: "call us"})
+	assert.Contains(t, v.Errors, "name")
+	assert.Contains(t, v.Errors, "country")
+	assert.Contains(t, v.Errors, "website")
+	assert.Contains(t, v.Errors, "safety_contact")
+
+	v = validator.New()
+	data.ValidateManufacturer(v, &data.Manufacturer{Name: "Lego", Country: "DK", Website: "https://www.lego.com", SafetyContact: "safety@lego.com"})
+	assert.True(t, v.Valid())
+
+	v = validator.New()
+	data.ValidateManufacturer(v, &data.Manufacturer{Name: "Brio", SafetyContact: "+46 451 713 00"})
+	assert.True(t, v.Valid())
+}
+
+func TestInsertManufacturerWithDuplicateName(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO manufacturers \(name, country, website, safety_contact\)`).
+		WithArgs("LEGO", "DK", "", "").
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "manufacturers_name_idx"})
+	mock.ExpectRollback()
+
+	m := data.ManufacturerModel{DB: db}
+
+	err = m.InsertAudited(&data.Manufacturer{Name: "LEGO", Country: "DK"}, audit.Actor{UserID: 2})
+	assert.ErrorIs(t, err, data.ErrDuplicateManufacturer)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateManufacturerRenamesToys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .+ FROM manufacturers WHERE id = \$1 FOR UPDATE`).WithArgs(4).
+		WillReturnRows(sqlmock.NewRows(manufacturerColumns).AddRow(4, "Lego", "DK", "", "", time.Now(), 2))
+	mock.ExpectQuery(`UPDATE manufacturers SET .+ version = version \+ 1 WHERE id = \$5 AND version = \$6 RETURNING version`).
+		WithArgs("The Lego Group", "DK", "", "", 4, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.ExpectExec(`UPDATE toys SET manufacturer = \$1, version = version \+ 1, updated_at = now\(\) WHERE manufacturer_id = \$2`).
+		WithArgs("The Lego Group", 4).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(sqlmock.AnyArg(), nil, "manufacturer.update", "manufacturer", "4", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	m := data.ManufacturerModel{DB: db}
+	manufacturer := &data.Manufacturer{ID: 4, Name: "The Lego Group", Country: "DK", Version: 2}
+
+	assert.NoError(t, m.UpdateAudited(manufacturer, audit.Actor{UserID: 2}))
+	assert.Equal(t, int32(3), manufacturer.Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteManufacturerInUse(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .+ FROM manufacturers WHERE id = \$1 FOR UPDATE`).WithArgs(4).
+		WillReturnRows(sqlmock.NewRows(manufacturerColumns).AddRow(4, "Lego", "DK", "", "", time.Now(), 2))
+	mock.ExpectExec(`DELETE FROM manufacturers WHERE id = \$1`).
+		WillReturnError(&pq.Error{Code: "23503", Constraint: "toys_manufacturer_id_fkey"})
+	mock.ExpectRollback()
+
+	m := data.ManufacturerModel{DB: db}
+
+	err = m.DeleteAudited(4, audit.Actor{UserID: 2})
+	assert.ErrorIs(t, err, data.ErrManufacturerInUse)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAllManufacturers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT count\(\*\) OVER\(\), id, name, .+ FROM manufacturers WHERE \(strpos\(lower\(name\), lower\(\$1\)\) > 0 OR \$1 = ''\) ORDER BY name ASC, id ASC LIMIT \$2 OFFSET \$3`).
+		WithArgs("le", 24, 0).
+		WillReturnRows(sqlmock.NewRows(append([]string{"count"}, manufacturerColumns...)).
+			AddRow(1, 4, "Lego", "DK", "https://www.lego.com", "", time.Now(), 2))
+
+	m := data.ManufacturerModel{DB: db}
+	filters := data.Filters{Page: 1, PageSize: 24, Sort: "name", SortSafeList: []string{"name"}}
+
+	manufacturers, metadata, err := m.GetAll("le", filters)
+	assert.NoError(t, err)
+	assert.Len(t, manufacturers, 1)
+	assert.Equal(t, "Lego", manufacturers[0].Name)
+	assert.Equal(t, 1, metadata.TotalRecords)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAllByManufacturerID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`WHERE deleted_at IS NULL AND manufacturer_id = \$1 ORDER BY`).
+		WithArgs(int64(4), 24, 0).
+		WillReturnRows(sqlmock.NewRows(toyListColumns))
+
+	m := data.ToyModel{DB: db}
+	filters := data.Filters{Page: 1, PageSize: 24, Sort: "id", SortSafeList: []string{"id"}}
+
+	_, _, err = m.GetAll(data.ToyFilter{ManufacturerID: 4}, filters)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInsertToyWithUnknownManufacturer(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	expectTermLookup(mock, "categories", "stem", "STEM")
+	expectTermLookup(mock, "skills", "motor", "motor")
+	mock.ExpectQuery(`SELECT .+ FROM manufacturers WHERE lower\(name\) = ANY\(\$1\)`).
+		WithArgs(pq.StringArray{"lego"}).
+		WillReturnRows(sqlmock.NewRows(manufacturerColumns))
+
+	m := data.ToyModel{DB: db}
+
+	err = m.Insert(versionedToy(1))
+
+	var unknown *data.UnknownManufacturerError
+	assert.ErrorAs(t, err, &unknown)
+	assert.Equal(t, "Lego", unknown.Name)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateToyWithUnknownManufacturer(t *testing.T) {
+	service := newTestToyService(t)
+	service.toys.missingManufacturers = map[string]bool{"Legoo": true}
+
+	w := httptest.NewRecorder()
+	service.UpdateToyHandler(w, toyRequest(http.MethodPatch, `{"manufacturer": "Legoo"}`, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "known manufacturer")
+}
+
+func TestImportToysWithUnknownManufacturer(t *testing.T) {
+	service := newTestToyService(t)
+	service.toys.missingManufacturers = map[string]bool{"Brioo": true}
+
+	body := "supplier_sku,title,skills,categories,recommended_age,manufacturer,value\n" +
+		"BR-1,Wooden train,motor,vehicles,3+,Brio,12000\n" +
+		"BR-2,Ferry,motor,vehicles,3+,Brioo,9000\n"
+
+	code, response := importRequest(t, service, "?dry_run=true", "text/csv", body)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, response.Import.Created)
+	assert.Equal(t, 1, response.Import.Failed)
+	assert.Contains(t, response.Import.Errors[0].Errors, "manufacturer")
+}
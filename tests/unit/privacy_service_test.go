@@ -0,0 +1,226 @@
+package unit
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/domain/entity"
+	"toy-rental-system/internal/repository"
+	"toy-rental-system/internal/repository/postgres"
+	"toy-rental-system/internal/service"
+	"toy-rental-system/pkg/jsonlog"
+)
+
+type fakePrivacyRepository struct {
+	user         *entity.User
+	jobs         []*entity.ErasureJob
+	anonymizeErr error
+	anonymized   bool
+}
+
+func (r *fakePrivacyRepository) FindByID(userID int64) (*entity.User, error) {
+	return r.user, nil
+}
+
+func (r *fakePrivacyRepository) Subscriptions(userID int64) ([]*entity.Subscription, error) {
+	return []*entity.Subscription{{ID: 1, UserID: userID, Tokens: 10, Price: 5000, Currency: "KZT"}}, nil
+}
+
+func (r *fakePrivacyRepository) TokenLedger(userID int64) ([]*entity.TokenLedgerEntry, error) {
+	subscriptionID := int64(1)
+	return []*entity.TokenLedgerEntry{{ID: 1, UserID: userID, Amount: 10, Reason: entity.TokenLedgerSubscription, SubscriptionID: &subscriptionID}}, nil
+}
+
+func (r *fakePrivacyRepository) AuditEvents(userID int64) ([]*audit.Event, error) {
+	return []*audit.Event{{ID: 1, Action: "subscription.create", IP: "10.0.0.1"}}, nil
+}
+
+func (r *fakePrivacyRepository) Reviews(userID int64) ([]*data.Review, error) {
+	return []*data.Review{{ID: 3, ToyID: 1, UserID: userID, Rating: 4, Body: "Great fun"}}, nil
+}
+
+func (r *fakePrivacyRepository) Rentals(userID int64) ([]*data.ToyReturn, error) {
+	return []*data.ToyReturn{{ID: 5, ToyID: 1, UserID: userID, ReturnedAt: time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)}}, nil
+}
+
+func (r *fakePrivacyRepository) CreateErasureJob(userID int64) (*entity.ErasureJob, error) {
+	job := &entity.ErasureJob{ID: int64(len(r.jobs) + 1), UserID: userID, Status: entity.ErasureStatusPending}
+	r.jobs = append(r.jobs, job)
+	return job, nil
+}
+
+func (r *fakePrivacyRepository) GetErasureJob(id int64) (*entity.ErasureJob, error) {
+	for _, job := range r.jobs {
+		if job.ID == id {
+			return job, nil
+		}
+	}
+	return nil, repository.ErrErasureJobNotFound
+}
+
+func (r *fakePrivacyRepository) LatestErasureJob(userID int64) (*entity.ErasureJob, error) {
+	if len(r.jobs) == 0 {
+		return nil, repository.ErrErasureJobNotFound
+	}
+	return r.jobs[len(r.jobs)-1], nil
+}
+
+func (r *fakePrivacyRepository) ClaimErasureJobs(limit int, lease time.Duration) ([]*entity.ErasureJob, error) {
+	jobs := []*entity.ErasureJob{}
+	for _, job := range r.jobs {
+		if job.Status == entity.ErasureStatusPending && len(jobs) < limit {
+			job.Status = entity.ErasureStatusRunning
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+func (r *fakePrivacyRepository) UpdateErasureJob(job *entity.ErasureJob) error {
+	return nil
+}
+
+func (r *fakePrivacyRepository) Anonymize(userID int64, actor audit.Actor) error {
+	r.anonymized = r.anonymizeErr == nil
+	return r.anonymizeErr
+}
+
+func newTestPrivacyService() (service.PrivacyService, *fakePrivacyRepository) {
+	repo := &fakePrivacyRepository{user: &entity.User{ID: 1, Username: "aigerim", Password: "secret", Role: entity.RoleUser}}
+	logger := jsonlog.New(io.Discard, jsonlog.LevelInfo)
+
+	return service.NewPrivacyService(repo, logger), repo
+}
+
+func TestExport(t *testing.T) {
+	privacy, _ := newTestPrivacyService()
+
+	buf := new(bytes.Buffer)
+	err := privacy.Export(1, buf)
+	assert.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+
+	contents := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		contents[f.Name] = string(b)
+	}
+
+	assert.Contains(t, contents["profile.json"], "aigerim")
+	assert.NotContains(t, contents["profile.json"], "secret")
+	assert.Contains(t, contents["subscriptions.json"], "KZT")
+	assert.Contains(t, contents["token_ledger.json"], `"reason": "subscription"`)
+	assert.Contains(t, contents["activity.json"], "subscription.create")
+	assert.Contains(t, contents["reviews.json"], "Great fun")
+	assert.Contains(t, contents["rentals.json"], "2024-05-15T00:00:00Z")
+}
+
+func TestErasure(t *testing.T) {
+	privacy, repo := newTestPrivacyService()
+
+	job, err := privacy.RequestErasure(1)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.ErasureStatusPending, job.Status)
+
+	// A second request is refused while the first one hasn't finished.
+	_, err = privacy.RequestErasure(1)
+	assert.ErrorIs(t, err, service.ErrErasureInProgress)
+
+	privacy.Erase(job, audit.Actor{UserID: 1})
+	assert.True(t, repo.anonymized)
+	assert.Equal(t, entity.ErasureStatusCompleted, job.Status)
+	assert.NotNil(t, job.CompletedAt)
+}
+
+func TestErasureFailure(t *testing.T) {
+	privacy, repo := newTestPrivacyService()
+	repo.anonymizeErr = errors.New("connection reset")
+
+	job, err := privacy.RequestErasure(1)
+	assert.NoError(t, err)
+
+	privacy.Erase(job, audit.Actor{UserID: 1})
+	assert.Equal(t, entity.ErasureStatusFailed, job.Status)
+	assert.Equal(t, "connection reset", job.Error)
+
+	// A failed job can be retried.
+	_, err = privacy.RequestErasure(1)
+	assert.NoError(t, err)
+}
+
+func TestProcessPendingErasures(t *testing.T) {
+	privacy, repo := newTestPrivacyService()
+
+	job, err := privacy.RequestErasure(1)
+	assert.NoError(t, err)
+
+	err = privacy.ProcessPendingErasures()
+	assert.NoError(t, err)
+	assert.True(t, repo.anonymized)
+	assert.Equal(t, entity.ErasureStatusCompleted, job.Status)
+
+	// Finished jobs aren't claimed again.
+	repo.anonymized = false
+	err = privacy.ProcessPendingErasures()
+	assert.NoError(t, err)
+	assert.False(t, repo.anonymized)
+}
+
+func TestClaimErasureJobsResumesExpiredLeases(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	requestedAt := time.Now()
+	mock.ExpectQuery(`UPDATE erasure_jobs SET status = 'running', lease_expires_at = now\(\) \+ \$2 \* interval '1 second' WHERE id IN \( SELECT id FROM erasure_jobs WHERE status = 'pending' OR \(status = 'running' AND \(lease_expires_at IS NULL OR lease_expires_at <= now\(\)\)\) ORDER BY id LIMIT \$1 FOR UPDATE SKIP LOCKED \)`).
+		WithArgs(10, float64(300)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "status", "error", "requested_at", "completed_at"}).
+			AddRow(3, 7, entity.ErasureStatusRunning, "", requestedAt, nil))
+
+	repo := postgres.NewPrivacyRepository(db)
+	jobs, err := repo.ClaimErasureJobs(10, 5*time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, int64(7), jobs[0].UserID)
+	assert.Equal(t, entity.ErasureStatusRunning, jobs[0].Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAnonymizeRemovesTwoFactorCredentials(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT username FROM users WHERE id = \$1 FOR UPDATE`).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("aigerim"))
+	mock.ExpectExec(`UPDATE users SET .+ totp_secret = '', totp_enabled = false, totp_last_step = 0 WHERE id = \$1`).
+		WithArgs(7, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM addresses WHERE user_id = \$1`).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM tokens WHERE user_id = \$1`).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM recovery_codes WHERE user_id = \$1`).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec(`DELETE FROM login_failures WHERE subject = \$1`).WithArgs("username:aigerim").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE toy_reviews SET body = '' WHERE user_id = \$1`).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(7, nil, "user.erase", "user", "7", nil, nil, sqlmock.AnyArg(), "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	repo := postgres.NewPrivacyRepository(db)
+
+	assert.NoError(t, repo.Anonymize(7, audit.Actor{UserID: 7}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
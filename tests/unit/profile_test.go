@@ -0,0 +1,85 @@
+package unit
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"toy-rental-system/internal/domain/entity"
+	"toy-rental-system/internal/repository"
+	"toy-rental-system/internal/validator"
+)
+
+func TestValidateProfile(t *testing.T) {
+	address := entity.Address{Line1: "Abai Ave 10", City: "Almaty", PostalCode: "050000", Country: "KZ"}
+
+	tests := []struct {
+		name   string
+		user   entity.User
+		errors []string
+	}{
+		{
+			name: "valid",
+			user: entity.User{Email: "aigerim@example.com", Phone: "+7 (701) 123-45-67", Addresses: []entity.Address{address}},
+		},
+		{
+			name: "no email yet",
+			user: entity.User{DisplayName: "Aigerim"},
+		},
+		{
+			name:   "invalid email and phone",
+			user:   entity.User{Email: "aigerim@", Phone: "call me"},
+			errors: []string{"email", "phone"},
+		},
+		{
+			name:   "incomplete address",
+			user:   entity.User{Addresses: []entity.Address{{Line1: "Abai Ave 10", Country: "kz"}}},
+			errors: []string{"addresses[0].city", "addresses[0].postal_code", "addresses[0].country"},
+		},
+		{
+			name: "two default addresses",
+			user: entity.User{Addresses: []entity.Address{
+				{Line1: "Abai Ave 10", City: "Almaty", PostalCode: "050000", Country: "KZ", IsDefault: true},
+				{Line1: "Kabanbay Batyr 53", City: "Astana", PostalCode: "010000", Country: "KZ", IsDefault: true},
+			}},
+			errors: []string{"addresses"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			entity.ValidateProfile(v, &tt.user)
+
+			assert.Len(t, v.Errors, len(tt.errors))
+			for _, key := range tt.errors {
+				assert.Contains(t, v.Errors, key)
+			}
+		})
+	}
+}
+
+func TestProfileOmitsPassword(t *testing.T) {
+	user := &entity.User{ID: 1, Username: "aigerim", Password: "correct horse", Email: "aigerim@example.com"}
+
+	js, err := json.Marshal(user.Profile())
+	assert.NoError(t, err)
+	assert.Contains(t, string(js), `"email":"aigerim@example.com"`)
+	assert.Contains(t, string(js), `"addresses":[]`)
+	assert.NotContains(t, string(js), "correct horse")
+}
+
+func TestUpdateProfileDuplicateEmail(t *testing.T) {
+	users, _ := newTestUserServiceWithUsers(&fakeUserRepository{
+		users: map[string]*entity.User{
+			"aigerim": {ID: 1, Username: "aigerim", Email: "aigerim@example.com"},
+			"dana":    {ID: 2, Username: "dana"},
+		},
+	})
+
+	dana, err := users.GetProfile(2)
+	assert.NoError(t, err)
+
+	dana.Email = "aigerim@example.com"
+	err = users.UpdateProfile(dana)
+	assert.ErrorIs(t, err, repository.ErrDuplicateEmail)
+}
@@ -0,0 +1,195 @@
+package unit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"toy-rental-system/internal/ratelimit"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name    string
+		val     string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", val: "", want: nil},
+		{name: "single IPv4", val: "10.0.0.1", want: []string{"10.0.0.1/32"}},
+		{name: "single IPv6", val: "::1", want: []string{"::1/128"}},
+		{name: "CIDR ranges with spaces", val: " 10.0.0.0/8 , 192.168.1.0/24,", want: []string{"10.0.0.0/8", "192.168.1.0/24"}},
+		{name: "host bits are masked", val: "172.16.5.4/12", want: []string{"172.16.0.0/12"}},
+		{name: "invalid IP", val: "10.0.0.1,proxy.local", wantErr: true},
+		{name: "invalid mask", val: "10.0.0.0/33", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxies, err := ratelimit.ParseTrustedProxies(tt.val)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			var got []string
+			for _, network := range proxies {
+				got = append(got, network.String())
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTrustedProxiesContains(t *testing.T) {
+	proxies, err := ratelimit.ParseTrustedProxies("10.0.0.0/8, 2001:db8::1")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "10.1.2.3", want: true},
+		{ip: "11.0.0.1", want: false},
+		{ip: "2001:db8::1", want: true},
+		{ip: "2001:db8::2", want: false},
+		{ip: "", want: false},
+		{ip: "not-an-ip", want: false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, proxies.Contains(tt.ip), "ip %q", tt.ip)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ratelimit.ParseTrustedProxies("10.0.0.0/8")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		want         string
+	}{
+		{name: "direct peer", remoteAddr: "203.0.113.9:51234", want: "203.0.113.9"},
+		{name: "direct peer with a forged header", remoteAddr: "203.0.113.9:51234", forwardedFor: "1.2.3.4", want: "203.0.113.9"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:443", forwardedFor: "203.0.113.9", want: "203.0.113.9"},
+		{name: "trusted proxy chain", remoteAddr: "10.0.0.1:443", forwardedFor: "203.0.113.9, 10.0.0.2, 10.0.0.3", want: "203.0.113.9"},
+		{name: "untrusted proxy after a forged header", remoteAddr: "10.0.0.1:443", forwardedFor: "1.2.3.4, 198.51.100.7", want: "198.51.100.7"},
+		{name: "malformed hop", remoteAddr: "10.0.0.1:443", forwardedFor: "1.2.3.4, garbage, 10.0.0.2", want: "10.0.0.2"},
+		{name: "trusted proxy without a header", remoteAddr: "10.0.0.1:443", want: "10.0.0.1"},
+		{name: "IPv6 peer", remoteAddr: "[2001:db8::7]:443", forwardedFor: "1.2.3.4", want: "2001:db8::7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/toys", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+
+			assert.Equal(t, tt.want, proxies.ClientIP(r))
+		})
+	}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name     string
+		rps      float64
+		burst    int
+		requests int
+		allowed  int
+	}{
+		{name: "within the burst", rps: 1, burst: 4, requests: 4, allowed: 4},
+		{name: "beyond the burst", rps: 1, burst: 4, requests: 6, allowed: 4},
+		{name: "burst of one", rps: 0.2, burst: 1, requests: 3, allowed: 1},
+		{name: "no burst", rps: 1, burst: 0, requests: 2, allowed: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := ratelimit.New(tt.rps, tt.burst)
+
+			allowed := 0
+			for i := 0; i < tt.requests; i++ {
+				ok, retryAfter := l.Allow("ip:203.0.113.9", tt.rps, tt.burst)
+				if ok {
+					allowed++
+					assert.Zero(t, retryAfter)
+				} else {
+					assert.Positive(t, retryAfter)
+				}
+			}
+			assert.Equal(t, tt.allowed, allowed)
+
+			// Every client has its own bucket.
+			ok, _ := l.Allow("ip:198.51.100.7", tt.rps, tt.burst)
+			assert.Equal(t, tt.burst > 0, ok)
+		})
+	}
+}
+
+func TestLimiterPerRoute(t *testing.T) {
+	global := ratelimit.New(2, 4)
+	login := ratelimit.New(0.2, 1)
+
+	key := func(l *ratelimit.Limiter) ratelimit.KeyFunc {
+		return func(r *http.Request) (string, float64, int) {
+			return "ip:" + r.RemoteAddr, l.RPS, l.Burst
+		}
+	}
+	exceeded := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	mux := http.NewServeMux()
+	mux.Handle("/login", login.Handler(key(login), exceeded, ok))
+	mux.Handle("/toys", global.Handler(key(global), exceeded, ok))
+
+	request := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, nil)
+		r.RemoteAddr = "203.0.113.9"
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, request("/login").Code)
+
+	// The second login attempt has to wait five seconds for the next token.
+	w := request("/login")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+
+	// The rest of the API has its own, more generous limits.
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, http.StatusOK, request("/toys").Code)
+	}
+	w = request("/toys")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
+
+func TestLimiterDelay(t *testing.T) {
+	l := ratelimit.New(0.2, 2)
+
+	// Clients we haven't seen yet, and clients with tokens left, don't have to wait.
+	assert.Zero(t, l.Delay("ip:203.0.113.9"))
+	l.Allow("ip:203.0.113.9", l.RPS, l.Burst)
+	assert.Zero(t, l.Delay("ip:203.0.113.9"))
+
+	// Delay doesn't take a token itself.
+	l.Allow("ip:203.0.113.9", l.RPS, l.Burst)
+	delay := l.Delay("ip:203.0.113.9")
+	assert.Greater(t, delay, 4*time.Second)
+	assert.LessOrEqual(t, delay, 5*time.Second)
+	assert.Equal(t, delay.Round(time.Second), l.Delay("ip:203.0.113.9").Round(time.Second))
+
+	assert.Zero(t, l.Delay("ip:198.51.100.7"))
+}
@@ -0,0 +1,215 @@
+package unit
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/validator"
+)
+
+var reviewColumns = []string{"id", "toy_id", "user_id", "author", "rating", "body", "created_at"}
+
+// expectReviewedToyLock expects the toy to be locked before its reviews change, and
+// whether the user has returned it to be checked.
+func expectReviewedToyLock(mock sqlmock.Sqlmock, toyID int64, userID int64, returned bool) {
+	mock.ExpectQuery(`SELECT id FROM toys WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).WithArgs(toyID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(toyID))
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM toy_returns WHERE toy_id = \$1 AND user_id = \$2\)`).WithArgs(toyID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(returned))
+}
+
+func TestValidateReview(t *testing.T) {
+	v := validator.New()
+	data.ValidateReview(v, &data.Review{Rating: 5, Body: "Played with it every day"})
+	assert.True(t, v.Valid())
+
+	v = validator.New()
+	data.ValidateReview(v, &data.Review{Rating: 4})
+	assert.True(t, v.Valid())
+
+	v = validator.New()
+	data.ValidateReview(v, &data.Review{Rating: 6, Body: "  "})
+	assert.Contains(t, v.Errors, "rating")
+	assert.Contains(t, v.Errors, "body")
+
+	v = validator.New()
+	data.ValidateReview(v, &data.Review{Rating: 0, Body: strings.Repeat("a", 5001)})
+	assert.Contains(t, v.Errors, "rating")
+	assert.Contains(t, v.Errors, "body")
+}
+
+func TestInsertReviewUpdatesRating(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectReviewedToyLock(mock, 1, 7, true)
+	mock.ExpectQuery(`INSERT INTO toy_reviews \(toy_id, user_id, rating, body\) VALUES \(\$1, \$2, \$3, \$4\) RETURNING id, created_at`).
+		WithArgs(1, 7, 4, "Great fun").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, time.Now()))
+	mock.ExpectExec(`UPDATE toys SET rating = COALESCE\(\(SELECT round\(avg\(rating\), 2\) FROM toy_reviews WHERE toy_id = \$1\), 0\), rating_count = \(SELECT count\(\*\) FROM toy_reviews WHERE toy_id = \$1\), updated_at = now\(\) WHERE id = \$1`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(sqlmock.AnyArg(), nil, "review.create", "review", "3", nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	m := data.ReviewModel{DB: db}
+	review := &data.Review{ToyID: 1, UserID: 7, Rating: 4, Body: "Great fun"}
+
+	assert.NoError(t, m.InsertAudited(review, audit.Actor{UserID: 7}))
+	assert.Equal(t, int64(3), review.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInsertReviewWithoutReturn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectReviewedToyLock(mock, 1, 7, false)
+	mock.ExpectRollback()
+
+	m := data.ReviewModel{DB: db}
+
+	err = m.InsertAudited(&data.Review{ToyID: 1, UserID: 7, Rating: 1}, audit.Actor{UserID: 7})
+	assert.ErrorIs(t, err, data.ErrNotReturned)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInsertDuplicateReview(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectReviewedToyLock(mock, 1, 7, true)
+	mock.ExpectQuery(`INSERT INTO toy_reviews`).
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "toy_reviews_toy_id_user_id_key"})
+	mock.ExpectRollback()
+
+	m := data.ReviewModel{DB: db}
+
+	err = m.InsertAudited(&data.Review{ToyID: 1, UserID: 7, Rating: 5}, audit.Actor{UserID: 7})
+	assert.ErrorIs(t, err, data.ErrDuplicateReview)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteReviewUpdatesRating(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM toys WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`DELETE FROM toy_reviews WHERE id = \$1`).WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE toys SET rating = .+ WHERE id = \$1`).WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(sqlmock.AnyArg(), nil, "review.delete", "review", "3", sqlmock.AnyArg(), nil, sqlmock.AnyArg(), "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	m := data.ReviewModel{DB: db}
+
+	assert.NoError(t, m.DeleteAudited(&data.Review{ID: 3, ToyID: 1, UserID: 7, Rating: 2}, audit.Actor{UserID: 2}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAllReviewsForToy(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT count\(\*\) OVER\(\), id, toy_id, user_id, .+ FROM toy_reviews WHERE toy_id = \$1 ORDER BY rating DESC, id DESC LIMIT \$2 OFFSET \$3`).
+		WithArgs(1, 10, 10).
+		WillReturnRows(sqlmock.NewRows(append([]string{"count"}, reviewColumns...)).
+			AddRow(11, 3, 1, 7, "Aigerim", 4, "Great fun", time.Now()))
+
+	m := data.ReviewModel{DB: db}
+	filters := data.Filters{Page: 2, PageSize: 10, Sort: "-rating", SortSafeList: []string{"-rating"}}
+
+	reviews, metadata, err := m.GetAllForToy(1, filters)
+	assert.NoError(t, err)
+	assert.Len(t, reviews, 1)
+	assert.Equal(t, "Aigerim", reviews[0].Author)
+	assert.Equal(t, 2, metadata.LastPage)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRecordToyReturnOfDeletedToy(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO toy_returns \(toy_id, user_id\) SELECT id, \$2 FROM toys WHERE id = \$1 AND deleted_at IS NULL`).
+		WithArgs(1, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "toy_id", "user_id", "returned_at"}))
+	mock.ExpectRollback()
+
+	m := data.ReviewModel{DB: db}
+
+	err = m.RecordReturnAudited(&data.ToyReturn{ToyID: 1, UserID: 7}, audit.Actor{UserID: 2})
+	assert.ErrorIs(t, err, data.ErrRecordNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAllSortedByRating(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`WHERE deleted_at IS NULL\s+ORDER BY rating DESC, id DESC\s+LIMIT \$1 OFFSET \$2`).
+		WithArgs(24, 0).
+		WillReturnRows(sqlmock.NewRows(toyListColumns))
+
+	m := data.ToyModel{DB: db}
+	filters := data.Filters{Page: 1, PageSize: 24, Sort: "-rating", SortSafeList: []string{"-rating"}}
+
+	_, _, err = m.GetAll(data.ToyFilter{}, filters)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateToyCannotPatchRating(t *testing.T) {
+	service := newTestToyService(t)
+	service.toys.toys[1].Rating, service.toys.toys[1].RatingCount = 3.5, 2
+
+	header := http.Header{"Content-Type": {"application/merge-patch+json"}}
+	w := httptest.NewRecorder()
+	service.UpdateToyHandler(w, toyRequest(http.MethodPatch, `{"rating": 5, "rating_count": 100}`, header))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"rating": "cannot be changed"`)
+	assert.Equal(t, 3.5, service.toys.toys[1].Rating)
+	assert.Equal(t, int32(2), service.toys.toys[1].RatingCount)
+}
+
+func TestNewReviewChangesToyETag(t *testing.T) {
+	service := newTestToyService(t)
+
+	w := httptest.NewRecorder()
+	service.ShowToyHandler(w, toyRequest(http.MethodGet, "", nil))
+	etag := w.Header().Get("ETag")
+
+	// A review changes the rating but not the version of the toy.
+	service.toys.toys[1].Rating, service.toys.toys[1].RatingCount = 4, 1
+
+	w = httptest.NewRecorder()
+	service.ShowToyHandler(w, toyRequest(http.MethodGet, "", http.Header{"If-None-Match": {etag}}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+	assert.Equal(t, `"toy-1-v2-r1"`, w.Header().Get("ETag"))
+}
@@ -0,0 +1,87 @@
+package unit
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"toy-rental-system/internal/data"
+)
+
+func TestGetAllSortsByRelevance(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	columns := []string{"count", "id", "created_at", "title", "desc", "details", "skills", "categories", "recommended_age", "manufacturer", "value", "is_available", "wait_list", "language", "version", "updated_at", "attributes", "rating", "rating_count", "relevance", "title_headline", "desc_headline"}
+
+	mock.ExpectQuery(`ts_rank\(search, q.query\) AS relevance.+WHERE deleted_at IS NULL\s+AND search @@ q.query.+ORDER BY relevance DESC, id DESC\s+LIMIT \$2 OFFSET \$3`).
+		WithArgs("wooden train", 24, 0).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, 7, time.Now(), "Wooden train", "A train made of beech", pq.StringArray{}, pq.StringArray{"motor"}, pq.StringArray{"vehicles"}, "3+", "Brio", 12000, true, pq.StringArray{}, "english", 1, time.Now(), []byte("{}"), 0, 0, 0.6, "<mark>Wooden</mark> <mark>train</mark>", "A <mark>train</mark> made of beech"))
+
+	m := data.ToyModel{DB: db}
+	filters := data.Filters{Page: 1, PageSize: 24, Sort: "relevance", SortSafeList: []string{"id", "relevance"}}
+
+	toys, metadata, err := m.GetAll(data.ToyFilter{Title: "wooden train"}, filters)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, 1, metadata.TotalRecords)
+	assert.Len(t, toys, 1)
+	assert.Equal(t, "<mark>Wooden</mark> <mark>train</mark>", toys[0].Highlight.Title)
+}
+
+func TestGetAllWithoutSearchTermHasNoHighlight(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	columns := []string{"count", "id", "created_at", "title", "desc", "details", "skills", "categories", "recommended_age", "manufacturer", "value", "is_available", "wait_list", "language", "version", "updated_at", "attributes", "rating", "rating_count", "relevance", "title_headline", "desc_headline"}
+
+	mock.ExpectQuery(`ORDER BY title DESC, id DESC`).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, 7, time.Now(), "Wooden train", "", pq.StringArray{}, pq.StringArray{"motor"}, pq.StringArray{"vehicles"}, "3+", "Brio", 12000, true, pq.StringArray{}, "english", 1, time.Now(), []byte("{}"), 0, 0, 0, "", ""))
+
+	m := data.ToyModel{DB: db}
+	filters := data.Filters{Page: 1, PageSize: 24, Sort: "-title", SortSafeList: []string{"-title"}}
+
+	toys, _, err := m.GetAll(data.ToyFilter{}, filters)
+	assert.NoError(t, err)
+	assert.Nil(t, toys[0].Highlight)
+}
+
+func TestSuggestEscapesPrefix(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`FROM toy_search_terms`).
+		WithArgs("100%_lego", `100\%\_lego%`, 8).
+		WillReturnRows(sqlmock.NewRows([]string{"term", "kind", "toys"}).AddRow("LEGO", "manufacturer", 12))
+
+	m := data.ToyModel{DB: db}
+
+	suggestions, err := m.Suggest("  100%_LEGO ", 8)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, "LEGO", suggestions[0].Term)
+}
+
+func TestDidYouMean(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// "a" is too short to be corrected, so only the other two words are looked up.
+	mock.ExpectQuery(`FROM toy_search_terms`).WithArgs("lgeo").
+		WillReturnRows(sqlmock.NewRows([]string{"term"}).AddRow("lego"))
+	mock.ExpectQuery(`FROM toy_search_terms`).WithArgs("train").
+		WillReturnRows(sqlmock.NewRows([]string{"term"}).AddRow("train"))
+
+	m := data.ToyModel{DB: db}
+
+	suggestion, err := m.DidYouMean("Lgeo a train")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, "lego a train", suggestion)
+}
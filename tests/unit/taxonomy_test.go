@@ -0,0 +1,241 @@
+package unit
+
+import (
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/validator"
+)
+
+var termColumns = []string{"id", "parent_id", "slug", "name", "translations", "created_at", "version"}
+
+// expectTermLookup expects the taxonomy to be asked for the terms of a toy, and answers
+// with a single term.
+func expectTermLookup(mock sqlmock.Sqlmock, table, slug, name string) {
+	mock.ExpectQuery(`SELECT id, slug, name FROM ` + table + ` WHERE slug = ANY\(\$1\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "slug", "name"}).AddRow(1, slug, name))
+}
+
+// expectTermLinks expects the links of toys to the terms of one taxonomy to be synced.
+func expectTermLinks(mock sqlmock.Sqlmock, table, column string) {
+	mock.ExpectExec(`WITH links .+ DELETE FROM ` + table + ` .+ INSERT INTO ` + table + ` \(toy_id, ` + column + `\) .+ ON CONFLICT DO NOTHING`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"STEM":                "stem",
+		"Fine motor skills":   "fine-motor-skills",
+		"  Arts & Crafts!  ":  "arts-crafts",
+		"already-a-slug":      "already-a-slug",
+		"Развивающие игрушки": "развивающие-игрушки",
+		"--":                  "",
+	}
+
+	for name, slug := range tests {
+		assert.Equal(t, slug, data.Slugify(name), name)
+	}
+}
+
+func TestValidateTerm(t *testing.T) {
+	parent := int64(4)
+
+	v := validator.New()
+	data.ValidateTerm(v, &data.Term{ID: 4, ParentID: &parent, Slug: "Board Games", Translations: map[string]string{"german": "Brettspiele"}})
+	assert.Contains(t, v.Errors, "name")
+	assert.Contains(t, v.Errors, "slug")
+	assert.Contains(t, v.Errors, "parent_id")
+	assert.Contains(t, v.Errors, "translations")
+
+	v = validator.New()
+	data.ValidateTerm(v, &data.Term{ID: 5, ParentID: &parent, Slug: "board-games", Name: "Board games", Translations: map[string]string{"russian": "Настольные игры"}})
+	assert.True(t, v.Valid())
+}
+
+func TestInsertTermAudited(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO skills \(parent_id, slug, name, translations\) VALUES \(\$1, \$2, \$3, \$4\) RETURNING id, created_at, version`).
+		WithArgs(nil, "logic", "Logic", []byte(`{}`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "version"}).AddRow(3, time.Now(), 1))
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(sqlmock.AnyArg(), nil, "skill.create", "skill", "3", nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	m := data.TaxonomyModel{DB: db, Kind: data.TaxonomySkills}
+	term := &data.Term{Slug: "logic", Name: "Logic"}
+
+	assert.NoError(t, m.InsertAudited(term, audit.Actor{UserID: 2}))
+	assert.Equal(t, int64(3), term.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInsertTermWithDuplicateSlug(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO categories`).WillReturnError(&pq.Error{Code: "23505", Constraint: "categories_slug_key"})
+	mock.ExpectRollback()
+
+	m := data.TaxonomyModel{DB: db, Kind: data.TaxonomyCategories}
+
+	err = m.InsertAudited(&data.Term{Slug: "stem", Name: "STEM"}, audit.Actor{UserID: 2})
+	assert.ErrorIs(t, err, data.ErrDuplicateSlug)
+}
+
+func TestUpdateTermRejectsCycle(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Category 7 is below category 2, so 2 can't be moved below 7.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .+ FROM categories WHERE id = \$1 FOR UPDATE`).WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(termColumns).AddRow(2, nil, "vehicles", "Vehicles", []byte(`{}`), time.Now(), 1))
+	mock.ExpectQuery(`WITH RECURSIVE ancestors AS .+ SELECT EXISTS \(SELECT 1 FROM ancestors WHERE id = \$2\)`).WithArgs(7, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	m := data.TaxonomyModel{DB: db, Kind: data.TaxonomyCategories}
+	parent := int64(7)
+
+	err = m.UpdateAudited(&data.Term{ID: 2, ParentID: &parent, Slug: "vehicles", Name: "Vehicles", Version: 1}, audit.Actor{UserID: 2})
+	assert.ErrorIs(t, err, data.ErrInvalidParent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateTermRenamesToys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .+ FROM categories WHERE id = \$1 FOR UPDATE`).WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(termColumns).AddRow(2, nil, "vehicles", "Vehicles", []byte(`{}`), time.Now(), 1))
+	mock.ExpectQuery(`UPDATE categories SET .+ version = version \+ 1 WHERE id = \$5 AND version = \$6 RETURNING version`).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+	mock.ExpectExec(`UPDATE toys SET categories = array_replace\(categories, \$1, \$2\), version = version \+ 1, updated_at = now\(\) WHERE id IN \(SELECT toy_id FROM toy_categories WHERE category_id = \$3\)`).
+		WithArgs("Vehicles", "Cars and trains", 2).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(sqlmock.AnyArg(), nil, "category.update", "category", "2", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	m := data.TaxonomyModel{DB: db, Kind: data.TaxonomyCategories}
+	term := &data.Term{ID: 2, Slug: "vehicles", Name: "Cars and trains", Version: 1}
+
+	assert.NoError(t, m.UpdateAudited(term, audit.Actor{UserID: 2}))
+	assert.Equal(t, int32(2), term.Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteTermInUse(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .+ FROM skills WHERE id = \$1 FOR UPDATE`).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(termColumns).AddRow(3, nil, "logic", "Logic", []byte(`{}`), time.Now(), 1))
+	mock.ExpectExec(`DELETE FROM skills WHERE id = \$1`).
+		WillReturnError(&pq.Error{Code: "23503", Constraint: "toy_skills_skill_id_fkey", Message: `update or delete on table "skills" violates foreign key constraint`})
+	mock.ExpectRollback()
+
+	m := data.TaxonomyModel{DB: db, Kind: data.TaxonomySkills}
+
+	err = m.DeleteAudited(3, audit.Actor{UserID: 2})
+	assert.ErrorIs(t, err, data.ErrTermInUse)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAllIncludesDescendantCategories(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Every category is its own condition, and matches the toys of its subcategories
+	// as well.
+	mock.ExpectQuery(`WHERE deleted_at IS NULL
+AND EXISTS \( SELECT 1 FROM toy_skills .+ WHERE slug = ANY\(\$1\) .+ \)
+AND EXISTS \( SELECT 1 FROM toy_categories WHERE toy_categories.toy_id = toys.id AND toy_categories.category_id IN \( WITH RECURSIVE tree AS \( SELECT id FROM categories WHERE slug = ANY\(\$2\) UNION ALL SELECT t.id FROM categories t JOIN tree ON t.parent_id = tree.id \) SELECT id FROM tree \) \)
+AND EXISTS \( SELECT 1 FROM toy_categories .+ slug = ANY\(\$3\) .+ \)
+ORDER BY`).
+		WithArgs(pq.StringArray{"fine-motor"}, pq.StringArray{"vehicles"}, pq.StringArray{"wooden-toys"}, 24, 0).
+		WillReturnRows(sqlmock.NewRows(toyListColumns))
+
+	m := data.ToyModel{DB: db}
+	filters := data.Filters{Page: 1, PageSize: 24, Sort: "id", SortSafeList: []string{"id"}}
+	filter := data.ToyFilter{Categories: []string{"Vehicles", "Wooden toys"}, Skills: []string{"fine-motor"}}
+
+	_, _, err = m.GetAll(filter, filters)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInsertToyRejectsUnknownTerms(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, slug, name FROM categories WHERE slug = ANY\(\$1\)`).
+		WithArgs(pq.StringArray{"stem", "space"}).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "slug", "name"}).AddRow(1, "stem", "STEM"))
+	expectTermLookup(mock, "skills", "motor", "motor")
+
+	m := data.ToyModel{DB: db}
+	toy := versionedToy(1)
+	toy.Categories = []string{"stem", "Space"}
+
+	err = m.Insert(toy)
+
+	var unknown *data.UnknownTermsError
+	assert.ErrorAs(t, err, &unknown)
+	assert.Equal(t, data.TaxonomyCategories, unknown.Kind)
+	assert.Equal(t, []string{"Space"}, unknown.Values)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateToyWithUnknownCategory(t *testing.T) {
+	service := newTestToyService(t)
+	service.toys.missingTerms = map[string]bool{"Space": true}
+
+	w := httptest.NewRecorder()
+	service.UpdateToyHandler(w, toyRequest(http.MethodPatch, `{"categories": ["STEM", "Space"]}`, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	var response struct {
+		Error map[string]string `json:"error"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Contains(t, response.Error["categories"], "Space")
+}
+
+func TestImportToysWithUnknownTerms(t *testing.T) {
+	service := newTestToyService(t)
+	service.toys.missingTerms = map[string]bool{"boats": true}
+
+	body := "supplier_sku,title,skills,categories,recommended_age,manufacturer,value\n" +
+		"BR-1,Wooden train,motor,vehicles,3+,Brio,12000\n" +
+		"BR-2,Ferry,motor,boats,3+,Brio,9000\n"
+
+	code, response := importRequest(t, service, "?dry_run=true", "text/csv", body)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, response.Import.Created)
+	assert.Equal(t, 1, response.Import.Failed)
+	assert.Equal(t, 3, response.Import.Errors[0].Row)
+	assert.Contains(t, response.Import.Errors[0].Errors["categories"], "boats")
+}
@@ -0,0 +1,113 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/storage"
+	"toy-rental-system/internal/thumbnail"
+	"toy-rental-system/pkg/jsonlog"
+)
+
+func TestResizeAveragesPixels(t *testing.T) {
+	// Alternating black and white columns average out to grey.
+	src := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 4; y++ {
+			if x%2 == 0 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+
+	dst := thumbnail.Resize(src, 2)
+	assert.Equal(t, image.Rect(0, 0, 2, 1), dst.Bounds())
+
+	r, g, b, a := dst.At(0, 0).RGBA()
+	assert.InDelta(t, 0x7f7f, r, 0x200)
+	assert.Equal(t, r, g)
+	assert.Equal(t, r, b)
+	assert.Equal(t, uint32(0xffff), a)
+}
+
+func TestThumbnailKey(t *testing.T) {
+	assert.Equal(t, "toys/7/3f2a_320w.jpg", thumbnail.Key("toys/7/3f2a.jpg", 320))
+}
+
+func TestToyImageSrcSet(t *testing.T) {
+	img := &data.ToyImage{
+		Key:   "toys/7/a.jpg",
+		Width: 800,
+		Variants: []*data.ToyImageVariant{
+			{Width: 160, Key: "toys/7/a_160w.jpg"},
+			{Width: 320, Key: "toys/7/a_320w.jpg"},
+		},
+	}
+
+	img.SetURLs(func(key string) string { return "/images/" + key })
+	assert.Equal(t, "/images/toys/7/a_160w.jpg 160w, /images/toys/7/a_320w.jpg 320w, /images/toys/7/a.jpg 800w", img.SrcSet)
+	assert.Equal(t, []string{"toys/7/a.jpg", "toys/7/a_160w.jpg", "toys/7/a_320w.jpg"}, img.Keys())
+}
+
+func newTestThumbnailWorker(t *testing.T) (*thumbnail.Worker, *fakeToyImageRepository, *storage.Local) {
+	store, err := storage.NewLocal(t.TempDir(), "/images")
+	assert.NoError(t, err)
+
+	images := &fakeToyImageRepository{}
+	worker := &thumbnail.Worker{
+		Images:      images,
+		Storage:     store,
+		Logger:      jsonlog.New(io.Discard, jsonlog.LevelOff),
+		MaxAttempts: 3,
+		BatchSize:   10,
+	}
+	return worker, images, store
+}
+
+func TestThumbnailWorkerGeneratesVariants(t *testing.T) {
+	worker, images, store := newTestThumbnailWorker(t)
+
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 400, 300))))
+	assert.NoError(t, store.Put(context.Background(), "toys/1/a.png", &buf))
+
+	images.InsertAudited(&data.ToyImage{ToyID: 1, Key: "toys/1/a.png", ContentType: "image/png", ThumbnailStatus: data.ThumbnailsPending}, audit.Actor{})
+
+	assert.NoError(t, worker.ProcessPending())
+
+	img := images.images[0]
+	assert.Equal(t, data.ThumbnailsDone, img.ThumbnailStatus)
+	assert.Equal(t, 400, img.Width)
+
+	// Only the widths below the original are generated.
+	assert.Len(t, img.Variants, 2)
+	assert.Equal(t, 320, img.Variants[1].Width)
+	assert.Equal(t, 240, img.Variants[1].Height)
+
+	f, err := store.Get(context.Background(), "toys/1/a_320w.png")
+	assert.NoError(t, err)
+	config, err := png.DecodeConfig(f)
+	f.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 320, config.Width)
+}
+
+func TestThumbnailWorkerRetriesFailures(t *testing.T) {
+	worker, images, store := newTestThumbnailWorker(t)
+
+	assert.NoError(t, store.Put(context.Background(), "toys/1/broken.png", bytes.NewReader([]byte("\x89PNG\r\n\x1a\nnot really"))))
+	images.InsertAudited(&data.ToyImage{ToyID: 1, Key: "toys/1/broken.png", ContentType: "image/png", ThumbnailStatus: data.ThumbnailsPending}, audit.Actor{})
+
+	assert.NoError(t, worker.ProcessPending())
+	assert.Len(t, images.retries[1], 1)
+	assert.Equal(t, data.ThumbnailsPending, images.images[0].ThumbnailStatus)
+}
@@ -0,0 +1,139 @@
+package unit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/domain/entity"
+	"toy-rental-system/internal/service"
+	"toy-rental-system/internal/totp"
+)
+
+// The secret "12345678901234567890" from appendix B of RFC 6238, base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// The RFC lists eight digit codes, these are their last six digits.
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, want := range tests {
+		code, err := totp.Code(rfc6238Secret, totp.Step(time.Unix(unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, want, code, "time %d", unix)
+	}
+}
+
+func TestTOTPValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	_, ok := totp.Validate(rfc6238Secret, "081804", now)
+	assert.True(t, ok)
+
+	// The code of the previous step is still accepted, the one from two steps ago isn't.
+	_, ok = totp.Validate(rfc6238Secret, "081804", now.Add(totp.Period))
+	assert.True(t, ok)
+	_, ok = totp.Validate(rfc6238Secret, "081804", now.Add(2*totp.Period))
+	assert.False(t, ok)
+
+	_, ok = totp.Validate(rfc6238Secret, "12345", now)
+	assert.False(t, ok)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	u, err := url.Parse(totp.ProvisioningURI("Toy Rental", "aigerim", rfc6238Secret))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Toy Rental:aigerim", u.Path)
+	assert.Equal(t, rfc6238Secret, u.Query().Get("secret"))
+	assert.Equal(t, "Toy Rental", u.Query().Get("issuer"))
+}
+
+func TestLoginRequiresTOTP(t *testing.T) {
+	user := &entity.User{ID: 1, Username: "aigerim", Password: "correct horse", TOTPSecret: rfc6238Secret, TOTPEnabled: true}
+	repo := &fakeUserRepository{
+		users:      map[string]*entity.User{"aigerim": user},
+		tokenUsers: map[string]*entity.User{data.ScopeTwoFactor: user},
+	}
+	users, attempts := newTestUserServiceWithUsers(repo)
+
+	result, err := users.Login("aigerim", "correct horse", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, data.ScopeTwoFactor, result.Scope)
+
+	_, err = users.LoginTOTP(result.Token, "000000", "10.0.0.1")
+	assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+	assert.Equal(t, 1, attempts.failures["username:aigerim"])
+
+	code, err := totp.Code(rfc6238Secret, totp.Step(time.Now()))
+	assert.NoError(t, err)
+
+	result, err = users.LoginTOTP(result.Token, code, "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, data.ScopeAuthentication, result.Scope)
+
+	// The same code can't be used twice.
+	_, err = users.LoginTOTP(result.Token, code, "10.0.0.1")
+	assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+}
+
+func TestStaffMustEnrollInTOTP(t *testing.T) {
+	staff := &entity.User{ID: 2, Username: "dana", Password: "correct horse", Role: entity.RoleStaff}
+	users, _ := newTestUserServiceWithUsers(&fakeUserRepository{
+		users: map[string]*entity.User{"dana": staff},
+	})
+
+	result, err := users.Login("dana", "correct horse", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, data.ScopeTOTPEnrollment, result.Scope)
+
+	enrollment, err := users.EnrollTOTP(staff)
+	assert.NoError(t, err)
+	assert.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	assert.NoError(t, err)
+
+	recoveryCodes, err := users.ConfirmTOTP(staff, code)
+	assert.NoError(t, err)
+	assert.Len(t, recoveryCodes, 10)
+	assert.True(t, staff.TOTPEnabled)
+
+	// Staff can't turn two-factor authentication off again.
+	err = users.DisableTOTP(staff, recoveryCodes[0])
+	assert.ErrorIs(t, err, service.ErrTOTPMandatory)
+}
+
+func TestRecoveryCodeCanBeUsedOnce(t *testing.T) {
+	user := &entity.User{ID: 1, Username: "aigerim", Password: "correct horse"}
+	users, _ := newTestUserServiceWithUsers(&fakeUserRepository{
+		users:      map[string]*entity.User{"aigerim": user},
+		tokenUsers: map[string]*entity.User{data.ScopeTwoFactor: user},
+	})
+
+	enrollment, err := users.EnrollTOTP(user)
+	assert.NoError(t, err)
+	code, _ := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	recoveryCodes, err := users.ConfirmTOTP(user, code)
+	assert.NoError(t, err)
+
+	result, err := users.Login("aigerim", "correct horse", "10.0.0.1")
+	assert.NoError(t, err)
+
+	// Recovery codes are accepted regardless of case.
+	_, err = users.LoginTOTP(result.Token, strings.ToUpper(recoveryCodes[3]), "10.0.0.1")
+	assert.NoError(t, err)
+
+	_, err = users.LoginTOTP(result.Token, recoveryCodes[3], "10.0.0.1")
+	assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+}
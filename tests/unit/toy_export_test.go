@@ -0,0 +1,132 @@
+package unit
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"toy-rental-system/internal/data"
+)
+
+func exportRequest(service testToyService, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	service.ExportToysHandler(w, httptest.NewRequest(http.MethodGet, "/admin/toys/export"+query, nil))
+	return w
+}
+
+func TestExportToysCSV(t *testing.T) {
+	service := newTestToyService(t)
+	service.toys.toys[1].Skills = []string{"motor", "logic"}
+	service.toys.toys[2] = &data.Toy{ID: 2, SupplierSKU: "BR-1", Title: "Wooden train, large", Skills: []string{"motor"}, Categories: []string{"vehicles"}, Manufacturer: "Brio", Value: 12000}
+
+	w := exportRequest(service, "?delimiter=%3B")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), `attachment; filename="toys-`)
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, []string{"id", "supplier_sku", "title"}, records[0][:3])
+	assert.Equal(t, "motor;logic", records[1][5])
+	assert.Equal(t, "Wooden train, large", records[2][2])
+
+	// The export can be filtered like the listing.
+	w = exportRequest(service, "?manufacturer=Brio")
+	records, err = csv.NewReader(w.Body).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "BR-1", records[1][1])
+}
+
+func TestExportToysCSVEscaping(t *testing.T) {
+	service := newTestToyService(t)
+	service.toys.toys[1].Title = `=HYPERLINK("http://example.com")`
+	service.toys.toys[1].Manufacturer = "@Lego"
+	service.toys.toys[1].Skills = []string{"-motor", "logic;maths", `back\slash`}
+
+	w := exportRequest(service, "?delimiter=%3B")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, `'=HYPERLINK("http://example.com")`, records[1][2])
+	assert.Equal(t, `'-motor;logic\;maths;back\\slash`, records[1][5])
+	assert.Equal(t, "'@Lego", records[1][9])
+
+	// The same values come back when the export is imported again.
+	var body strings.Builder
+	cw := csv.NewWriter(&body)
+	cw.Write([]string{"title", "skills", "categories", "recommended_age", "manufacturer", "value"})
+	cw.Write([]string{records[1][2], records[1][5], "STEM", "4+", "Lego", "7000"})
+	cw.Flush()
+
+	code, response := importRequest(t, service, "?delimiter=%3B", "text/csv", body.String())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, response.Import.Created)
+
+	imported := service.toys.toys[int64(len(service.toys.toys))]
+	assert.Equal(t, `=HYPERLINK("http://example.com")`, imported.Title)
+	assert.Equal(t, []string{"-motor", "logic;maths", `back\slash`}, imported.Skills)
+
+	w = exportRequest(service, `?delimiter=%5C`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestExportToysNDJSON(t *testing.T) {
+	service := newTestToyService(t)
+
+	w := exportRequest(service, "?format=ndjson")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 1)
+
+	var toy map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &toy))
+	assert.Equal(t, "Lego", toy["title"])
+	assert.Equal(t, []any{"motor"}, toy["skills"])
+	assert.Equal(t, []any{}, toy["images"])
+}
+
+func TestExportToysValidation(t *testing.T) {
+	service := newTestToyService(t)
+
+	w := exportRequest(service, "?format=xlsx")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = exportRequest(service, "?min_value=5000&max_value=1000")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestExportToysQuery(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	columns := []string{"id", "created_at", "title", "desc", "details", "skills", "categories", "images", "recommended_age", "manufacturer", "value", "is_available", "language", "version", "updated_at", "supplier_sku", "attributes"}
+
+	mock.ExpectQuery(`SELECT .+ FROM toys WHERE deleted_at IS NULL AND lower\(manufacturer\) = lower\(\$1\) ORDER BY id`).WithArgs("Brio").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(7, time.Now(), "Wooden train", "", pq.StringArray{}, pq.StringArray{"motor"}, pq.StringArray{"vehicles"}, pq.StringArray{}, "3+", "Brio", 12000, true, "english", 1, time.Now(), "BR-1", []byte("{}")).
+			AddRow(9, time.Now(), "Crane", "", pq.StringArray{}, pq.StringArray{"motor"}, pq.StringArray{"vehicles"}, pq.StringArray{}, "3+", "Brio", 9000, true, "english", 1, time.Now(), "", []byte("{}")))
+
+	m := data.ToyModel{DB: db}
+
+	ids := []int64{}
+	err = m.Export(context.Background(), data.ToyFilter{Manufacturer: "Brio"}, func(toy *data.Toy) error {
+		ids = append(ids, toy.ID)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{7, 9}, ids)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,108 @@
+package unit
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/validator"
+)
+
+var toyListColumns = []string{"count", "id", "created_at", "title", "desc", "details", "skills", "categories", "recommended_age", "manufacturer", "value", "is_available", "wait_list", "language", "version", "updated_at", "attributes", "rating", "rating_count", "relevance", "title_headline", "desc_headline"}
+
+func TestGetAllWithoutFilters(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`FROM toys\s+WHERE deleted_at IS NULL\s+ORDER BY id ASC\s+LIMIT \$1 OFFSET \$2`).
+		WithArgs(24, 0).
+		WillReturnRows(sqlmock.NewRows(toyListColumns))
+
+	m := data.ToyModel{DB: db}
+	filters := data.Filters{Page: 1, PageSize: 24, Sort: "id", SortSafeList: []string{"id"}}
+
+	toys, _, err := m.GetAll(data.ToyFilter{}, filters)
+	assert.NoError(t, err)
+	assert.Empty(t, toys)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAllCombinesFilters(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	createdAfter := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Every filter gets its own numbered placeholder, in the order the filters are
+	// applied, and the recommended age is no longer mixed up with the categories.
+	mock.ExpectQuery(`WHERE deleted_at IS NULL
+AND search @@ q.query
+AND EXISTS \( SELECT 1 FROM toy_categories .+ WHERE slug = ANY\(\$2\) .+ \)
+AND NOT EXISTS \( SELECT 1 FROM toy_categories .+ WHERE slug = ANY\(\$3\) .+ \)
+AND recommended_age = \$4
+AND lower\(manufacturer\) = lower\(\$5\)
+AND value >= \$6
+AND value <= \$7
+AND is_available
+AND created_at > \$8
+ORDER BY .+ LIMIT \$9 OFFSET \$10`).
+		WithArgs("train", sqlmock.AnyArg(), sqlmock.AnyArg(), "3+", "Brio", int64(1000), int64(5000), createdAfter, 10, 10).
+		WillReturnRows(sqlmock.NewRows(toyListColumns))
+
+	m := data.ToyModel{DB: db}
+	filters := data.Filters{Page: 2, PageSize: 10, Sort: "relevance", SortSafeList: []string{"relevance"}}
+	filter := data.ToyFilter{
+		Title:             "train",
+		Categories:        []string{"vehicles"},
+		ExcludeCategories: []string{"electronics"},
+		RecommendedAge:    "3+",
+		Manufacturer:      "Brio",
+		MinValue:          1000,
+		MaxValue:          5000,
+		AvailableOnly:     true,
+		CreatedAfter:      createdAfter,
+	}
+
+	_, _, err = m.GetAll(filter, filters)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAllKeepsInjectionOutOfQuery(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+		assert.NotContains(t, actualSQL, "DROP TABLE")
+		return nil
+	})))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	manufacturer := "x'); DROP TABLE toys; --"
+	mock.ExpectQuery(``).
+		WithArgs(manufacturer, 24, 0).
+		WillReturnRows(sqlmock.NewRows(toyListColumns))
+
+	m := data.ToyModel{DB: db}
+	filters := data.Filters{Page: 1, PageSize: 24, Sort: "id", SortSafeList: []string{"id"}}
+
+	_, _, err = m.GetAll(data.ToyFilter{Manufacturer: manufacturer}, filters)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestValidateToyFilter(t *testing.T) {
+	v := validator.New()
+	data.ValidateToyFilter(v, data.ToyFilter{MinValue: 5000, MaxValue: 1000})
+	assert.Contains(t, v.Errors, "max_value")
+
+	v = validator.New()
+	data.ValidateToyFilter(v, data.ToyFilter{MinValue: -1})
+	assert.Contains(t, v.Errors, "min_value")
+
+	// A minimum on its own is fine.
+	v = validator.New()
+	data.ValidateToyFilter(v, data.ToyFilter{MinValue: 5000})
+	assert.True(t, v.Valid())
+}
@@ -0,0 +1,465 @@
+package unit
+
+import (
+	"context"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/storage"
+	"toy-rental-system/internal/validator"
+	"toy-rental-system/pkg/jsonlog"
+	"toy-rental-system/serviceToy"
+)
+
+// fakeToyRepository keeps the toys in memory. Methods the handlers under test don't
+// call are left to the embedded nil interface.
+type fakeToyRepository struct {
+	data.ToyRepository
+	toys map[int64]*data.Toy
+	// trash holds the deleted toys.
+	trash map[int64]*data.Toy
+	// missingTerms are the categories and skills which aren't in the taxonomy, and
+	// missingManufacturers the manufacturers which don't exist. All others do.
+	missingTerms         map[string]bool
+	missingManufacturers map[string]bool
+	// schemas are the attribute schemas of the categories, keyed by slug.
+	schemas map[string]data.AttributeSchema
+}
+
+func (f *fakeToyRepository) Get(id int64) (*data.Toy, error) {
+	toy, ok := f.toys[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	copied := *toy
+	return &copied, nil
+}
+
+func (f *fakeToyRepository) UpdateAudited(toy *data.Toy, actor audit.Actor) error {
+	if unknown, _ := f.UnknownTerms(data.TaxonomyCategories, toy.Categories); len(unknown) > 0 {
+		return &data.UnknownTermsError{Kind: data.TaxonomyCategories, Values: unknown}
+	}
+	if f.missingManufacturers[toy.Manufacturer] {
+		return &data.UnknownManufacturerError{Name: toy.Manufacturer}
+	}
+	if len(toy.Attributes) > 0 {
+		schemas, _ := f.AttributeSchemas(toy.Categories)
+		schema := data.AttributeSchema{}
+		for _, category := range toy.Categories {
+			schema = schema.Merge(schemas[data.Slugify(category)])
+		}
+		v := validator.New()
+		if data.ValidateAttributes(v, schema, toy.Attributes); !v.Valid() {
+			return &data.AttributeError{Errors: v.Errors}
+		}
+	}
+	if f.toys[toy.ID].Version != toy.Version {
+		return data.ErrEditConflict
+	}
+	toy.Version++
+	toy.UpdatedAt = time.Now()
+	copied := *toy
+	f.toys[toy.ID] = &copied
+	return nil
+}
+
+func (f *fakeToyRepository) DeleteAudited(id int64, version int32, actor audit.Actor) error {
+	toy, ok := f.toys[id]
+	if !ok {
+		return data.ErrRecordNotFound
+	}
+	if version != 0 && toy.Version != version {
+		return data.ErrEditConflict
+	}
+	if f.trash == nil {
+		f.trash = map[int64]*data.Toy{}
+	}
+	now := time.Now()
+	toy.DeletedAt = &now
+	f.trash[id] = toy
+	delete(f.toys, id)
+	return nil
+}
+
+func (f *fakeToyRepository) RestoreAudited(id int64, actor audit.Actor) (*data.Toy, error) {
+	toy, ok := f.trash[id]
+	if !ok {
+		return nil, data.ErrRecordNotFound
+	}
+	toy.DeletedAt = nil
+	toy.Version++
+	f.toys[id] = toy
+	delete(f.trash, id)
+	copied := *toy
+	return &copied, nil
+}
+
+func (f *fakeToyRepository) GetDeleted(filters data.Filters) ([]*data.Toy, data.Metadata, error) {
+	toys := []*data.Toy{}
+	for _, toy := range f.trash {
+		toys = append(toys, toy)
+	}
+	return toys, data.Metadata{TotalRecords: len(toys)}, nil
+}
+
+func (f *fakeToyRepository) ExistingSupplierSKUs(skus []string) (map[string]bool, map[string]bool, error) {
+	existing, trashed := map[string]bool{}, map[string]bool{}
+	for _, sku := range skus {
+		for _, toy := range f.toys {
+			if toy.SupplierSKU == sku {
+				existing[sku] = true
+			}
+		}
+		for _, toy := range f.trash {
+			if toy.SupplierSKU == sku {
+				existing[sku], trashed[sku] = true, true
+			}
+		}
+	}
+	return existing, trashed, nil
+}
+
+func (f *fakeToyRepository) UnknownTerms(kind data.TaxonomyKind, values []string) ([]string, error) {
+	unknown := []string{}
+	for _, value := range values {
+		if f.missingTerms[value] {
+			unknown = append(unknown, value)
+		}
+	}
+	return unknown, nil
+}
+
+func (f *fakeToyRepository) UnknownManufacturers(names []string) ([]string, error) {
+	unknown := []string{}
+	for _, name := range names {
+		if f.missingManufacturers[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown, nil
+}
+
+func (f *fakeToyRepository) AttributeSchemas(categories []string) (map[string]data.AttributeSchema, error) {
+	schemas := map[string]data.AttributeSchema{}
+	for _, category := range categories {
+		if schema, ok := f.schemas[data.Slugify(category)]; ok {
+			schemas[data.Slugify(category)] = schema
+		}
+	}
+	return schemas, nil
+}
+
+func (f *fakeToyRepository) Import(toys []*data.Toy, upsert bool, actor audit.Actor) (int, int, error) {
+	created, updated := 0, 0
+	for _, toy := range toys {
+		toy.ID = int64(len(f.toys) + 1)
+		for _, existing := range f.toys {
+			if toy.SupplierSKU != "" && existing.SupplierSKU == toy.SupplierSKU {
+				if !upsert {
+					return 0, 0, data.ErrDuplicateSupplierSKU
+				}
+				toy.ID = existing.ID
+			}
+		}
+		if _, ok := f.toys[toy.ID]; ok {
+			updated++
+		} else {
+			created++
+		}
+		copied := *toy
+		f.toys[toy.ID] = &copied
+	}
+	return created, updated, nil
+}
+
+// Export only honours the manufacturer of the filter.
+func (f *fakeToyRepository) Export(ctx context.Context, filter data.ToyFilter, fn func(toy *data.Toy) error) error {
+	for id := int64(1); id <= int64(len(f.toys)); id++ {
+		toy, ok := f.toys[id]
+		if !ok || filter.Manufacturer != "" && toy.Manufacturer != filter.Manufacturer {
+			continue
+		}
+		copied := *toy
+		err := fn(&copied)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fakeToyImageRepository keeps the images in memory, in insertion order.
+type fakeToyImageRepository struct {
+	images []*data.ToyImage
+	// retries records the messages of the failed thumbnail attempts by image ID.
+	retries map[int64][]string
+}
+
+func (f *fakeToyImageRepository) InsertAudited(image *data.ToyImage, actor audit.Actor) error {
+	image.ID = int64(len(f.images) + 1)
+	image.Position = len(f.images)
+	image.IsPrimary = image.IsPrimary || len(f.images) == 0
+	f.images = append(f.images, image)
+	return nil
+}
+
+func (f *fakeToyImageRepository) GetAllForToy(toyID int64) ([]*data.ToyImage, error) {
+	images := []*data.ToyImage{}
+	for _, image := range f.images {
+		if image.ToyID == toyID {
+			copied := *image
+			images = append(images, &copied)
+		}
+	}
+	return images, nil
+}
+
+func (f *fakeToyImageRepository) ArrangeAudited(toyID int64, order []int64, primaryID int64, actor audit.Actor) error {
+	for _, image := range f.images {
+		for i, id := range order {
+			if image.ID == id {
+				image.Position = i
+			}
+		}
+		if primaryID != 0 {
+			image.IsPrimary = image.ID == primaryID
+		}
+	}
+	return nil
+}
+
+func (f *fakeToyImageRepository) DeleteAudited(toyID, id int64, actor audit.Actor) (*data.ToyImage, error) {
+	for i, image := range f.images {
+		if image.ToyID == toyID && image.ID == id {
+			f.images = append(f.images[:i], f.images[i+1:]...)
+			return image, nil
+		}
+	}
+	return nil, data.ErrRecordNotFound
+}
+
+func (f *fakeToyImageRepository) ClaimPendingThumbnails(limit int, lease time.Duration) ([]*data.ToyImage, error) {
+	images := []*data.ToyImage{}
+	for _, image := range f.images {
+		if image.ThumbnailStatus == data.ThumbnailsPending && len(images) < limit {
+			copied := *image
+			images = append(images, &copied)
+		}
+	}
+	return images, nil
+}
+
+func (f *fakeToyImageRepository) CompleteThumbnails(id int64, width, height int, variants []*data.ToyImageVariant) error {
+	for _, image := range f.images {
+		if image.ID == id {
+			image.Width, image.Height, image.Variants = width, height, variants
+			image.ThumbnailStatus = data.ThumbnailsDone
+			return nil
+		}
+	}
+	return data.ErrRecordNotFound
+}
+
+func (f *fakeToyImageRepository) RetryThumbnails(id int64, message string, maxAttempts int) error {
+	if f.retries == nil {
+		f.retries = map[int64][]string{}
+	}
+	f.retries[id] = append(f.retries[id], message)
+	return nil
+}
+
+// maxTestImageSize is the upload limit of the toy service in tests.
+const maxTestImageSize = 1024
+
+type testToyService struct {
+	serviceToy.ToyService
+	toys   *fakeToyRepository
+	images *fakeToyImageRepository
+	store  *storage.Local
+}
+
+func newTestToyService(t *testing.T) testToyService {
+	repo := &fakeToyRepository{toys: map[int64]*data.Toy{
+		1: {ID: 1, Title: "Lego", Skills: []string{"motor"}, Categories: []string{"STEM"}, RecommendedAge: "3+", Manufacturer: "Lego", Value: 5000, Language: "english", Version: 2, UpdatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+	}}
+	images := &fakeToyImageRepository{}
+
+	store, err := storage.NewLocal(t.TempDir(), "/images")
+	assert.NoError(t, err)
+
+	return testToyService{
+		ToyService: serviceToy.NewToyService(repo, images, store, maxTestImageSize, jsonlog.New(io.Discard, jsonlog.LevelError)),
+		toys:       repo,
+		images:     images,
+		store:      store,
+	}
+}
+
+func toyRequest(method, body string, header http.Header) *http.Request {
+	r := httptest.NewRequest(method, "/toy/1", strings.NewReader(body))
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	ctx := context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "id", Value: "1"}})
+	return r.WithContext(ctx)
+}
+
+func TestShowToyConditionalGet(t *testing.T) {
+	service := newTestToyService(t)
+
+	w := httptest.NewRecorder()
+	service.ShowToyHandler(w, toyRequest(http.MethodGet, "", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"toy-1-v2-r0"`, w.Header().Get("ETag"))
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", w.Header().Get("Last-Modified"))
+
+	w = httptest.NewRecorder()
+	service.ShowToyHandler(w, toyRequest(http.MethodGet, "", http.Header{"If-None-Match": {`"other", W/"toy-1-v2-r0"`}}))
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = httptest.NewRecorder()
+	service.ShowToyHandler(w, toyRequest(http.MethodGet, "", http.Header{"If-Modified-Since": {"Wed, 01 May 2024 12:00:00 GMT"}}))
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = httptest.NewRecorder()
+	service.ShowToyHandler(w, toyRequest(http.MethodGet, "", http.Header{"If-None-Match": {`"toy-1-v1"`}}))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestUpdateToyIfMatch(t *testing.T) {
+	service := newTestToyService(t)
+	repo := service.toys
+
+	w := httptest.NewRecorder()
+	service.UpdateToyHandler(w, toyRequest(http.MethodPatch, `{"title": "Lego Duplo"}`, http.Header{"If-Match": {`"toy-1-v1"`}}))
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, "Lego", repo.toys[1].Title)
+
+	// A weak tag never matches If-Match.
+	w = httptest.NewRecorder()
+	service.UpdateToyHandler(w, toyRequest(http.MethodPatch, `{"title": "Lego Duplo"}`, http.Header{"If-Match": {`W/"toy-1-v2"`}}))
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = httptest.NewRecorder()
+	service.UpdateToyHandler(w, toyRequest(http.MethodPatch, `{"title": "Lego Duplo"}`, http.Header{"If-Match": {`"toy-1-v2-r0"`}}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"toy-1-v3-r0"`, w.Header().Get("ETag"))
+	assert.Equal(t, "Lego Duplo", repo.toys[1].Title)
+}
+
+func TestUpdateToyStaleVersion(t *testing.T) {
+	service := newTestToyService(t)
+	repo := service.toys
+
+	w := httptest.NewRecorder()
+	service.UpdateToyHandler(w, toyRequest(http.MethodPatch, `{"version": 1, "title": "Lego Duplo"}`, nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "Lego", repo.toys[1].Title)
+}
+
+func TestDeleteToyIfMatch(t *testing.T) {
+	service := newTestToyService(t)
+	repo := service.toys
+
+	w := httptest.NewRecorder()
+	service.DeleteToyHandler(w, toyRequest(http.MethodDelete, "", http.Header{"If-Match": {`"toy-1-v1"`}}))
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Contains(t, repo.toys, int64(1))
+
+	w = httptest.NewRecorder()
+	service.DeleteToyHandler(w, toyRequest(http.MethodDelete, "", http.Header{"If-Match": {"*"}}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, repo.toys, int64(1))
+}
+
+func TestUpdateToyWithMergePatch(t *testing.T) {
+	service := newTestToyService(t)
+	repo := service.toys
+
+	header := http.Header{"Content-Type": {"application/merge-patch+json"}}
+	w := httptest.NewRecorder()
+	service.UpdateToyHandler(w, toyRequest(http.MethodPatch, `{"manufacturer": "Lego Group", "details": ["1 brick"]}`, header))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Lego Group", repo.toys[1].Manufacturer)
+	assert.Equal(t, []string{"1 brick"}, repo.toys[1].Details)
+
+	// The patched toy still has to be valid.
+	w = httptest.NewRecorder()
+	service.UpdateToyHandler(w, toyRequest(http.MethodPatch, `{"skills": null}`, header))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, []string{"motor"}, repo.toys[1].Skills)
+}
+
+func TestUpdateToyWithJSONPatch(t *testing.T) {
+	service := newTestToyService(t)
+	repo := service.toys
+
+	header := http.Header{"Content-Type": {"application/json-patch+json"}}
+	patch := `[
+		{"op": "test", "path": "/skills/0", "value": "motor"},
+		{"op": "add", "path": "/skills/-", "value": "logic"},
+		{"op": "remove", "path": "/skills/0"},
+		{"op": "add", "path": "/image/-", "value": "http://example.com/lego.jpg"}
+	]`
+
+	w := httptest.NewRecorder()
+	service.UpdateToyHandler(w, toyRequest(http.MethodPatch, patch, header))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"logic"}, repo.toys[1].Skills)
+	assert.Equal(t, []string{"http://example.com/lego.jpg"}, repo.toys[1].Images)
+
+	// A failed test leaves the toy unchanged.
+	w = httptest.NewRecorder()
+	service.UpdateToyHandler(w, toyRequest(http.MethodPatch, `[{"op": "test", "path": "/title", "value": "Duplo"}, {"op": "remove", "path": "/skills/0"}]`, header))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, []string{"logic"}, repo.toys[1].Skills)
+
+	w = httptest.NewRecorder()
+	service.UpdateToyHandler(w, toyRequest(http.MethodPatch, `[{"op": "replace", "path": "/id", "value": 2}]`, header))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestUpdateToyWithJSONPatchOnReadOnlyPaths(t *testing.T) {
+	service := newTestToyService(t)
+	repo := service.toys
+
+	header := http.Header{"Content-Type": {"application/json-patch+json"}}
+	tests := []struct {
+		name  string
+		patch string
+		field string
+	}{
+		{name: "deleted_at", patch: `[{"op": "add", "path": "/deleted_at", "value": "2024-01-01T00:00:00Z"}]`, field: "deleted_at"},
+		{name: "photos", patch: `[{"op": "add", "path": "/photos", "value": [{"id": 1}]}]`, field: "photos"},
+		{name: "supplier_sku", patch: `[{"op": "add", "path": "/supplier_sku", "value": "SKU-1"}]`, field: "supplier_sku"},
+		{name: "manufacturer_id", patch: `[{"op": "add", "path": "/manufacturer_id", "value": 3}]`, field: "manufacturer_id"},
+		{name: "isAvailable", patch: `[{"op": "replace", "path": "/isAvailable", "value": true}]`, field: "isAvailable"},
+		{name: "waitList", patch: `[{"op": "add", "path": "/waitList", "value": ["aigerim"]}]`, field: "waitList"},
+		{name: "removed updated_at", patch: `[{"op": "remove", "path": "/updated_at"}]`, field: "updated_at"},
+		{name: "unknown path", patch: `[{"op": "add", "path": "/price", "value": 10}]`, field: "price"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			service.UpdateToyHandler(w, toyRequest(http.MethodPatch, tt.patch, header))
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+			assert.Contains(t, w.Body.String(), `"`+tt.field+`"`)
+			assert.Equal(t, int32(2), repo.toys[1].Version)
+		})
+	}
+
+	// Testing a read-only field doesn't change it, so it's allowed.
+	w := httptest.NewRecorder()
+	service.UpdateToyHandler(w, toyRequest(http.MethodPatch, `[{"op": "test", "path": "/id", "value": 1}, {"op": "replace", "path": "/title", "value": "Duplo"}]`, header))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Duplo", repo.toys[1].Title)
+}
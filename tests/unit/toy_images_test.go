@@ -0,0 +1,191 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/storage"
+)
+
+func testPNG(t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func uploadRequest(t *testing.T, content []byte, fields map[string]string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for key, value := range fields {
+		assert.NoError(t, mw.WriteField(key, value))
+	}
+	if content != nil {
+		fw, err := mw.CreateFormFile("image", "photo.bin")
+		assert.NoError(t, err)
+		_, err = fw.Write(content)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, mw.Close())
+
+	r := toyRequest(http.MethodPost, "", http.Header{"Content-Type": {mw.FormDataContentType()}})
+	r.Body = io.NopCloser(&body)
+	return r
+}
+
+func TestUploadToyImage(t *testing.T) {
+	service := newTestToyService(t)
+
+	w := httptest.NewRecorder()
+	service.UploadToyImageHandler(w, uploadRequest(t, testPNG(t), nil))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"content_type": "image/png"`)
+
+	// The type is sniffed from the content and decides the extension.
+	assert.Len(t, service.images.images, 1)
+	stored := service.images.images[0]
+	assert.True(t, stored.IsPrimary)
+	assert.True(t, strings.HasPrefix(stored.Key, "toys/1/"))
+	assert.True(t, strings.HasSuffix(stored.Key, ".png"))
+
+	f, err := service.store.Get(context.Background(), stored.Key)
+	assert.NoError(t, err)
+	content, _ := io.ReadAll(f)
+	f.Close()
+	assert.Equal(t, testPNG(t), content)
+
+	// A second image can take over as the primary image.
+	w = httptest.NewRecorder()
+	service.UploadToyImageHandler(w, uploadRequest(t, testPNG(t), map[string]string{"primary": "true"}))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.True(t, service.images.images[1].IsPrimary)
+}
+
+func TestUploadToyImageRejectsBadFiles(t *testing.T) {
+	service := newTestToyService(t)
+
+	w := httptest.NewRecorder()
+	service.UploadToyImageHandler(w, uploadRequest(t, []byte("<html><script>alert(1)</script></html>"), nil))
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	w = httptest.NewRecorder()
+	service.UploadToyImageHandler(w, uploadRequest(t, append(testPNG(t), make([]byte, maxTestImageSize)...), nil))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	w = httptest.NewRecorder()
+	service.UploadToyImageHandler(w, uploadRequest(t, nil, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	assert.Empty(t, service.images.images)
+}
+
+func TestArrangeAndDeleteToyImages(t *testing.T) {
+	service := newTestToyService(t)
+
+	for i := 0; i < 3; i++ {
+		service.UploadToyImageHandler(httptest.NewRecorder(), uploadRequest(t, testPNG(t), nil))
+	}
+
+	// The order has to list every image.
+	w := httptest.NewRecorder()
+	service.ArrangeToyImagesHandler(w, toyRequest(http.MethodPatch, `{"order": [3, 1]}`, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = httptest.NewRecorder()
+	service.ArrangeToyImagesHandler(w, toyRequest(http.MethodPatch, `{"order": [3, 1, 2], "primary": 3}`, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 0, service.images.images[2].Position)
+	assert.True(t, service.images.images[2].IsPrimary)
+
+	key := service.images.images[0].Key
+
+	r := toyRequest(http.MethodDelete, "", nil)
+	params := append(httprouter.ParamsFromContext(r.Context()), httprouter.Param{Key: "image_id", Value: "1"})
+	w = httptest.NewRecorder()
+	service.DeleteToyImageHandler(w, r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params)))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	_, err := service.store.Get(context.Background(), key)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+// The images of a deleted toy are kept, so that the toy can be restored from the
+// trash.
+func TestDeleteToyKeepsImageFiles(t *testing.T) {
+	service := newTestToyService(t)
+
+	service.UploadToyImageHandler(httptest.NewRecorder(), uploadRequest(t, testPNG(t), nil))
+	key := service.images.images[0].Key
+
+	w := httptest.NewRecorder()
+	service.DeleteToyHandler(w, toyRequest(http.MethodDelete, "", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	f, err := service.store.Get(context.Background(), key)
+	assert.NoError(t, err)
+	f.Close()
+}
+
+func TestLocalStorage(t *testing.T) {
+	store, err := storage.NewLocal(t.TempDir(), "/images")
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, store.Put(ctx, "toys/1/a.png", bytes.NewReader(testPNG(t))))
+	assert.ErrorIs(t, store.Put(ctx, "../escape.png", bytes.NewReader(nil)), storage.ErrInvalidKey)
+	assert.Equal(t, "/images/toys/1/a.png", store.URL("toys/1/a.png"))
+
+	w := httptest.NewRecorder()
+	store.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/images/toys/1/a.png", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+
+	// Directories are never listed.
+	w = httptest.NewRecorder()
+	store.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/images/toys/1/", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	assert.NoError(t, store.Delete(ctx, "toys/1/a.png"))
+	assert.NoError(t, store.Delete(ctx, "toys/1/a.png"))
+}
+
+func TestInsertToyImage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE toys SET version = version \+ 1`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO toy_images .+ COALESCE\(max\(position\) \+ 1, 0\), \$5 OR count\(\*\) = 0, \$6`).
+		WithArgs(1, "toys/1/a.png", "image/png", 100, false, data.ThumbnailsPending).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "position", "is_primary", "created_at"}).AddRow(5, 0, true, time.Now()))
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(7, nil, "toy_image.create", "toy_image", "5", nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	m := data.ToyImageModel{DB: db}
+	img := &data.ToyImage{ToyID: 1, Key: "toys/1/a.png", ContentType: "image/png", Size: 100}
+
+	assert.NoError(t, m.InsertAudited(img, audit.Actor{UserID: 7}))
+	assert.True(t, img.IsPrimary)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
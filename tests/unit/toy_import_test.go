@@ -0,0 +1,206 @@
+package unit
+
+import (
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+)
+
+type importResponse struct {
+	Import struct {
+		DryRun  bool `json:"dry_run"`
+		Rows    int  `json:"rows"`
+		Created int  `json:"created"`
+		Updated int  `json:"updated"`
+		Failed  int  `json:"failed"`
+		Errors  []struct {
+			Row    int               `json:"row"`
+			Errors map[string]string `json:"errors"`
+		} `json:"errors"`
+	} `json:"import"`
+}
+
+func importRequest(t *testing.T, service testToyService, query, contentType, body string) (int, importResponse) {
+	r := httptest.NewRequest(http.MethodPost, "/admin/toys/import"+query, strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+
+	w := httptest.NewRecorder()
+	service.ImportToysHandler(w, r)
+
+	var response importResponse
+	if w.Code == http.StatusOK {
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	}
+	return w.Code, response
+}
+
+const importCSV = `supplier_sku,title,skills,categories,recommended_age,manufacturer,value
+BR-1,Wooden train,motor|logic,vehicles,3+,Brio,12000
+BR-2,,motor,vehicles,3+,Brio,12000
+BR-3,Crane,motor,vehicles,3+,Brio,lots
+BR-4,Bridge,motor,vehicles
+BR-1,Wooden train again,motor,vehicles,3+,Brio,12000
+`
+
+func TestImportToysCSV(t *testing.T) {
+	service := newTestToyService(t)
+
+	code, response := importRequest(t, service, "", "text/csv; charset=utf-8", importCSV)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 5, response.Import.Rows)
+	assert.Equal(t, 1, response.Import.Created)
+	assert.Equal(t, 4, response.Import.Failed)
+
+	errors := response.Import.Errors
+	assert.Equal(t, 3, errors[0].Row)
+	assert.Contains(t, errors[0].Errors, "title")
+	assert.Equal(t, "must be an integer value", errors[1].Errors["value"])
+	assert.Contains(t, errors[2].Errors, "row")
+	assert.Equal(t, "duplicates the supplier_sku of row 2", errors[3].Errors["supplier_sku"])
+
+	assert.Len(t, service.toys.toys, 2)
+	toy := service.toys.toys[2]
+	assert.Equal(t, "BR-1", toy.SupplierSKU)
+	assert.Equal(t, []string{"motor", "logic"}, toy.Skills)
+	assert.Equal(t, data.DefaultSearchLanguage, toy.Language)
+	assert.True(t, toy.IsAvailable)
+}
+
+func TestImportToysDryRun(t *testing.T) {
+	service := newTestToyService(t)
+
+	code, response := importRequest(t, service, "?dry_run=true", "text/csv", importCSV)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, response.Import.DryRun)
+	assert.Equal(t, 1, response.Import.Created)
+	assert.Len(t, service.toys.toys, 1)
+}
+
+func TestImportToysUpsert(t *testing.T) {
+	service := newTestToyService(t)
+	service.toys.toys[1].SupplierSKU = "LEGO-1"
+
+	body := `{"supplier_sku": "LEGO-1", "title": "Lego Classic", "skills": ["motor"], "categories": ["STEM"], "recommended_age": "4+", "manufacturer": "Lego", "value": 7000}
+
+{"supplier_sku": "LEGO-2", "title": "Lego City", "skills": ["motor"], "categories": ["STEM"], "recommended_age": "5+", "manufacturer": "Lego", "value": 9000, "is_available": false}
+{"title": "Lego Friends", "colour": "pink"}
+{"title": 7}
+`
+
+	code, response := importRequest(t, service, "", "application/x-ndjson", body)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, response.Import.Created)
+	assert.Equal(t, 0, response.Import.Updated)
+	assert.Equal(t, 3, response.Import.Failed)
+	assert.Equal(t, "a toy with this supplier_sku already exists", response.Import.Errors[0].Errors["supplier_sku"])
+	assert.Equal(t, 4, response.Import.Errors[1].Row)
+	assert.Equal(t, `contains unknown key "colour"`, response.Import.Errors[1].Errors["row"])
+	assert.Equal(t, "has an incorrect JSON type", response.Import.Errors[2].Errors["title"])
+	assert.False(t, service.toys.toys[2].IsAvailable)
+
+	code, response = importRequest(t, service, "?upsert=true", "application/x-ndjson", body)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, response.Import.Updated)
+	assert.Equal(t, "Lego Classic", service.toys.toys[1].Title)
+	assert.Len(t, service.toys.toys, 2)
+}
+
+func TestImportToysSkipsTrashedToys(t *testing.T) {
+	service := newTestToyService(t)
+	service.toys.toys[1].SupplierSKU = "LEGO-1"
+	service.toys.DeleteAudited(1, 0, audit.Actor{UserID: 2})
+
+	body := `{"supplier_sku": "LEGO-1", "title": "Lego Classic", "skills": ["motor"], "categories": ["STEM"], "recommended_age": "4+", "manufacturer": "Lego", "value": 7000}
+`
+
+	code, response := importRequest(t, service, "?upsert=true", "application/x-ndjson", body)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 0, response.Import.Updated)
+	assert.Equal(t, 1, response.Import.Failed)
+	assert.Equal(t, "belongs to a deleted toy, which must be restored first", response.Import.Errors[0].Errors["supplier_sku"])
+	assert.NotEqual(t, "Lego Classic", service.toys.trash[1].Title)
+}
+
+func TestImportToysRejectsBadFiles(t *testing.T) {
+	service := newTestToyService(t)
+
+	code, _ := importRequest(t, service, "", "application/json", `{}`)
+	assert.Equal(t, http.StatusUnsupportedMediaType, code)
+
+	code, _ = importRequest(t, service, "", "text/csv", "title,colour\nLego,red\n")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = importRequest(t, service, "", "text/csv", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestImportToysBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	toys := []*data.Toy{
+		{SupplierSKU: "BR-1", Title: "Wooden train", Skills: []string{"motor"}, Categories: []string{"vehicles"}, RecommendedAge: "3+", Manufacturer: "Brio", Value: 12000, Language: "english"},
+		{SupplierSKU: "BR-2", Title: "Crane", Skills: []string{"motor"}, Categories: []string{"vehicles"}, RecommendedAge: "3+", Manufacturer: "Brio", Value: 9000, Language: "english"},
+	}
+
+	mock.ExpectBegin()
+	expectTermLookup(mock, "categories", "vehicles", "Vehicles")
+	expectTermLookup(mock, "skills", "motor", "Motor skills")
+	expectManufacturerLookup(mock, 6, "BRIO")
+	mock.ExpectQuery(`INSERT INTO toys .+ VALUES \(NULLIF\(\$1, ''\), .+ \$14, '\{\}'\),\s+\(NULLIF\(\$15, ''\), .+ ON CONFLICT \(supplier_sku\) DO UPDATE .+ RETURNING id, created_at, version, updated_at, xmax = 0`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "version", "updated_at", "inserted"}).
+			AddRow(8, time.Now(), 1, time.Now(), true).
+			AddRow(3, time.Now(), 4, time.Now(), false))
+	expectTermLinks(mock, "toy_categories", "category_id")
+	expectTermLinks(mock, "toy_skills", "skill_id")
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(sqlmock.AnyArg(), nil, "toy.import", "toy", "", nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	m := data.ToyModel{DB: db}
+	created, updated, err := m.Import(toys, true, audit.Actor{UserID: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, created)
+	assert.Equal(t, 1, updated)
+	assert.Equal(t, int64(3), toys[1].ID)
+	assert.Equal(t, int32(4), toys[1].Version)
+	assert.Equal(t, []string{"Vehicles"}, toys[0].Categories)
+	assert.Equal(t, []string{"Motor skills"}, toys[1].Skills)
+	assert.Equal(t, "BRIO", toys[0].Manufacturer)
+	assert.Equal(t, int64(6), toys[1].ManufacturerID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportToysRefusesTrashedToy(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	toys := []*data.Toy{
+		{SupplierSKU: "BR-1", Title: "Wooden train", Skills: []string{"motor"}, Categories: []string{"vehicles"}, RecommendedAge: "3+", Manufacturer: "Brio", Value: 12000, Language: "english"},
+	}
+
+	// The toy was moved to the trash after the import was validated, so the upsert
+	// skips it and returns no row.
+	mock.ExpectBegin()
+	expectTermLookup(mock, "categories", "vehicles", "Vehicles")
+	expectTermLookup(mock, "skills", "motor", "Motor skills")
+	expectManufacturerLookup(mock, 6, "BRIO")
+	mock.ExpectQuery(`INSERT INTO toys .+ ON CONFLICT \(supplier_sku\) DO UPDATE .+ WHERE toys.deleted_at IS NULL RETURNING`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "version", "updated_at", "inserted"}))
+	mock.ExpectRollback()
+
+	m := data.ToyModel{DB: db}
+	_, _, err = m.Import(toys, true, audit.Actor{UserID: 2})
+	assert.ErrorIs(t, err, data.ErrTrashedSupplierSKU)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,128 @@
+package unit
+
+import (
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+)
+
+func TestDeleteAndRestoreToy(t *testing.T) {
+	service := newTestToyService(t)
+
+	w := httptest.NewRecorder()
+	service.DeleteToyHandler(w, toyRequest(http.MethodDelete, "", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	service.ShowToyHandler(w, toyRequest(http.MethodGet, "", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	service.ListDeletedToysHandler(w, httptest.NewRequest(http.MethodGet, "/admin/toys/trash", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var trash struct {
+		Toys []map[string]any `json:"toys"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&trash))
+	assert.Len(t, trash.Toys, 1)
+	assert.NotEmpty(t, trash.Toys[0]["deleted_at"])
+
+	w = httptest.NewRecorder()
+	service.RestoreToyHandler(w, toyRequest(http.MethodPost, "", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"toy-1-v3-r0"`, w.Header().Get("ETag"))
+
+	w = httptest.NewRecorder()
+	service.ShowToyHandler(w, toyRequest(http.MethodGet, "", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// A toy which isn't in the trash can't be restored.
+	w = httptest.NewRecorder()
+	service.RestoreToyHandler(w, toyRequest(http.MethodPost, "", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestListDeletedToysValidation(t *testing.T) {
+	service := newTestToyService(t)
+
+	w := httptest.NewRecorder()
+	service.ListDeletedToysHandler(w, httptest.NewRequest(http.MethodGet, "/admin/toys/trash?sort=title", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestRestoreToyAudited(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE toys SET deleted_at = NULL, version = version \+ 1, updated_at = now\(\) WHERE id = \$1 AND deleted_at IS NOT NULL RETURNING id`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`SELECT .+ FROM toys WHERE id = \$1 AND deleted_at IS NULL`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(toyColumns).AddRow(1, time.Now(), "Lego", "", pq.StringArray{}, pq.StringArray{"motor"}, pq.StringArray{"STEM"}, pq.StringArray{}, "3+", "Lego", 5000, true, pq.StringArray{}, "english", 3, time.Now(), "", 1, []byte("{}"), 0, 0))
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(sqlmock.AnyArg(), nil, "toy.restore", "toy", "1", nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	m := data.ToyModel{DB: db}
+	toy, err := m.RestoreAudited(1, audit.Actor{UserID: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), toy.Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE toys SET deleted_at = NULL`).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	_, err = m.RestoreAudited(2, audit.Actor{UserID: 2})
+	assert.ErrorIs(t, err, data.ErrRecordNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPurgeDeletedToys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	cutoff := time.Now().Add(-30 * 24 * time.Hour)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM toys t WHERE deleted_at < \$1 AND NOT EXISTS \(SELECT 1 FROM toy_returns r WHERE r.toy_id = t.id\) AND NOT EXISTS \(SELECT 1 FROM toy_reviews v WHERE v.toy_id = t.id\) ORDER BY deleted_at, id LIMIT \$2 FOR UPDATE SKIP LOCKED`).WithArgs(cutoff, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(5))
+	mock.ExpectQuery(`SELECT storage_key FROM toy_images WHERE toy_id = ANY\(\$1\) UNION ALL SELECT v.storage_key`).
+		WillReturnRows(sqlmock.NewRows([]string{"storage_key"}).AddRow("toys/3/a.png").AddRow("toys/3/a_160w.png"))
+	mock.ExpectExec(`DELETE FROM toys WHERE id = ANY\(\$1\)`).WillReturnResult(sqlmock.NewResult(0, 2))
+	for _, id := range []string{"3", "5"} {
+		mock.ExpectQuery(`INSERT INTO audit_events`).
+			WithArgs(nil, nil, "toy.purge", "toy", id, nil, nil, sqlmock.AnyArg(), "", "").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	}
+	mock.ExpectCommit()
+
+	m := data.ToyModel{DB: db}
+	purged, keys, err := m.PurgeDeleted(cutoff, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.Equal(t, []string{"toys/3/a.png", "toys/3/a_160w.png"}, keys)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Nothing is deleted while the trash has no expired toys.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM toys t WHERE deleted_at < \$1`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	purged, keys, err = m.PurgeDeleted(cutoff, 100)
+	assert.NoError(t, err)
+	assert.Zero(t, purged)
+	assert.Empty(t, keys)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
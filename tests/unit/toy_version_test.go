@@ -0,0 +1,74 @@
+package unit
+
+import (
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+)
+
+var toyColumns = []string{"id", "created_at", "title", "desc", "details", "skills", "categories", "images", "recommended_age", "manufacturer", "value", "is_available", "wait_list", "language", "version", "updated_at", "supplier_sku", "manufacturer_id", "attributes", "rating", "rating_count"}
+
+func versionedToy(version int32) *data.Toy {
+	return &data.Toy{ID: 1, Title: "Lego", Skills: []string{"motor"}, Categories: []string{"STEM"}, RecommendedAge: "3+", Manufacturer: "Lego", Value: 5000, Language: "english", Version: version}
+}
+
+func TestUpdateToyIncrementsVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	expectTermLookup(mock, "categories", "stem", "STEM")
+	expectTermLookup(mock, "skills", "motor", "motor")
+	expectManufacturerLookup(mock, 4, "Lego")
+	mock.ExpectQuery(`UPDATE toys SET .+ version = version \+ 1, updated_at = now\(\) WHERE id = \$15 AND version = \$16 RETURNING version, updated_at`).
+		WithArgs("Lego", "", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "3+", "Lego", int64(5000), false, sqlmock.AnyArg(), "english", int64(4), sqlmock.AnyArg(), int64(1), int32(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "updated_at"}).AddRow(4, time.Now()))
+	expectTermLinks(mock, "toy_categories", "category_id")
+	expectTermLinks(mock, "toy_skills", "skill_id")
+
+	m := data.ToyModel{DB: db}
+	toy := versionedToy(3)
+
+	assert.NoError(t, m.Update(toy))
+	assert.Equal(t, int32(4), toy.Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateToyWithStaleVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Somebody else updated the toy since it was read, so no row matches the version.
+	expectTermLookup(mock, "categories", "stem", "STEM")
+	expectTermLookup(mock, "skills", "motor", "motor")
+	expectManufacturerLookup(mock, 4, "Lego")
+	mock.ExpectQuery(`UPDATE toys`).WillReturnError(sql.ErrNoRows)
+
+	m := data.ToyModel{DB: db}
+
+	err = m.Update(versionedToy(3))
+	assert.ErrorIs(t, err, data.ErrEditConflict)
+}
+
+func TestUpdateToyAuditedWithStaleVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT .+ FROM toys WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(toyColumns).AddRow(1, time.Now(), "Lego", "", pq.StringArray{}, pq.StringArray{"motor"}, pq.StringArray{"STEM"}, pq.StringArray{}, "3+", "Lego", 5000, true, pq.StringArray{}, "english", 4, time.Now(), "", 1, []byte("{}"), 0, 0))
+	mock.ExpectRollback()
+
+	m := data.ToyModel{DB: db}
+
+	err = m.UpdateAudited(versionedToy(3), audit.Actor{UserID: 2})
+	assert.ErrorIs(t, err, data.ErrEditConflict)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -7,7 +7,9 @@ import (
 	"log"
 	"path/filepath"
 	"testing"
+	"time"
 	_ "toy-rental-system/helpers"
+	"toy-rental-system/internal/audit"
 	"toy-rental-system/internal/config"
 	"toy-rental-system/internal/data"
 	"toy-rental-system/internal/domain/entity"
@@ -20,7 +22,7 @@ func TestDeleteToy(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	defer db.Close()
 
-	mock.ExpectExec("DELETE FROM toys WHERE id = ?").WithArgs(1).
+	mock.ExpectExec(`UPDATE toys SET deleted_at = now\(\)`).WithArgs(1).
 		WillReturnResult(sqlmock.NewResult(0, 1)) // Simulating one row affected
 
 	m := &data.ToyModel{DB: db}
@@ -92,6 +94,73 @@ func TestSaveSubscription(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestSaveSubscriptionAuditedDoesNotCreditTokens(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	repo := postgres.NewSubscriptionRepository(db)
+	subscription := &entity.Subscription{UserID: 7, Tokens: 10, Price: 5, Currency: "KZT", PaymentIntentID: "pi_1"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO subscriptions \(user_id, tokens, price, currency, payment_intent_id\) VALUES \(\$1, \$2, \$3, \$4, \$5\) RETURNING id`).
+		WithArgs(7, 10, 5, "KZT", "pi_1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(7, nil, "subscription.create", "subscription", "1", nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	err = repo.SaveAudited(subscription, audit.Actor{UserID: 7})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), subscription.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConfirmSubscriptionAuditedCreditsTokens(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	repo := postgres.NewSubscriptionRepository(db)
+	subscription := &entity.Subscription{ID: 1, UserID: 7, Tokens: 10, Price: 5, Currency: "KZT", PaymentIntentID: "pi_1"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE subscriptions SET confirmed_at = now\(\) WHERE id = \$1 AND confirmed_at IS NULL RETURNING confirmed_at`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"confirmed_at"}).AddRow(time.Now()))
+	mock.ExpectExec(`INSERT INTO token_ledger \(user_id, amount, reason, subscription_id\) VALUES \(\$1, \$2, \$3, \$4\)`).
+		WithArgs(7, 10, "subscription", 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE users SET tokens = tokens \+ \$2 WHERE id = \$1`).WithArgs(7, 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(7, nil, "subscription.confirm", "subscription", "1", nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	err = repo.ConfirmAudited(subscription, audit.Actor{UserID: 7})
+	assert.NoError(t, err)
+	assert.NotNil(t, subscription.ConfirmedAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConfirmSubscriptionAuditedTwice(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	repo := postgres.NewSubscriptionRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE subscriptions SET confirmed_at = now\(\)`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"confirmed_at"}))
+	mock.ExpectRollback()
+
+	err = repo.ConfirmAudited(&entity.Subscription{ID: 1, UserID: 7, Tokens: 10}, audit.Actor{UserID: 7})
+	assert.ErrorIs(t, err, postgres.ErrSubscriptionConfirmed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 type MockSubscriptionRepository struct {
 	mock.Mock
 	cfg config.Config
@@ -102,6 +171,91 @@ func (m *MockSubscriptionRepository) Save(sub *entity.Subscription) error {
 	return args.Error(0)
 }
 
+func (m *MockSubscriptionRepository) SaveAudited(sub *entity.Subscription, actor audit.Actor) error {
+	args := m.Called(sub, actor)
+	return args.Error(0)
+}
+
+func (m *MockSubscriptionRepository) Get(id int64) (*entity.Subscription, error) {
+	args := m.Called(id)
+	sub, _ := args.Get(0).(*entity.Subscription)
+	return sub, args.Error(1)
+}
+
+func (m *MockSubscriptionRepository) GetPlan(id int64) (*entity.SubscriptionPlan, error) {
+	args := m.Called(id)
+	plan, _ := args.Get(0).(*entity.SubscriptionPlan)
+	return plan, args.Error(1)
+}
+
+func (m *MockSubscriptionRepository) ConfirmAudited(sub *entity.Subscription, actor audit.Actor) error {
+	args := m.Called(sub, actor)
+	return args.Error(0)
+}
+
+// fakePayments is a payment gateway whose payments succeed once they're in paid.
+type fakePayments struct {
+	paid map[string]int64
+}
+
+func (p *fakePayments) CreatePayment(amount int64, currency string) (*service.Payment, error) {
+	return &service.Payment{ID: "pi_1", ClientSecret: "pi_1_secret", Amount: amount, Currency: currency}, nil
+}
+
+func (p *fakePayments) GetPayment(id string) (*service.Payment, error) {
+	amount, ok := p.paid[id]
+	return &service.Payment{ID: id, Amount: amount, Currency: "kzt", Succeeded: ok}, nil
+}
+
+func TestStartSubscriptionUsesPlanPrice(t *testing.T) {
+	repo := new(MockSubscriptionRepository)
+	subscriptions := service.NewSubscriptionService(config.Config{}, repo)
+	subscriptions.Payments = &fakePayments{}
+
+	repo.On("GetPlan", int64(2)).Return(&entity.SubscriptionPlan{ID: 2, Tokens: 20, Price: 1500, Currency: "KZT"}, nil)
+	repo.On("SaveAudited", mock.Anything, audit.Actor{UserID: 7}).Return(nil)
+
+	subscription, err := subscriptions.Start(7, 2, audit.Actor{UserID: 7})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), subscription.UserID)
+	assert.Equal(t, int64(20), subscription.Tokens)
+	assert.Equal(t, int64(1500), subscription.Price)
+	assert.Equal(t, "pi_1", subscription.PaymentIntentID)
+	assert.Equal(t, "pi_1_secret", subscription.ClientSecret)
+	assert.Nil(t, subscription.ConfirmedAt)
+	repo.AssertExpectations(t)
+}
+
+func TestConfirmSubscription(t *testing.T) {
+	repo := new(MockSubscriptionRepository)
+	payments := &fakePayments{paid: map[string]int64{}}
+	subscriptions := service.NewSubscriptionService(config.Config{}, repo)
+	subscriptions.Payments = payments
+
+	pending := &entity.Subscription{ID: 1, UserID: 7, Tokens: 20, Price: 1500, Currency: "KZT", PaymentIntentID: "pi_1"}
+	repo.On("Get", int64(1)).Return(pending, nil)
+
+	// Another user can't confirm the subscription.
+	_, err := subscriptions.Confirm(8, 1, audit.Actor{UserID: 8})
+	assert.ErrorIs(t, err, postgres.ErrSubscriptionNotFound)
+
+	// Neither an unpaid nor an underpaid subscription credits any tokens.
+	_, err = subscriptions.Confirm(7, 1, audit.Actor{UserID: 7})
+	assert.ErrorIs(t, err, service.ErrPaymentNotConfirmed)
+
+	payments.paid["pi_1"] = 1
+	_, err = subscriptions.Confirm(7, 1, audit.Actor{UserID: 7})
+	assert.ErrorIs(t, err, service.ErrPaymentNotConfirmed)
+	repo.AssertNotCalled(t, "ConfirmAudited", mock.Anything, mock.Anything)
+
+	payments.paid["pi_1"] = 1500
+	repo.On("ConfirmAudited", pending, audit.Actor{UserID: 7}).Return(nil)
+
+	_, err = subscriptions.Confirm(7, 1, audit.Actor{UserID: 7})
+	assert.NoError(t, err)
+	repo.AssertExpectations(t)
+}
+
 func TestProcessPayment(t *testing.T) {
 	s, err := filepath.Abs("toy-rental-system/tests")
 	if err != nil {
@@ -0,0 +1,255 @@
+package unit
+
+import (
+	"bytes"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+	"toy-rental-system/internal/audit"
+	"toy-rental-system/internal/data"
+	"toy-rental-system/internal/domain/entity"
+	"toy-rental-system/internal/repository"
+	"toy-rental-system/internal/repository/postgres"
+	"toy-rental-system/internal/service"
+	"toy-rental-system/pkg/jsonlog"
+)
+
+type fakeUserRepository struct {
+	users map[string]*entity.User
+
+	// tokenUsers maps a token scope to the user returned by GetForToken().
+	tokenUsers map[string]*entity.User
+}
+
+func (r *fakeUserRepository) Save(user *entity.User) error {
+	r.users[user.Username] = user
+	return nil
+}
+
+func (r *fakeUserRepository) FindByID(id int64) (*entity.User, error) {
+	for _, user := range r.users {
+		if int64(user.ID) == id {
+			return user, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (r *fakeUserRepository) UpdateProfile(user *entity.User) error {
+	for _, other := range r.users {
+		if other.ID != user.ID && user.Email != "" && other.Email == user.Email {
+			return repository.ErrDuplicateEmail
+		}
+	}
+	r.users[user.Username] = user
+	return nil
+}
+
+func (r *fakeUserRepository) FindByUsername(username string) (*entity.User, error) {
+	user, ok := r.users[username]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (r *fakeUserRepository) GetForToken(tokenScope, tokenPlaintext string) (*entity.User, error) {
+	user, ok := r.tokenUsers[tokenScope]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	return user, nil
+}
+
+type fakeTokenRepository struct{}
+
+func (fakeTokenRepository) New(userID int64, ttl time.Duration, scope string) (*data.Token, error) {
+	return &data.Token{Plaintext: "ABCDEFGHIJKLMNOPQRSTUVWXYZ", UserID: userID, Scope: scope}, nil
+}
+
+func (fakeTokenRepository) Insert(token *data.Token) error {
+	return nil
+}
+
+func (fakeTokenRepository) DeleteAllForUser(scope string, userID int64) error {
+	return nil
+}
+
+type fakeLoginAttemptRepository struct {
+	failures map[string]int
+	locks    map[string]time.Time
+	// unlockedBy is the actor of the last unlock.
+	unlockedBy audit.Actor
+}
+
+func (r *fakeLoginAttemptRepository) RecordFailure(subject string) (int, error) {
+	r.failures[subject]++
+	return r.failures[subject], nil
+}
+
+func (r *fakeLoginAttemptRepository) Lock(subject string, until time.Time) error {
+	r.locks[subject] = until
+	return nil
+}
+
+func (r *fakeLoginAttemptRepository) LockedUntil(subjects ...string) (time.Time, error) {
+	var lockedUntil time.Time
+	for _, subject := range subjects {
+		if until := r.locks[subject]; until.After(time.Now()) && until.After(lockedUntil) {
+			lockedUntil = until
+		}
+	}
+	return lockedUntil, nil
+}
+
+func (r *fakeLoginAttemptRepository) Reset(subject string) error {
+	delete(r.failures, subject)
+	delete(r.locks, subject)
+	return nil
+}
+
+func (r *fakeLoginAttemptRepository) ResetAudited(subject string, userID int64, actor audit.Actor) error {
+	r.unlockedBy = actor
+	return r.Reset(subject)
+}
+
+type fakeTwoFactorRepository struct {
+	users         map[string]*entity.User
+	lastStep      map[int64]int64
+	recoveryCodes map[int64][][]byte
+}
+
+func (r *fakeTwoFactorRepository) user(userID int64) *entity.User {
+	for _, user := range r.users {
+		if int64(user.ID) == userID {
+			return user
+		}
+	}
+	return nil
+}
+
+func (r *fakeTwoFactorRepository) SetTOTPSecret(userID int64, secret string) error {
+	r.user(userID).TOTPSecret = secret
+	return nil
+}
+
+func (r *fakeTwoFactorRepository) EnableTOTP(userID int64, recoveryCodeHashes [][]byte) error {
+	r.user(userID).TOTPEnabled = true
+	r.recoveryCodes[userID] = recoveryCodeHashes
+	return nil
+}
+
+func (r *fakeTwoFactorRepository) DisableTOTP(userID int64) error {
+	r.user(userID).TOTPSecret = ""
+	r.user(userID).TOTPEnabled = false
+	delete(r.recoveryCodes, userID)
+	return nil
+}
+
+func (r *fakeTwoFactorRepository) ReplaceRecoveryCodes(userID int64, recoveryCodeHashes [][]byte) error {
+	r.recoveryCodes[userID] = recoveryCodeHashes
+	return nil
+}
+
+func (r *fakeTwoFactorRepository) UseTOTPStep(userID int64, step int64) (bool, error) {
+	if r.lastStep[userID] >= step {
+		return false, nil
+	}
+	r.lastStep[userID] = step
+	return true, nil
+}
+
+func (r *fakeTwoFactorRepository) UseRecoveryCode(userID int64, hash []byte) (bool, error) {
+	for i, h := range r.recoveryCodes[userID] {
+		if bytes.Equal(h, hash) {
+			r.recoveryCodes[userID] = append(r.recoveryCodes[userID][:i], r.recoveryCodes[userID][i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func newTestUserService() (service.UserService, *fakeLoginAttemptRepository) {
+	return newTestUserServiceWithUsers(&fakeUserRepository{
+		users: map[string]*entity.User{
+			"aigerim": {ID: 1, Username: "aigerim", Password: "correct horse"},
+		},
+	})
+}
+
+func newTestUserServiceWithUsers(users *fakeUserRepository) (service.UserService, *fakeLoginAttemptRepository) {
+	attempts := &fakeLoginAttemptRepository{failures: map[string]int{}, locks: map[string]time.Time{}}
+	logger := jsonlog.New(io.Discard, jsonlog.LevelInfo)
+
+	twoFactor := &fakeTwoFactorRepository{users: users.users, lastStep: map[int64]int64{}, recoveryCodes: map[int64][][]byte{}}
+
+	return service.NewUserService(users, fakeTokenRepository{}, attempts, twoFactor, logger), attempts
+}
+
+func TestLoginLockout(t *testing.T) {
+	users, attempts := newTestUserService()
+
+	for i := 0; i < 5; i++ {
+		_, err := users.Login("aigerim", "wrong", "10.0.0.1")
+		assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+	}
+	assert.Contains(t, attempts.locks, "username:aigerim")
+
+	// The correct password is refused while the account is locked, with the same error
+	// as a wrong one.
+	_, err := users.Login("aigerim", "correct horse", "10.0.0.2")
+	assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+
+	err = users.Unlock("aigerim", audit.Actor{UserID: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), attempts.unlockedBy.UserID)
+
+	result, err := users.Login("aigerim", "correct horse", "10.0.0.2")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, result.Token)
+	assert.Equal(t, data.ScopeAuthentication, result.Scope)
+}
+
+func TestUnlockUnknownUser(t *testing.T) {
+	users, _ := newTestUserService()
+
+	err := users.Unlock("nobody", audit.Actor{UserID: 2})
+	assert.ErrorIs(t, err, repository.ErrUserNotFound)
+}
+
+func TestResetAuditedRecordsUnlock(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM login_failures WHERE subject = \$1`).WithArgs("username:aigerim").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(2, nil, "user.unlock", "user", "7", nil, nil, sqlmock.AnyArg(), "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectCommit()
+
+	attempts := postgres.NewLoginAttemptRepository(db)
+	err = attempts.ResetAudited("username:aigerim", 7, audit.Actor{UserID: 2})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginLockoutIsExponential(t *testing.T) {
+	users, attempts := newTestUserService()
+
+	for i := 0; i < 5; i++ {
+		users.Login("aigerim", "wrong", "10.0.0.1")
+	}
+	first := time.Until(attempts.locks["username:aigerim"])
+
+	// Pretend the first lockout has expired and fail once more.
+	attempts.locks["username:aigerim"] = time.Now().Add(-time.Second)
+	users.Login("aigerim", "wrong", "10.0.0.1")
+	second := time.Until(attempts.locks["username:aigerim"])
+
+	assert.InDelta(t, 2*first.Seconds(), second.Seconds(), 1)
+}